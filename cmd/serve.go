@@ -1,10 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"path/filepath"
+
+	"github.com/vincenty1ung/vincenty1ung.github.io/scripts"
 )
 
 func main() {
@@ -14,17 +15,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Serve files from the current directory
-	fs := http.FileServer(http.Dir(cwd))
-	http.Handle("/", fs)
-
-	port := "3001"
-	fmt.Printf("Starting local server at http://localhost:%s\n", port)
-	fmt.Printf("Serving files from: %s\n", cwd)
-	fmt.Println("Press Ctrl+C to stop")
-
-	err = http.ListenAndServe(":"+port, nil)
-	if err != nil {
+	// Serve files from the current directory, with a local storage build on top when present
+	distDir := filepath.Join(cwd, scripts.DefaultLocalDir)
+	if _, err := os.Stat(distDir); err != nil {
+		distDir = ""
+	}
+	if err := scripts.Serve(cwd, scripts.DefaultServePort, distDir); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/vincenty1ung/vincenty1ung.github.io/scripts"
 )
 
 func main() {
-	scripts.UpdatePhotosHandler()
+	os.Exit(scripts.Run(os.Args[1:]))
 }
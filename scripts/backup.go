@@ -0,0 +1,271 @@
+package scripts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat names a backup of photos.json after the UTC time it was replaced
+const backupTimeFormat = "20060102T150405.000Z"
+
+// BackupConfig controls the copies of photos.json kept before it is replaced
+type BackupConfig struct {
+	Dir        string `yaml:"dir"`          // Relative to root_dir
+	Keep       int    `yaml:"keep"`         // Newest backups kept, 0 keeps all
+	MaxAgeDays int    `yaml:"max_age_days"` // Older backups are deleted, 0 keeps them
+}
+
+// DefaultBackupConfig returns the default backup retention
+func DefaultBackupConfig() BackupConfig {
+	return BackupConfig{Dir: ".photos-backups", Keep: 20, MaxAgeDays: 90}
+}
+
+// BackupDir returns the absolute backups directory
+func (c *Config) BackupDir(rootDir string) string {
+	if filepath.IsAbs(c.Backup.Dir) {
+		return c.Backup.Dir
+	}
+	return filepath.Join(rootDir, c.Backup.Dir)
+}
+
+// Backup is one saved version of photos.json
+type Backup struct {
+	Name string    `json:"name"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the same directory,
+// so that readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// backupName returns the file name of the backup of photos.json replaced at t
+func (p *PhotoProcessor) backupName(t time.Time) string {
+	base := filepath.Base(p.Config.OutputFile)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// Backups lists the backups of photos.json, oldest first
+func (p *PhotoProcessor) Backups() ([]Backup, error) {
+	dir := p.Config.BackupDir(p.RootDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	base := filepath.Base(p.Config.OutputFile)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext) + "."
+	var backups []Backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, stem) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, stem), ext))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Name: name, Path: filepath.Join(dir, name), Time: t, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// BackupPhotosJSON saves content, the photos.json about to be replaced at now, and applies the retention
+func (p *PhotoProcessor) BackupPhotosJSON(content []byte, now time.Time) (Backup, error) {
+	dir, t := p.Config.BackupDir(p.RootDir), now
+	path := filepath.Join(dir, p.backupName(t))
+	// Two replacements within a millisecond, e.g. a rollback right after a sync, keep both backups
+	for _, err := os.Stat(path); err == nil; _, err = os.Stat(path) {
+		t = t.Add(time.Millisecond)
+		path = filepath.Join(dir, p.backupName(t))
+	}
+	if err := writeFileAtomic(path, content, 0644); err != nil {
+		return Backup{}, fmt.Errorf("failed to write backup: %w", err)
+	}
+	fmt.Printf("✓ Backup created: %s\n", path)
+
+	if _, err := p.PruneBackups(now); err != nil {
+		fmt.Printf("⚠ Warning: %v\n", err)
+	}
+	return Backup{Name: filepath.Base(path), Path: path, Time: t.UTC(), Size: int64(len(content))}, nil
+}
+
+// PruneBackups deletes the backups beyond backup.keep or older than backup.max_age_days
+// and returns the number removed
+func (p *PhotoProcessor) PruneBackups(now time.Time) (int, error) {
+	backups, err := p.Backups()
+	if err != nil {
+		return 0, err
+	}
+
+	keep, maxAge := p.Config.Backup.Keep, time.Duration(p.Config.Backup.MaxAgeDays)*24*time.Hour
+	removed := 0
+	for i, backup := range backups {
+		tooMany := keep > 0 && i < len(backups)-keep
+		tooOld := maxAge > 0 && now.Sub(backup.Time) > maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(backup.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove backup %s: %w", backup.Name, err)
+		}
+		removed++
+	}
+	if removed > 0 {
+		fmt.Printf("🟢 Removed %d old backups of %s\n", removed, p.Config.OutputFile)
+	}
+	return removed, nil
+}
+
+// Rollback replaces photos.json with a backup, saving the current content as a new backup first,
+// and uploads it to storage when upload is set. Before anything is replaced, objects the backup references
+// that a prune has since moved to the trash are restored; when others are gone it refuses, unless Force is set.
+func (p *PhotoProcessor) Rollback(ctx context.Context, backup Backup, upload bool) error {
+	content, err := os.ReadFile(backup.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	var albums []YearAlbum
+	if err := json.Unmarshal(content, &albums); err != nil {
+		return fmt.Errorf("backup %s is not a valid photos.json: %w", backup.Name, err)
+	}
+	if upload && p.Storage != nil {
+		if err := p.restoreReferenced(ctx, backup, albums); err != nil {
+			return err
+		}
+	}
+
+	outputFilePath := p.OutputFilePath()
+	current, err := os.ReadFile(outputFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", p.Config.OutputFile, err)
+	}
+	if JSONEqual(current, content) {
+		fmt.Printf("✓ %s already matches %s\n", p.Config.OutputFile, backup.Name)
+	} else {
+		if len(current) > 0 {
+			if _, err := p.BackupPhotosJSON(current, time.Now()); err != nil {
+				return err
+			}
+		}
+		if err := writeFileAtomic(outputFilePath, content, 0644); err != nil {
+			return fmt.Errorf("error writing output file: %w", err)
+		}
+		fmt.Printf("✓ Rolled %s back to %s\n", p.Config.OutputFile, backup.Name)
+	}
+
+	if !upload || p.Storage == nil {
+		return nil
+	}
+	// A rollback replaces whatever is stored, but only the copy it looked at
+	storedETag, err := p.storedPhotosJSON(ctx)
+	if err != nil {
+		return err
+	}
+	return p.UploadPhotosJSON(ctx, content, storedETag)
+}
+
+// restoreReferenced makes sure the objects referenced by the photos of a backup are stored. Missing objects
+// are copied back from the newest trash batch that holds them; it fails with the keys that remain missing,
+// unless Force is set.
+func (p *PhotoProcessor) restoreReferenced(ctx context.Context, backup Backup, albums []YearAlbum) error {
+	var missing []string
+	for _, album := range albums {
+		for _, photo := range album.Photos {
+			for _, key := range p.photoKeys(photo) {
+				_, err := p.Storage.Head(ctx, key)
+				if errors.Is(err, ErrNotFound) {
+					missing = append(missing, key)
+				} else if err != nil {
+					return fmt.Errorf("failed to check %s: %w", key, err)
+				}
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	batches, err := p.TrashBatches(ctx)
+	if err != nil {
+		return err
+	}
+	trashed := make(map[string]bool)
+	for _, batch := range batches {
+		for _, key := range batch.Keys {
+			trashed[key] = true
+		}
+	}
+	var lost []string
+	for _, key := range missing {
+		restored := false
+		for i := len(batches) - 1; i >= 0 && !restored; i-- {
+			trashKey := p.trashKey(batches[i].Name, key)
+			if !trashed[trashKey] {
+				continue
+			}
+			if _, err := p.Storage.Copy(ctx, trashKey, key); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", key, err)
+			}
+			fmt.Printf("✓ Restored %s from trash batch %s\n", key, batches[i].Name)
+			restored = true
+		}
+		if !restored {
+			lost = append(lost, key)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+
+	err = fmt.Errorf(
+		"backup %s references %d objects that are no longer stored: %s", backup.Name, len(lost), strings.Join(lost, ", "),
+	)
+	if p.Force {
+		fmt.Printf("⚠ %v, continuing because of -force\n", err)
+		return nil
+	}
+	return fmt.Errorf("%w; rerun with -force to roll back anyway", err)
+}
@@ -0,0 +1,156 @@
+package scripts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPruneBackups tests the retention of photos.json backups by count and age
+func TestPruneBackups(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ages := []int{100, 40, 20, 3, 1} // Days before now, oldest first
+
+	tests := []struct {
+		name       string
+		keep       int
+		maxAgeDays int
+		remaining  []int
+	}{
+		{"Keep everything", 0, 0, ages},
+		{"Keep the newest", 2, 0, []int{3, 1}},
+		{"Drop the old", 0, 30, []int{20, 3, 1}},
+		{"Count and age", 4, 30, []int{20, 3, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Backup.Keep, cfg.Backup.MaxAgeDays = tt.keep, tt.maxAgeDays
+			p := &PhotoProcessor{Config: cfg, RootDir: t.TempDir()}
+			for _, days := range ages {
+				path := filepath.Join(cfg.BackupDir(p.RootDir), p.backupName(now.AddDate(0, 0, -days)))
+				assert.NoError(t, writeFileAtomic(path, []byte("[]"), 0644))
+			}
+
+			_, err := p.PruneBackups(now)
+			assert.NoError(t, err)
+			backups, err := p.Backups()
+			assert.NoError(t, err)
+			var remaining []int
+			for _, backup := range backups {
+				remaining = append(remaining, int(now.Sub(backup.Time).Hours()/24))
+			}
+			assert.Equal(t, tt.remaining, remaining)
+		})
+	}
+}
+
+// TestRollback tests that replacing photos.json keeps a backup that rollback restores locally and in storage
+func TestRollback(t *testing.T) {
+	storage := NewMemoryStorage("")
+	p := &PhotoProcessor{Config: DefaultConfig(), RootDir: t.TempDir(), Storage: storage}
+	first := []YearAlbum{{Year: "2024", Photos: []Photo{{Filename: "DSC_2024-01-01_a.jpg"}}}}
+	second := []YearAlbum{{Year: "2025", Photos: []Photo{{Filename: "DSC_2025-01-01_b.jpg"}}}}
+	for _, key := range p.photoKeys(first[0].Photos[0]) {
+		_, err := putBytes(t.Context(), storage, key, []byte(key), PutOptions{})
+		assert.NoError(t, err)
+	}
+
+	_, err := p.Publish(t.Context(), first, nil, true)
+	assert.NoError(t, err)
+	firstContent, err := os.ReadFile(p.OutputFilePath())
+	assert.NoError(t, err)
+	backups, err := p.Backups()
+	assert.NoError(t, err)
+	assert.Empty(t, backups, "there is nothing to back up on the first write")
+
+	// Unchanged output is neither written nor backed up
+	outcome, err := p.Publish(t.Context(), first, firstContent, true)
+	assert.NoError(t, err)
+	assert.Equal(t, PublishUnchanged, outcome)
+	backups, err = p.Backups()
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+
+	_, err = p.Publish(t.Context(), second, firstContent, true)
+	assert.NoError(t, err)
+	backups, err = p.Backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	secondContent, err := os.ReadFile(p.OutputFilePath())
+	assert.NoError(t, err)
+	assert.False(t, JSONEqual(firstContent, secondContent))
+
+	assert.NoError(t, p.Rollback(t.Context(), backups[0], true))
+	content, err := os.ReadFile(p.OutputFilePath())
+	assert.NoError(t, err)
+	assert.Equal(t, firstContent, content)
+	uploaded, _, ok := storage.Get(p.photosJSONKey())
+	assert.True(t, ok)
+	assert.Equal(t, firstContent, uploaded)
+
+	// The replaced content is backed up too, so the rollback can be undone
+	backups, err = p.Backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 2)
+	undo, err := os.ReadFile(backups[1].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, secondContent, undo)
+
+	entries, err := os.ReadDir(filepath.Dir(p.OutputFilePath()))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary or backup files are left next to photos.json")
+}
+
+// TestRollbackMissingObjects tests that a rollback restores pruned objects from the trash and refuses
+// when objects are gone, unless forced
+func TestRollbackMissingObjects(t *testing.T) {
+	storage := NewMemoryStorage("")
+	p := &PhotoProcessor{Config: DefaultConfig(), RootDir: t.TempDir(), Storage: storage}
+	trashed := Photo{Filename: "DSC_2024-01-01_a.jpg", Hash: "aaaaaaaa11111111"}
+	deleted := Photo{Filename: "DSC_2024-01-02_b.jpg", Hash: "bbbbbbbb22222222"}
+	first := []YearAlbum{{Year: "2024", Photos: []Photo{trashed, deleted}}}
+
+	for _, photo := range []Photo{trashed, deleted} {
+		for _, key := range p.photoKeys(photo) {
+			_, err := putBytes(t.Context(), storage, key, []byte(key), PutOptions{})
+			assert.NoError(t, err)
+		}
+	}
+	_, err := p.Publish(t.Context(), first, nil, true)
+	assert.NoError(t, err)
+	firstContent, err := os.ReadFile(p.OutputFilePath())
+	assert.NoError(t, err)
+	_, err = p.Publish(t.Context(), []YearAlbum{}, firstContent, true)
+	assert.NoError(t, err)
+	secondContent, err := os.ReadFile(p.OutputFilePath())
+	assert.NoError(t, err)
+	backups, err := p.Backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+
+	// A prune moved the objects of one photo to the trash and deleted those of the other
+	assert.NoError(t, p.moveToTrash(t.Context(), "20250101T000000Z", p.photoKeys(trashed)))
+	assert.NoError(t, storage.DeleteMany(t.Context(), p.photoKeys(deleted)))
+
+	err = p.Rollback(t.Context(), backups[0], true)
+	assert.ErrorContains(t, err, p.originalKey(deleted.Filename, deleted.Hash))
+	assert.NotContains(t, err.Error(), p.originalKey(trashed.Filename, trashed.Hash))
+	content, err := os.ReadFile(p.OutputFilePath())
+	assert.NoError(t, err)
+	assert.Equal(t, secondContent, content, "a refused rollback leaves photos.json unchanged")
+	for _, key := range p.photoKeys(trashed) {
+		_, err := storage.Head(t.Context(), key)
+		assert.NoError(t, err, "%s is restored from the trash", key)
+	}
+
+	p.Force = true
+	assert.NoError(t, p.Rollback(t.Context(), backups[0], true))
+	uploaded, _, ok := storage.Get(p.photosJSONKey())
+	assert.True(t, ok)
+	assert.Equal(t, firstContent, uploaded)
+}
@@ -0,0 +1,190 @@
+package scripts
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes returned by Run, so that individual stages can be scripted
+const (
+	ExitOK      = 0 // Command completed successfully
+	ExitError   = 1 // Command failed
+	ExitUsage   = 2 // Invalid command line
+	ExitPartial = 3 // Command completed, but some items failed or did not verify
+
+	ExitInterrupted = 130 // Command was stopped by SIGINT or SIGTERM; finished work was saved
+)
+
+// command is a single subcommand of the photo tool
+type command struct {
+	name  string
+	short string
+	run   func(args []string) int
+}
+
+// commands returns the command tree in the order shown by help
+func commands() []command {
+	return []command{
+		{"sync", "Scan, upload, prune and publish in one run (default)", runSync},
+		{"scan", "List photos and whether they are new, changed or unchanged", runScan},
+		{"thumbs", "Generate WebP thumbnails locally or upload them to R2", runThumbs},
+		{"exif", "Print the extracted EXIF and XMP data of photos as JSON", runExif},
+		{"publish", "Upload the local photos.json to R2", runPublish},
+		{"prune", "Delete stored originals and thumbnails that no local photo references", runPrune},
+		{"restore", "List the trash or move pruned objects back", runRestore},
+		{"diff", "Compare two versions of photos.json photo by photo", runDiff},
+		{"rollback", "List the backups of photos.json or restore one locally and in R2", runRollback},
+		{"verify", "Check that every photo in photos.json exists in R2", runVerify},
+		{"reconcile", "Compare the local state database with storage and mark missing uploads", runReconcile},
+		{"serve", "Serve the site from a local HTTP server", runServe},
+		{"config", "Print or validate the effective configuration", runConfig},
+	}
+}
+
+// Run executes the command line and returns the process exit code.
+// Without arguments it performs a full sync, as main.go always did.
+func Run(args []string) int {
+	if len(args) == 0 {
+		return runSync(nil)
+	}
+
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return ExitOK
+	}
+
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return ExitUsage
+}
+
+// printUsage prints the list of available commands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: go run main.go <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run '<command> -h' for the flags of a command.")
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go run main.go %s %s\n\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args and returns the exit code to use when parsing failed
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK, false
+		}
+		return ExitUsage, false
+	}
+	return ExitOK, true
+}
+
+// setupProcessor creates a PhotoProcessor and loads the existing photos.json
+func setupProcessor(cfg *Config) (*PhotoProcessor, []byte, error) {
+	processor, err := NewPhotoProcessor(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error initializing processor: %w", err)
+	}
+
+	existingContent, err := processor.LoadExistingMetadata()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load existing metadata: %v\n", err)
+	}
+	return processor, existingContent, nil
+}
+
+// reportRetries prints the storage calls that were retried or failed permanently during a command
+func reportRetries(processor *PhotoProcessor) {
+	if retrying, ok := processor.Storage.(*RetryingStorage); ok {
+		PrintRetrySummary(retrying.Summary())
+	}
+}
+
+// confirmOnTerminal asks a yes or no question on the terminal, defaulting to no.
+// Without a terminal, e.g. in CI, nothing is asked and the answer is yes; the prune limits still apply.
+func confirmOnTerminal(ctx context.Context, prompt string) bool {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return true
+	}
+
+	fmt.Printf("%s [y/N] ", prompt)
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer <- line
+	}()
+	select {
+	case line := <-answer:
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	case <-ctx.Done():
+		fmt.Println()
+		return false
+	}
+}
+
+// requireStorage fails commands that cannot do anything without a storage backend
+func requireStorage(processor *PhotoProcessor, name string) bool {
+	if processor.Storage == nil {
+		fmt.Fprintf(os.Stderr, "%s: %s storage is not configured\n", name, processor.Config.Storage.Backend)
+		return false
+	}
+	return true
+}
+
+// selectJobs scans the image directory, keeping only the given files when any are passed
+func selectJobs(processor *PhotoProcessor, files []string) ([]Job, error) {
+	jobs, err := processor.ScanJobs()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return jobs, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, f := range files {
+		wanted[f] = true
+	}
+
+	var selected []Job
+	for _, job := range jobs {
+		relPath, _ := filepath.Rel(processor.RootDir, job.Path)
+		if wanted[job.Path] || wanted[relPath] || wanted[filepath.Base(job.Path)] {
+			selected = append(selected, job)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("none of the given files were found in %s", processor.ImgDirPath)
+	}
+	return selected, nil
+}
@@ -0,0 +1,1089 @@
+package scripts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runSync runs the full pipeline: scan, process and upload, prune, write and publish photos.json
+func runSync(args []string) int {
+	fs := newFlagSet("sync", "[flags]")
+	cf := addConfigFlags(fs)
+	workers := fs.Int("workers", MaxConcurrency, "number of concurrent workers (overrides max_concurrency)")
+	noPrune := fs.Bool("no-prune", false, "keep stored objects that photos.json no longer references")
+	noPublish := fs.Bool("no-publish", false, "write photos.json locally but do not upload it to R2")
+	dryRun := fs.Bool("dry-run", false, "print what would change without uploading, deleting or writing anything")
+	planJSON := fs.String("plan-json", "", "write the dry-run plan as JSON to this file (implies -dry-run)")
+	reconcile := fs.Bool("reconcile", false, "compare the state database with storage first and upload what is missing")
+	rehash := fs.Bool("rehash", false, "hash every file instead of trusting unchanged size and modification time")
+	force := fs.Bool("force", false, "prune or drop photos even when more would be removed than prune.max_delete_* allow")
+	yes := fs.Bool("yes", false, "prune without asking for confirmation")
+	overwrite := fs.Bool("overwrite", false, "publish photos.json even when the stored copy was changed elsewhere")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *planJSON != "" {
+		*dryRun = true
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if flagWasSet(fs, "workers") {
+		cfg.MaxConcurrency = *workers
+		cfg.SetFromFlag("max_concurrency", "workers")
+	}
+
+	processor, existingContent, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	processor.DryRun = *dryRun
+	processor.Rehash = *rehash
+	processor.Force = *force
+	processor.Overwrite = *overwrite
+	if !*yes {
+		processor.Confirm = confirmOnTerminal
+	}
+
+	ctx, stopping, stop := drainOnSignal(context.Background())
+	defer stop()
+	processor.Stopping = stopping
+
+	jobs, err := processor.ScanJobs()
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	if *reconcile {
+		report, err := processor.Reconcile(ctx)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return ExitError
+		}
+		PrintReconcileReport(report)
+	}
+
+	allPhotos, failures := processor.ProcessAll(ctx, jobs)
+	newAlbums := BuildAlbums(allPhotos)
+	interrupted := processor.Interrupted(ctx)
+
+	if *dryRun {
+		if interrupted {
+			fmt.Println("⚠ Interrupted, the plan is incomplete and was not printed")
+			return ExitInterrupted
+		}
+		return reportPlan(ctx, processor, allPhotos, newAlbums, existingContent, *planJSON, failures)
+	}
+
+	// A failed mount or a wrong img_dir looks like a mass deletion; keep storage, photos.json and the state
+	if err := processor.CheckRemovals(jobs, allPhotos); err != nil {
+		return refuseDeletion(err)
+	}
+
+	// Identify deleted photos; photos that were not processed would look removed, so an interrupted run keeps everything
+	if interrupted {
+		fmt.Println("⚠ Interrupted, skipping the prune")
+	} else if !*noPrune {
+		keys, stored, err := processor.OrphanKeys(ctx, allPhotos)
+		if err == nil {
+			err = processor.DeleteOrphans(ctx, keys, stored)
+		}
+		if errors.Is(err, ErrDeletionLimit) || errors.Is(err, ErrDeletionDeclined) {
+			return refuseDeletion(err)
+		}
+		if err == nil {
+			_, err = processor.PurgeTrash(ctx, time.Now())
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// Record the uploads even when photos.json cannot be written, so they are not repeated
+	if err := processor.SaveState(jobs); err != nil {
+		fmt.Printf("⚠ Failed to save state: %v\n", err)
+	}
+
+	if ctx.Err() != nil {
+		// The state database holds the finished uploads; the next sync publishes them
+		fmt.Println("⚠ Aborted, photos.json was left unchanged")
+		PrintFailureReport(failures)
+		return ExitInterrupted
+	}
+
+	outcome, err := processor.Publish(ctx, newAlbums, existingContent, !*noPublish)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		if errors.Is(err, ErrRemoteChanged) {
+			fmt.Println("photos.json was left unchanged; check who published it, or rerun with -overwrite")
+		}
+		return ExitError
+	}
+
+	fmt.Printf("Successfully updated photos.json with %d photos (%s).\n", len(allPhotos), outcome)
+	if interrupted {
+		fmt.Println("⚠ Interrupted, photos that were not processed kept their previous entries")
+		PrintFailureReport(failures)
+		return ExitInterrupted
+	}
+	if len(failures) > 0 {
+		PrintFailureReport(failures)
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+// refuseDeletion reports a sync that stopped before removing anything and returns its exit code
+func refuseDeletion(err error) int {
+	fmt.Printf("❌ %v\n", err)
+	fmt.Println("Nothing was deleted and photos.json was left unchanged; check img_dir, or rerun with -force")
+	return ExitError
+}
+
+// reportPlan prints the dry-run plan and optionally writes it as JSON
+func reportPlan(
+	ctx context.Context, processor *PhotoProcessor, allPhotos []Photo, newAlbums []YearAlbum, existingContent []byte, planJSON string,
+	failures []PhotoFailure,
+) int {
+	plan, err := processor.BuildSyncPlan(ctx, allPhotos, newAlbums, existingContent)
+	if plan != nil && len(failures) > 0 {
+		plan.Failed = failures
+	}
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+
+	fmt.Println()
+	plan.PrintText(os.Stdout, useColor(os.Stdout))
+
+	if planJSON != "" {
+		if err := plan.WriteJSON(planJSON); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("✓ Plan written to %s\n", planJSON)
+	}
+
+	if len(failures) > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+// runScan lists all photos and their status relative to photos.json without uploading anything
+func runScan(args []string) int {
+	fs := newFlagSet("scan", "[flags]")
+	cf := addConfigFlags(fs)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	rehash := fs.Bool("rehash", false, "hash every file instead of trusting unchanged size and modification time")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	processor.Rehash = *rehash
+
+	jobs, err := processor.ScanJobs()
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	type scanEntry struct {
+		Path   string      `json:"path"`
+		Year   string      `json:"year"`
+		Status PhotoStatus `json:"status"`
+		Hash   string      `json:"hash,omitempty"`
+		Error  string      `json:"error,omitempty"`
+	}
+
+	exitCode := ExitOK
+	counts := make(map[PhotoStatus]int)
+	var entries []scanEntry
+	for _, job := range jobs {
+		relPath, _ := filepath.Rel(processor.RootDir, job.Path)
+		entry := scanEntry{Path: relPath, Year: job.YearDir}
+		status, hash, err := processor.Classify(job.Path)
+		if err != nil {
+			entry.Error = err.Error()
+			exitCode = ExitPartial
+		} else {
+			entry.Status = status
+			entry.Hash = hash
+			counts[status]++
+		}
+		entries = append(entries, entry)
+	}
+
+	if *asJSON {
+		if err := printJSON(entries); err != nil {
+			fmt.Println(err)
+			return ExitError
+		}
+		return exitCode
+	}
+
+	for _, entry := range entries {
+		if entry.Error != "" {
+			fmt.Printf("%-9s %s: %s\n", "error", entry.Path, entry.Error)
+			continue
+		}
+		fmt.Printf("%-9s %s\n", entry.Status, entry.Path)
+	}
+	fmt.Printf(
+		"%d photos: %d new, %d changed, %d unchanged\n",
+		len(entries), counts[PhotoNew], counts[PhotoChanged], counts[PhotoUnchanged],
+	)
+	return exitCode
+}
+
+// runThumbs generates thumbnails for all or selected photos
+func runThumbs(args []string) int {
+	fs := newFlagSet("thumbs", "[flags] [files...]")
+	cf := addConfigFlags(fs)
+	defaults := DefaultThumbnailConfig()
+	outDir := fs.String("out", "thumbnails", "directory to write thumbnails to")
+	width := fs.Int("width", defaults.MaxWidth, "maximum thumbnail width in pixels (overrides thumbnail.max_width)")
+	quality := fs.Int("quality", defaults.Quality, "WebP quality, 1-100 (overrides thumbnail.quality)")
+	upload := fs.Bool("upload", false, "upload thumbnails to storage instead of writing them locally")
+	renditions := fs.Bool("renditions", false, "also generate the responsive renditions in thumbnail.widths and thumbnail.formats")
+	format := fs.String("format", FormatWebP, "thumbnail format: webp, avif or jpeg")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if flagWasSet(fs, "width") {
+		cfg.Thumbnail.MaxWidth = *width
+		cfg.SetFromFlag("thumbnail.max_width", "width")
+	}
+	if flagWasSet(fs, "quality") {
+		cfg.Thumbnail.Quality = *quality
+		cfg.SetFromFlag("thumbnail.quality", "quality")
+	}
+	if FormatExt(*format) == "" {
+		fmt.Fprintf(os.Stderr, "thumbs: unknown format %q\n", *format)
+		return ExitUsage
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	if *upload && !requireStorage(processor, "thumbs") {
+		return ExitError
+	}
+
+	jobs, err := selectJobs(processor, fs.Args())
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	if !*upload {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			fmt.Printf("Error creating %s: %v\n", *outDir, err)
+			return ExitError
+		}
+	}
+
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+
+	exitCode := ExitOK
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			fmt.Println("⚠ Interrupted")
+			return ExitInterrupted
+		}
+		filename := filepath.Base(job.Path)
+		filenameNoExt := strings.TrimSuffix(filename, filepath.Ext(filename))
+		// Extraction errors leave the orientation at normal, as in the sync pipeline
+		exifData, _, _, _, _ := GetExifExtractor().Extract(ctx, job.Path)
+		orientation := ExifOrientation(exifData)
+		data, err := GenerateThumbnailFormat(ctx, job.Path, cfg.Thumbnail, *format, orientation)
+		if err != nil {
+			fmt.Printf("❌ Failed to generate thumbnail for %s: %v\n", filename, err)
+			exitCode = ExitPartial
+			continue
+		}
+		// The thumbnail key follows the decoded image, as GenerateRenditions does, not the EXIF size
+		width, height, err := imageSize(job.Path)
+		if err != nil {
+			fmt.Printf("❌ Failed to generate thumbnail for %s: %v\n", filename, err)
+			exitCode = ExitPartial
+			continue
+		}
+		width, _ = OrientedSize(width, height, orientation)
+
+		// Thumbnail first, then the responsive renditions when requested
+		type output struct {
+			key, name, contentType string
+			data                   []byte
+		}
+		hash, err := processor.fileHash(job.Path)
+		if err != nil {
+			fmt.Printf("❌ Failed to hash %s: %v\n", filename, err)
+			exitCode = ExitPartial
+			continue
+		}
+		thumbnailKey := processor.renditionKey(filename, hash, ThumbnailWidth(width, cfg.Thumbnail), *format)
+		outputs := []output{{thumbnailKey, filenameNoExt + FormatExt(*format), FormatContentType(*format), data}}
+		if *renditions {
+			list, err := GenerateRenditions(ctx, job.Path, cfg.Thumbnail, orientation)
+			if err != nil {
+				fmt.Printf("❌ Failed to generate renditions for %s: %v\n", filename, err)
+				exitCode = ExitPartial
+				continue
+			}
+			for _, r := range list {
+				key := processor.renditionKey(filename, hash, r.Width, r.Format)
+				if *upload && key == thumbnailKey {
+					// The thumbnail is one of the renditions
+					continue
+				}
+				outputs = append(
+					outputs, output{
+						key:         key,
+						name:        fmt.Sprintf("%s-%dw%s", filenameNoExt, r.Width, FormatExt(r.Format)),
+						contentType: FormatContentType(r.Format),
+						data:        r.Data,
+					},
+				)
+			}
+		}
+
+		for _, out := range outputs {
+			if *upload {
+				if _, err := putBytes(
+					ctx, processor.Storage, out.key, out.data,
+					PutOptions{ContentType: out.contentType, CacheControl: ImmutableCacheControl},
+				); err != nil {
+					fmt.Printf("❌ Failed to upload %s: %v\n", out.key, err)
+					exitCode = ExitPartial
+					continue
+				}
+				fmt.Printf("✓ %s -> %s\n", filename, out.key)
+				continue
+			}
+
+			target := filepath.Join(*outDir, out.name)
+			if err := os.WriteFile(target, out.data, 0644); err != nil {
+				fmt.Printf("❌ Failed to write %s: %v\n", target, err)
+				exitCode = ExitPartial
+				continue
+			}
+			fmt.Printf("✓ %s -> %s\n", filename, target)
+		}
+	}
+	return exitCode
+}
+
+// runExif prints the EXIF and XMP data of all or selected photos
+func runExif(args []string) int {
+	fs := newFlagSet("exif", "[flags] [files...]")
+	cf := addConfigFlags(fs)
+	extractor := fs.String("extractor", "", "EXIF extractor to use, go-exif or exiftool (overrides exif_extractor)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if flagWasSet(fs, "extractor") {
+		cfg.ExifExtractor = *extractor
+		cfg.SetFromFlag("exif_extractor", "extractor")
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	jobs, err := selectJobs(processor, fs.Args())
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	type exifEntry struct {
+		Filename     string                 `json:"filename"`
+		Width        int                    `json:"width,omitempty"`
+		Height       int                    `json:"height,omitempty"`
+		DateTaken    string                 `json:"dateTaken,omitempty"`
+		Taken        string                 `json:"taken,omitempty"`
+		OffsetSource string                 `json:"offsetSource,omitempty"`
+		Exif         map[string]interface{} `json:"exif,omitempty"`
+		Metadata     *PhotoMetadata         `json:"metadata,omitempty"`
+		Error        string                 `json:"error,omitempty"`
+	}
+
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+
+	exitCode := ExitOK
+	var entries []exifEntry
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return ExitInterrupted
+		}
+		entry := exifEntry{Filename: filepath.Base(job.Path)}
+		exifData, width, height, dateTaken, err := ExtractMetadata(ctx, job.Path)
+		if err != nil {
+			entry.Error = err.Error()
+			exitCode = ExitPartial
+		} else {
+			entry.Width, entry.Height = OrientedSize(width, height, ExifOrientation(exifData))
+			entry.Exif = exifData
+			metadata := NewPhotoMetadata(exifData)
+			entry.Metadata = &metadata
+			if !dateTaken.IsZero() {
+				entry.DateTaken = dateTaken.Format(time.RFC3339)
+			}
+			// The capture time as sync resolves it, with the offset from GPS or the folder zones
+			photo := Photo{Metadata: &metadata}
+			if processor.setCaptureTime(&photo, job.Path) {
+				entry.Taken, entry.OffsetSource = photo.Taken, photo.OffsetSource
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := printJSON(entries); err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	return exitCode
+}
+
+// runPublish uploads the local photos.json to R2 as is
+func runPublish(args []string) int {
+	fs := newFlagSet("publish", "[flags]")
+	cf := addConfigFlags(fs)
+	overwrite := fs.Bool("overwrite", false, "upload even when the stored photos.json was changed elsewhere")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	processor, existingContent, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	if !requireStorage(processor, "publish") {
+		return ExitError
+	}
+	if len(existingContent) == 0 {
+		fmt.Printf("❌ %s does not exist or is empty\n", cfg.OutputFile)
+		return ExitError
+	}
+
+	processor.Overwrite = *overwrite
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+	current, storedETag, err := processor.RemotePhotosJSONCurrent(ctx, existingContent, existingContent)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	if current {
+		fmt.Printf("✓ photos.json in %s is up to date\n", cfg.Storage.Backend)
+		return ExitOK
+	}
+	if err := processor.UploadPhotosJSON(ctx, existingContent, storedETag); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+// runPrune deletes the stored originals and thumbnails not referenced by the last synced entries of local photos
+func runPrune(args []string) int {
+	fs := newFlagSet("prune", "[flags]")
+	cf := addConfigFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "list the keys that would be deleted without deleting them")
+	force := fs.Bool("force", false, "prune or drop photos even when more would be removed than prune.max_delete_* allow")
+	yes := fs.Bool("yes", false, "prune without asking for confirmation")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	if !requireStorage(processor, "prune") {
+		return ExitError
+	}
+
+	jobs, err := processor.ScanJobs()
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	// Without photos.json or the state database every published object would look unreferenced
+	if len(processor.ExistingPhotos) == 0 && processor.State.Len() == 0 {
+		fmt.Println("❌ prune needs photos.json or the state database to know which objects are referenced")
+		return ExitError
+	}
+
+	// Keep the objects published for photos that still exist locally
+	var present []Photo
+	for _, job := range jobs {
+		if photo, ok := processor.baseline(filepath.Base(job.Path)); ok {
+			present = append(present, photo)
+		}
+	}
+
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+	keys, stored, err := processor.OrphanKeys(ctx, present)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	if *dryRun {
+		if len(keys) == 0 {
+			fmt.Println("✓ No orphaned files found.")
+			return ExitOK
+		}
+		fmt.Printf("Would %s %d keys (dry run):\n", cfg.Prune.Mode, len(keys))
+		for _, key := range keys {
+			fmt.Printf("  %s\n", key)
+		}
+		if err := cfg.Prune.CheckDeletion(len(keys), stored); err != nil {
+			fmt.Printf("⚠ %v\n", err)
+		}
+		return ExitOK
+	}
+
+	processor.Force = *force
+	if !*yes {
+		processor.Confirm = confirmOnTerminal
+	}
+	if len(keys) == 0 {
+		fmt.Println("✓ No orphaned files found.")
+	} else if err := processor.DeleteOrphans(ctx, keys, stored); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	if _, err := processor.PurgeTrash(ctx, time.Now()); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+// runRestore lists the trash batches left by prune, or moves the given batches back to their original keys
+func runRestore(args []string) int {
+	fs := newFlagSet("restore", "[flags] [batch...]")
+	cf := addConfigFlags(fs)
+	latest := fs.Bool("latest", false, "restore the newest trash batch")
+	dryRun := fs.Bool("dry-run", false, "list the keys that would be restored without restoring them")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	if !requireStorage(processor, "restore") {
+		return ExitError
+	}
+
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+	batches, err := processor.TrashBatches(ctx)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+
+	names := fs.Args()
+	if *latest && len(batches) > 0 {
+		names = append(names, batches[len(batches)-1].Name)
+	}
+	if len(names) == 0 {
+		if len(batches) == 0 {
+			fmt.Println("✓ The trash is empty.")
+			return ExitOK
+		}
+		for _, batch := range batches {
+			expires := "never"
+			if ttl := cfg.Prune.TrashTTLDays; ttl > 0 && !batch.Time.IsZero() {
+				expires = batch.Time.AddDate(0, 0, ttl).Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf(
+				"%s  %5d objects  %8.1f MB  expires %s\n",
+				batch.Name, len(batch.Keys), float64(batch.Size)/(1<<20), expires,
+			)
+		}
+		return ExitOK
+	}
+
+	byName := make(map[string]TrashBatch, len(batches))
+	for _, batch := range batches {
+		byName[batch.Name] = batch
+	}
+	var selected []TrashBatch
+	for _, name := range names {
+		batch, ok := byName[strings.TrimSuffix(name, "/")]
+		if !ok {
+			fmt.Printf("❌ No trash batch %s\n", name)
+			return ExitError
+		}
+		selected = append(selected, batch)
+	}
+
+	for _, batch := range selected {
+		if *dryRun {
+			fmt.Printf("Would restore %d keys from %s (dry run):\n", len(batch.Keys), batch.Name)
+			for _, key := range batch.Keys {
+				fmt.Printf("  %s\n", processor.restoredKey(batch.Name, key))
+			}
+			continue
+		}
+		if err := processor.Restore(ctx, batch); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return ExitError
+		}
+	}
+	if !*dryRun {
+		fmt.Println("✓ Restored. Run reconcile to bring the state database up to date.")
+	}
+	return ExitOK
+}
+
+// runRollback lists the backups of photos.json, or restores the given one locally and in storage
+func runRollback(args []string) int {
+	fs := newFlagSet("rollback", "[flags] [backup]")
+	cf := addConfigFlags(fs)
+	latest := fs.Bool("latest", false, "restore the newest backup")
+	noPublish := fs.Bool("no-publish", false, "only restore the local photos.json")
+	force := fs.Bool("force", false, "roll back even when objects the backup references are neither stored nor in the trash")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 1 || (*latest && fs.NArg() > 0) {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	processor.Force = *force
+
+	backups, err := processor.Backups()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+
+	name := fs.Arg(0)
+	if *latest && len(backups) > 0 {
+		name = backups[len(backups)-1].Name
+	}
+	if name == "" {
+		if len(backups) == 0 {
+			fmt.Printf("✓ No backups in %s\n", cfg.BackupDir(processor.RootDir))
+			return ExitOK
+		}
+		for _, backup := range backups {
+			fmt.Printf(
+				"%s  %s  %8.1f KB\n",
+				backup.Name, backup.Time.Local().Format("2006-01-02 15:04:05"), float64(backup.Size)/(1<<10),
+			)
+		}
+		return ExitOK
+	}
+
+	var selected *Backup
+	for i, backup := range backups {
+		if backup.Name == filepath.Base(name) || backup.Path == name {
+			selected = &backups[i]
+		}
+	}
+	if selected == nil {
+		fmt.Printf("❌ No backup %s\n", name)
+		return ExitError
+	}
+
+	upload := !*noPublish
+	if upload && !requireStorage(processor, "rollback") {
+		return ExitError
+	}
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+	if err := processor.Rollback(ctx, *selected, upload); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	fmt.Println("✓ Rolled back. The next sync rebuilds photos.json from the image directory.")
+	return ExitOK
+}
+
+// runDiff compares two versions of photos.json photo by photo, by default the newest backup with the current file
+func runDiff(args []string) int {
+	fs := newFlagSet("diff", "[flags] [old.json|-] [new.json]")
+	cf := addConfigFlags(fs)
+	asJSON := fs.Bool("json", false, "print the diff as JSON")
+	noColor := fs.Bool("no-color", false, "print without colors, also set by $NO_COLOR")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 2 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	rootDir, err := cfg.ResolveRootDir()
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	// Only paths are needed, so no storage is set up and the JSON output stays clean
+	processor := &PhotoProcessor{Config: cfg, RootDir: rootDir}
+
+	oldPath, newPath := fs.Arg(0), fs.Arg(1)
+	if newPath == "" {
+		newPath = processor.OutputFilePath()
+	}
+	if oldPath == "" {
+		backups, err := processor.Backups()
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return ExitError
+		}
+		if len(backups) == 0 {
+			fmt.Printf("❌ No backups in %s to compare with, pass the old photos.json\n", cfg.BackupDir(rootDir))
+			return ExitError
+		}
+		oldPath = backups[len(backups)-1].Path
+	}
+
+	var oldContent []byte
+	if oldPath == "-" {
+		oldContent, err = io.ReadAll(os.Stdin)
+	} else {
+		oldContent, err = os.ReadFile(oldPath)
+	}
+	if err != nil {
+		fmt.Printf("❌ Failed to read %s: %v\n", oldPath, err)
+		return ExitError
+	}
+	newContent, err := os.ReadFile(newPath)
+	if err != nil {
+		fmt.Printf("❌ Failed to read %s: %v\n", newPath, err)
+		return ExitError
+	}
+
+	diff, err := DiffPhotosJSON(oldContent, newContent)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	if *asJSON {
+		if err := printJSON(diff); err != nil {
+			fmt.Println(err)
+			return ExitError
+		}
+		return ExitOK
+	}
+	if oldPath == "-" {
+		oldPath = "stdin"
+	}
+	fmt.Printf("%s → %s: %s\n", oldPath, newPath, diff.Summary())
+	diff.PrintText(os.Stdout, !*noColor && useColor(os.Stdout))
+	return ExitOK
+}
+
+// runVerify checks that the original and thumbnail of every photo in photos.json exist in R2
+func runVerify(args []string) int {
+	fs := newFlagSet("verify", "[flags]")
+	cf := addConfigFlags(fs)
+	checkHash := fs.Bool("hash", false, "also verify local files against the hashes in photos.json")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	if !requireStorage(processor, "verify") {
+		return ExitError
+	}
+
+	var localPaths map[string]string
+	if *checkHash {
+		jobs, err := processor.ScanJobs()
+		if err != nil {
+			fmt.Println(err)
+			return ExitError
+		}
+		localPaths = make(map[string]string)
+		for _, job := range jobs {
+			localPaths[filepath.Base(job.Path)] = job.Path
+		}
+	}
+
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+
+	var problems int
+	for filename, photo := range processor.ExistingPhotos {
+		if ctx.Err() != nil {
+			fmt.Println("⚠ Interrupted, verification is incomplete")
+			return ExitInterrupted
+		}
+		for _, key := range processor.photoKeys(photo) {
+			exists, err := objectExists(ctx, processor.Storage, key)
+			if err != nil {
+				fmt.Printf("❌ %s: %v\n", filename, err)
+				problems++
+			} else if !exists {
+				fmt.Printf("❌ %s: missing %s\n", filename, key)
+				problems++
+			}
+		}
+
+		if !*checkHash {
+			continue
+		}
+		path, ok := localPaths[filename]
+		if !ok {
+			fmt.Printf("❌ %s: local file not found\n", filename)
+			problems++
+			continue
+		}
+		hash, err := calculateFileHash(path)
+		if err != nil {
+			fmt.Printf("❌ %s: %v\n", filename, err)
+			problems++
+		} else if hash != photo.Hash {
+			fmt.Printf("❌ %s: hash mismatch (photos.json %s, local %s)\n", filename, photo.Hash, hash)
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		fmt.Printf("⚠ %d problems found in %d photos\n", problems, len(processor.ExistingPhotos))
+		return ExitPartial
+	}
+	fmt.Printf("✓ All %d photos verified\n", len(processor.ExistingPhotos))
+	return ExitOK
+}
+
+// runReconcile compares the state database with a listing of the storage. Photos whose objects
+// are missing or changed are marked stale, so the next sync uploads them again.
+func runReconcile(args []string) int {
+	fs := newFlagSet("reconcile", "[flags]")
+	cf := addConfigFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "report the differences without updating the state database")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	processor, _, err := setupProcessor(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	defer reportRetries(processor)
+	if !requireStorage(processor, "reconcile") {
+		return ExitError
+	}
+
+	ctx, stop := cancelOnSignal(context.Background())
+	defer stop()
+	report, err := processor.Reconcile(ctx)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return ExitError
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			return ExitError
+		}
+		fmt.Println(string(data))
+	} else {
+		PrintReconcileReport(report)
+	}
+
+	if !*dryRun {
+		if err := processor.State.Save(); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return ExitError
+		}
+	}
+	if !report.Consistent() {
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+// runServe serves a directory over HTTP for local previews
+func runServe(args []string) int {
+	fs := newFlagSet("serve", "[flags]")
+	dir := fs.String("dir", ".", "directory to serve")
+	port := fs.String("port", DefaultServePort, "port to listen on")
+	distDir := fs.String("dist", "", "local storage build served on top of -dir (default storage.local_dir when it exists)")
+	cf := addConfigFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if !flagWasSet(fs, "dist") {
+		cfg, err := cf.load(fs)
+		if err != nil {
+			fmt.Println(err)
+			return ExitError
+		}
+		rootDir, err := cfg.ResolveRootDir()
+		if err != nil {
+			fmt.Println(err)
+			return ExitError
+		}
+		if info, err := os.Stat(cfg.LocalDirPath(rootDir)); err == nil && info.IsDir() {
+			*distDir = cfg.LocalDirPath(rootDir)
+		}
+	}
+
+	if err := Serve(*dir, *port, *distDir); err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+// runConfig prints or validates the effective configuration
+func runConfig(args []string) int {
+	if len(args) == 0 || (args[0] != "print" && args[0] != "validate") {
+		fmt.Fprintln(os.Stderr, "Usage: go run main.go config <print|validate> [flags]")
+		return ExitUsage
+	}
+	action := args[0]
+
+	fs := newFlagSet("config "+action, "[flags]")
+	cf := addConfigFlags(fs)
+	if code, ok := parseFlags(fs, args[1:]); !ok {
+		return code
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	if action == "print" {
+		cfg.Print(os.Stdout)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return ExitError
+	}
+	if action == "validate" {
+		fmt.Println("✓ Configuration is valid")
+	}
+	return ExitOK
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
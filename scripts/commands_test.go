@@ -0,0 +1,48 @@
+package scripts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSyncEmptyScanNoPrune tests that an empty scan neither empties photos.json nor the state database,
+// also when the prune is skipped
+func TestSyncEmptyScanNoPrune(t *testing.T) {
+	root := t.TempDir()
+	configPath := filepath.Join(root, "photos.yaml")
+	envPath := filepath.Join(root, ".env")
+	config := "root_dir: .\nexif_extractor: go-exif\nstorage:\n  backend: local\n  local_dir: storage\n"
+	assert.NoError(t, os.WriteFile(configPath, []byte(config), 0644))
+	assert.NoError(t, os.WriteFile(envPath, nil, 0644))
+	imgDir := filepath.Join(root, ImgDir)
+	writeTestJPEG(t, filepath.Join(imgDir, "2025", "DSC_2025-01-02_a.jpg"), 40, 30)
+	writeTestJPEG(t, filepath.Join(imgDir, "2025", "DSC_2025-01-03_b.jpg"), 40, 30)
+
+	args := []string{"sync", "-config", configPath, "-env-file", envPath, "-yes"}
+	assert.Equal(t, ExitOK, Run(args))
+	photosJSON, err := os.ReadFile(filepath.Join(root, OutputFile))
+	assert.NoError(t, err)
+	state, err := os.ReadFile(filepath.Join(root, DefaultStateFile))
+	assert.NoError(t, err)
+
+	// A failed mount looks like every photo was deleted
+	assert.NoError(t, os.RemoveAll(imgDir))
+	assert.NoError(t, os.MkdirAll(imgDir, 0755))
+	assert.Equal(t, ExitError, Run(append(args, "-no-prune")))
+
+	content, err := os.ReadFile(filepath.Join(root, OutputFile))
+	assert.NoError(t, err)
+	assert.Equal(t, string(photosJSON), string(content), "photos.json is left unchanged")
+	content, err = os.ReadFile(filepath.Join(root, DefaultStateFile))
+	assert.NoError(t, err)
+	assert.Equal(t, string(state), string(content), "the state database keeps its records")
+
+	// -force accepts the removal
+	assert.Equal(t, ExitOK, Run(append(args, "-no-prune", "-force")))
+	content, err = os.ReadFile(filepath.Join(root, OutputFile))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "DSC_2025-01-02_a")
+}
@@ -0,0 +1,718 @@
+package scripts
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file lookup
+const (
+	ConfigEnvVar          = "PHOTOS_CONFIG" // Path of the config file, overridden by -config
+	DefaultConfigFile     = "photos.yaml"   // Used when present in the working directory
+	DefaultConfigFileTOML = "photos.toml"   // Used when present and there is no photos.yaml
+)
+
+// Config holds the settings of one gallery.
+// Values are layered: defaults, then the config file, then environment variables, then command line flags.
+type Config struct {
+	RootDir        string          `yaml:"root_dir"` // Relative to the config file, working directory when empty
+	ImgDir         string          `yaml:"img_dir"`
+	OutputFile     string          `yaml:"output_file"`
+	WebPrefix      string          `yaml:"web_prefix"`
+	DateRegex      string          `yaml:"date_regex"`
+	MaxConcurrency int             `yaml:"max_concurrency"`
+	ExifExtractor  string          `yaml:"exif_extractor"`
+	StateFile      string          `yaml:"state_file"` // Local state database, relative to root_dir
+	Timezone       TimezoneConfig  `yaml:"timezone"`
+	Thumbnail      ThumbnailConfig `yaml:"thumbnail"`
+	Storage        StorageConfig   `yaml:"storage"`
+	Retry          RetryConfig     `yaml:"retry"`
+	Prune          PruneConfig     `yaml:"prune"`
+	Backup         BackupConfig    `yaml:"backup"`
+	R2             R2Config        `yaml:"r2"`
+
+	File    string            `yaml:"-"` // Config file the values were loaded from, if any
+	EnvFile string            `yaml:"-"` // .env file the values were read from, if any
+	Sources map[string]string `yaml:"-"` // Field name to the layer that supplied its value
+}
+
+// configField describes one configurable value and the environment variables that override it
+type configField struct {
+	Name   string   // Dotted YAML path, e.g. "r2.bucket"
+	Env    []string // Environment variables, the first non-empty one wins
+	Secret bool     // Masked when printed
+	ptr    func(c *Config) interface{}
+}
+
+// configFields lists every field in the order used by `config print`
+func configFields() []configField {
+	return []configField{
+		{Name: "root_dir", Env: []string{"PHOTOS_ROOT_DIR"}, ptr: func(c *Config) interface{} { return &c.RootDir }},
+		{Name: "img_dir", Env: []string{"PHOTOS_IMG_DIR"}, ptr: func(c *Config) interface{} { return &c.ImgDir }},
+		{Name: "output_file", Env: []string{"PHOTOS_OUTPUT_FILE"}, ptr: func(c *Config) interface{} { return &c.OutputFile }},
+		{Name: "web_prefix", Env: []string{"PHOTOS_WEB_PREFIX"}, ptr: func(c *Config) interface{} { return &c.WebPrefix }},
+		{Name: "date_regex", Env: []string{"PHOTOS_DATE_REGEX"}, ptr: func(c *Config) interface{} { return &c.DateRegex }},
+		{
+			Name: "max_concurrency", Env: []string{"PHOTOS_MAX_CONCURRENCY"},
+			ptr: func(c *Config) interface{} { return &c.MaxConcurrency },
+		},
+		{
+			Name: "exif_extractor", Env: []string{"PHOTOS_EXIF_EXTRACTOR"},
+			ptr: func(c *Config) interface{} { return &c.ExifExtractor },
+		},
+		{Name: "state_file", Env: []string{"PHOTOS_STATE_FILE"}, ptr: func(c *Config) interface{} { return &c.StateFile }},
+		{
+			Name: "timezone.default", Env: []string{"PHOTOS_TIMEZONE_DEFAULT"},
+			ptr: func(c *Config) interface{} { return &c.Timezone.Default },
+		},
+		{
+			Name: "timezone.folders", Env: []string{"PHOTOS_TIMEZONE_FOLDERS"},
+			ptr: func(c *Config) interface{} { return &c.Timezone.Folders },
+		},
+		{
+			Name: "thumbnail.max_width", Env: []string{"PHOTOS_THUMBNAIL_MAX_WIDTH"},
+			ptr: func(c *Config) interface{} { return &c.Thumbnail.MaxWidth },
+		},
+		{
+			Name: "thumbnail.quality", Env: []string{"PHOTOS_THUMBNAIL_QUALITY"},
+			ptr: func(c *Config) interface{} { return &c.Thumbnail.Quality },
+		},
+		{
+			Name: "thumbnail.widths", Env: []string{"PHOTOS_THUMBNAIL_WIDTHS"},
+			ptr: func(c *Config) interface{} { return &c.Thumbnail.Widths },
+		},
+		{
+			Name: "thumbnail.formats", Env: []string{"PHOTOS_THUMBNAIL_FORMATS"},
+			ptr: func(c *Config) interface{} { return &c.Thumbnail.Formats },
+		},
+		{
+			Name: "retry.max_attempts", Env: []string{"PHOTOS_RETRY_MAX_ATTEMPTS"},
+			ptr: func(c *Config) interface{} { return &c.Retry.MaxAttempts },
+		},
+		{
+			Name: "retry.base_delay_ms", Env: []string{"PHOTOS_RETRY_BASE_DELAY_MS"},
+			ptr: func(c *Config) interface{} { return &c.Retry.BaseDelayMS },
+		},
+		{
+			Name: "retry.max_delay_ms", Env: []string{"PHOTOS_RETRY_MAX_DELAY_MS"},
+			ptr: func(c *Config) interface{} { return &c.Retry.MaxDelayMS },
+		},
+		{
+			Name: "retry.error_budget", Env: []string{"PHOTOS_RETRY_ERROR_BUDGET"},
+			ptr: func(c *Config) interface{} { return &c.Retry.ErrorBudget },
+		},
+		{Name: "prune.mode", Env: []string{"PHOTOS_PRUNE_MODE"}, ptr: func(c *Config) interface{} { return &c.Prune.Mode }},
+		{
+			Name: "prune.max_delete_percent", Env: []string{"PHOTOS_PRUNE_MAX_DELETE_PERCENT"},
+			ptr: func(c *Config) interface{} { return &c.Prune.MaxDeletePercent },
+		},
+		{
+			Name: "prune.max_delete_count", Env: []string{"PHOTOS_PRUNE_MAX_DELETE_COUNT"},
+			ptr: func(c *Config) interface{} { return &c.Prune.MaxDeleteCount },
+		},
+		{
+			Name: "prune.trash_prefix", Env: []string{"PHOTOS_PRUNE_TRASH_PREFIX"},
+			ptr: func(c *Config) interface{} { return &c.Prune.TrashPrefix },
+		},
+		{
+			Name: "prune.trash_ttl_days", Env: []string{"PHOTOS_PRUNE_TRASH_TTL_DAYS"},
+			ptr: func(c *Config) interface{} { return &c.Prune.TrashTTLDays },
+		},
+		{Name: "backup.dir", Env: []string{"PHOTOS_BACKUP_DIR"}, ptr: func(c *Config) interface{} { return &c.Backup.Dir }},
+		{Name: "backup.keep", Env: []string{"PHOTOS_BACKUP_KEEP"}, ptr: func(c *Config) interface{} { return &c.Backup.Keep }},
+		{
+			Name: "backup.max_age_days", Env: []string{"PHOTOS_BACKUP_MAX_AGE_DAYS"},
+			ptr: func(c *Config) interface{} { return &c.Backup.MaxAgeDays },
+		},
+		{
+			Name: "storage.backend", Env: []string{"PHOTOS_STORAGE_BACKEND"},
+			ptr: func(c *Config) interface{} { return &c.Storage.Backend },
+		},
+		{
+			Name: "storage.local_dir", Env: []string{"PHOTOS_STORAGE_LOCAL_DIR"},
+			ptr: func(c *Config) interface{} { return &c.Storage.LocalDir },
+		},
+		{
+			Name: "storage.base_url", Env: []string{"PHOTOS_STORAGE_BASE_URL"},
+			ptr: func(c *Config) interface{} { return &c.Storage.BaseURL },
+		},
+		{
+			Name: "r2.endpoint", Env: []string{"NUXT_PROVIDER_S3_ENDPOINT", "R2_ENDPOINT"},
+			ptr: func(c *Config) interface{} { return &c.R2.Endpoint },
+		},
+		{
+			Name: "r2.bucket", Env: []string{"NUXT_PROVIDER_S3_BUCKET", "R2_BUCKET"},
+			ptr: func(c *Config) interface{} { return &c.R2.Bucket },
+		},
+		{
+			Name: "r2.region", Env: []string{"NUXT_PROVIDER_S3_REGION", "R2_REGION"},
+			ptr: func(c *Config) interface{} { return &c.R2.Region },
+		},
+		{
+			Name: "r2.access_key_id", Env: []string{"NUXT_PROVIDER_S3_ACCESS_KEY_ID", "R2_ACCESS_KEY_ID"}, Secret: true,
+			ptr: func(c *Config) interface{} { return &c.R2.AccessKeyID },
+		},
+		{
+			Name: "r2.secret_access_key", Env: []string{"NUXT_PROVIDER_S3_SECRET_ACCESS_KEY", "R2_SECRET_ACCESS_KEY"},
+			Secret: true, ptr: func(c *Config) interface{} { return &c.R2.SecretAccessKey },
+		},
+		{
+			Name: "r2.cdn_url", Env: []string{"NUXT_PROVIDER_S3_CDN_URL", "R2_CDN_URL"},
+			ptr: func(c *Config) interface{} { return &c.R2.CDNUrl },
+		},
+		{
+			Name: "r2.base_prefix", Env: []string{"NUXT_PROVIDER_S3_BASE_PREFIX", "R2_BASE_PREFIX"},
+			ptr: func(c *Config) interface{} { return &c.R2.BasePrefix },
+		},
+		{
+			Name: "r2.original_prefix", Env: []string{"NUXT_PROVIDER_S3_ORIGINAL_PREFIX", "R2_ORIGINAL_PREFIX"},
+			ptr: func(c *Config) interface{} { return &c.R2.OriginalPrefix },
+		},
+		{
+			Name: "r2.thumbnail_prefix", Env: []string{"NUXT_PROVIDER_S3_PREFIX_THUMBNAIL_BASE", "R2_THUMBNAIL_PREFIX"},
+			ptr: func(c *Config) interface{} { return &c.R2.ThumbnailPrefix },
+		},
+		{
+			Name: "r2.multipart_threshold_mb", Env: []string{"R2_MULTIPART_THRESHOLD_MB"},
+			ptr: func(c *Config) interface{} { return &c.R2.MultipartThresholdMB },
+		},
+		{
+			Name: "r2.part_size_mb", Env: []string{"R2_PART_SIZE_MB"},
+			ptr: func(c *Config) interface{} { return &c.R2.PartSizeMB },
+		},
+		{
+			Name: "r2.part_concurrency", Env: []string{"R2_PART_CONCURRENCY"},
+			ptr: func(c *Config) interface{} { return &c.R2.PartConcurrency },
+		},
+	}
+}
+
+// DefaultConfig returns the built-in defaults, matching the historical constants
+func DefaultConfig() *Config {
+	cfg := &Config{
+		ImgDir:         ImgDir,
+		OutputFile:     OutputFile,
+		WebPrefix:      WebPhotographyPrefix,
+		DateRegex:      DefaultDateRegex,
+		MaxConcurrency: MaxConcurrency,
+		ExifExtractor:  string(CurrentExifExtractor),
+		StateFile:      DefaultStateFile,
+		Thumbnail:      DefaultThumbnailConfig(),
+		Retry:          DefaultRetryConfig(),
+		Prune:          DefaultPruneConfig(),
+		Backup:         DefaultBackupConfig(),
+		Storage: StorageConfig{
+			Backend:  StorageR2,
+			LocalDir: DefaultLocalDir,
+		},
+		R2: R2Config{
+			BasePrefix:      "photos/",
+			OriginalPrefix:  "originals/",
+			ThumbnailPrefix: "thumbnails/",
+
+			MultipartThresholdMB: DefaultMultipartThresholdMB,
+			PartSizeMB:           DefaultPartSizeMB,
+			PartConcurrency:      DefaultPartConcurrency,
+		},
+		Sources: make(map[string]string),
+	}
+	for _, field := range configFields() {
+		cfg.Sources[field.Name] = "default"
+	}
+	return cfg
+}
+
+// LoadConfig builds the configuration from defaults, the config file, an env file and the environment.
+// The config file is YAML, or TOML when its extension is .toml.
+// An empty path falls back to $PHOTOS_CONFIG, ./photos.yaml, ./photos.toml and the same files
+// in $XDG_CONFIG_HOME/photo-gallery/.
+// An empty envFile falls back to ./.env, ./scripts/.env and $XDG_CONFIG_HOME/photo-gallery/.env; none is required.
+func LoadConfig(path, envFile string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path == "" {
+		path = findConfigFile()
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	vars, envFile, err := readEnvFile(envFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.EnvFile = envFile
+
+	if err := cfg.applyEnv(vars, envFile, ""); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// findConfigFile returns the first config file found when -config is not given
+func findConfigFile() string {
+	if path := os.Getenv(ConfigEnvVar); path != "" {
+		return path
+	}
+	candidates := []string{DefaultConfigFile, DefaultConfigFileTOML}
+	if dir := xdgConfigDir(); dir != "" {
+		candidates = append(
+			candidates, filepath.Join(dir, DefaultConfigFile), filepath.Join(dir, DefaultConfigFileTOML),
+		)
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadFile overlays the values of a YAML config file, or of a TOML one by its .toml extension
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		// TOML uses the same keys; converted to YAML it goes through the same strict decoding
+		var raw map[string]interface{}
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(raw); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s must be YAML (.yaml, .yml) or TOML (.toml)", path)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// Decode again loosely to find out which fields the file actually sets
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err == nil {
+		for _, field := range configFields() {
+			if yamlHasKey(raw, field.Name) {
+				c.Sources[field.Name] = "config file"
+			}
+		}
+	}
+
+	c.File = path
+	if c.RootDir != "" && !filepath.IsAbs(c.RootDir) {
+		c.RootDir = filepath.Join(filepath.Dir(path), c.RootDir)
+	}
+	return nil
+}
+
+// yamlHasKey reports whether a dotted path such as "r2.bucket" is present in a decoded YAML document
+func yamlHasKey(raw map[string]interface{}, name string) bool {
+	head, rest, nested := strings.Cut(name, ".")
+	value, ok := raw[head]
+	if !ok || !nested {
+		return ok
+	}
+	child, ok := value.(map[string]interface{})
+	return ok && yamlHasKey(child, rest)
+}
+
+// applyEnv overlays the fields whose name starts with prefix, first from envVars read from envFile,
+// then from the process environment, which wins
+func (c *Config) applyEnv(envVars map[string]string, envFile, prefix string) error {
+	for _, field := range configFields() {
+		if !strings.HasPrefix(field.Name, prefix) {
+			continue
+		}
+
+		var value, source string
+		for _, name := range field.Env {
+			if v := envVars[name]; v != "" {
+				value, source = v, fmt.Sprintf("env file %s (%s)", envFile, name)
+				break
+			}
+		}
+		for _, name := range field.Env {
+			if v := os.Getenv(name); v != "" {
+				value, source = v, fmt.Sprintf("env (%s)", name)
+				break
+			}
+		}
+		if value == "" {
+			continue
+		}
+
+		if err := field.set(c, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", source, err)
+		}
+		c.Sources[field.Name] = source
+	}
+	return nil
+}
+
+// SetFromFlag records that a field was overridden by a command line flag
+func (c *Config) SetFromFlag(field, flagName string) {
+	c.Sources[field] = "flag -" + flagName
+}
+
+// set parses value into the field
+func (f configField) set(c *Config, value string) error {
+	switch ptr := f.ptr(c).(type) {
+	case *string:
+		*ptr = value
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*ptr = i
+	case *[]int:
+		var list []int
+		for _, part := range strings.Split(value, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return err
+			}
+			list = append(list, i)
+		}
+		*ptr = list
+	case *[]string:
+		var list []string
+		for _, part := range strings.Split(value, ",") {
+			list = append(list, strings.TrimSpace(part))
+		}
+		*ptr = list
+	case *map[string]string:
+		// "2024/japan=Asia/Tokyo,2024/paris=Europe/Paris"
+		entries := make(map[string]string)
+		for _, part := range strings.Split(value, ",") {
+			key, val, ok := strings.Cut(part, "=")
+			if !ok {
+				return fmt.Errorf("%q is not key=value", strings.TrimSpace(part))
+			}
+			entries[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		*ptr = entries
+	}
+	return nil
+}
+
+// display formats the field value as a YAML scalar, masking secrets
+func (f configField) display(c *Config) string {
+	switch ptr := f.ptr(c).(type) {
+	case *string:
+		if f.Secret {
+			return quoteYAML(maskSecret(*ptr))
+		}
+		return quoteYAML(*ptr)
+	case *int:
+		return strconv.Itoa(*ptr)
+	case *[]int:
+		items := make([]string, len(*ptr))
+		for i, v := range *ptr {
+			items[i] = strconv.Itoa(v)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *[]string:
+		items := make([]string, len(*ptr))
+		for i, v := range *ptr {
+			items[i] = quoteYAML(v)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *map[string]string:
+		keys := make([]string, 0, len(*ptr))
+		for key := range *ptr {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = quoteYAML(key) + ": " + quoteYAML((*ptr)[key])
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return ""
+}
+
+// Validate checks the configuration for values the pipeline cannot work with
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.ImgDir == "" {
+		problems = append(problems, "img_dir must not be empty")
+	}
+	if c.OutputFile == "" {
+		problems = append(problems, "output_file must not be empty")
+	} else if !strings.EqualFold(filepath.Ext(c.OutputFile), ".json") {
+		problems = append(problems, "output_file must be a .json file")
+	}
+	if re, err := regexp.Compile(c.DateRegex); err != nil {
+		problems = append(problems, fmt.Sprintf("date_regex is invalid: %v", err))
+	} else if re.NumSubexp() < 3 {
+		problems = append(problems, "date_regex must capture year, month and day")
+	}
+	if c.MaxConcurrency < 1 || c.MaxConcurrency > 256 {
+		problems = append(problems, "max_concurrency must be between 1 and 256")
+	}
+	switch ExifExtractorType(c.ExifExtractor) {
+	case ExifExtractorGoExif, ExifExtractorExifTool:
+	default:
+		problems = append(problems, fmt.Sprintf("exif_extractor must be %q or %q", ExifExtractorGoExif, ExifExtractorExifTool))
+	}
+	if c.StateFile == "" {
+		problems = append(problems, "state_file must not be empty")
+	}
+	if _, err := NewTimeZones(c.Timezone); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Thumbnail.MaxWidth <= 0 {
+		problems = append(problems, "thumbnail.max_width must be positive")
+	}
+	if c.Thumbnail.Quality < 1 || c.Thumbnail.Quality > 100 {
+		problems = append(problems, "thumbnail.quality must be between 1 and 100")
+	}
+	for _, width := range c.Thumbnail.Widths {
+		if width <= 0 {
+			problems = append(problems, "thumbnail.widths must all be positive")
+			break
+		}
+	}
+	for _, format := range c.Thumbnail.Formats {
+		if FormatExt(format) == "" {
+			problems = append(
+				problems, fmt.Sprintf("thumbnail.formats must only contain %q, %q or %q", FormatWebP, FormatAVIF, FormatJPEG),
+			)
+			break
+		}
+	}
+	for _, format := range c.Thumbnail.Formats {
+		if format == FormatAVIF {
+			if _, err := exec.LookPath("avifenc"); err != nil {
+				problems = append(problems, "thumbnail.formats contains avif, but avifenc is not in PATH; install libavif")
+			}
+			break
+		}
+	}
+	if len(c.Thumbnail.Widths) > 0 && len(c.Thumbnail.Formats) == 0 {
+		problems = append(problems, "thumbnail.formats must not be empty when thumbnail.widths is set")
+	}
+	if c.Retry.MaxAttempts < 1 {
+		problems = append(problems, "retry.max_attempts must be at least 1")
+	}
+	if c.Retry.BaseDelayMS < 0 || c.Retry.MaxDelayMS < c.Retry.BaseDelayMS {
+		problems = append(problems, "retry.base_delay_ms must not be negative or exceed retry.max_delay_ms")
+	}
+	if c.Retry.ErrorBudget < 0 {
+		problems = append(problems, "retry.error_budget must not be negative")
+	}
+	switch c.Prune.Mode {
+	case PruneTrash, PruneDelete:
+	default:
+		problems = append(problems, fmt.Sprintf("prune.mode must be %q or %q", PruneTrash, PruneDelete))
+	}
+	if c.Prune.MaxDeletePercent < 0 || c.Prune.MaxDeletePercent > 100 {
+		problems = append(problems, "prune.max_delete_percent must be between 0 and 100")
+	}
+	if c.Prune.MaxDeleteCount < 0 {
+		problems = append(problems, "prune.max_delete_count must not be negative")
+	}
+	if c.Prune.TrashTTLDays < 0 {
+		problems = append(problems, "prune.trash_ttl_days must not be negative")
+	}
+	if c.Prune.TrashPrefix == "" {
+		problems = append(problems, "prune.trash_prefix must not be empty")
+	}
+	for _, prefix := range []string{c.R2.OriginalPrefix, c.R2.ThumbnailPrefix} {
+		// Swept prefixes must not contain the trash, and the trash must not contain them
+		if prefix != "" && c.Prune.TrashPrefix != "" &&
+			(strings.HasPrefix(c.Prune.TrashPrefix, prefix) || strings.HasPrefix(prefix, c.Prune.TrashPrefix)) {
+			problems = append(problems, "prune.trash_prefix must not overlap the original or thumbnail prefix")
+			break
+		}
+	}
+	if c.Backup.Dir == "" {
+		problems = append(problems, "backup.dir must not be empty")
+	}
+	if c.Backup.Keep < 0 {
+		problems = append(problems, "backup.keep must not be negative")
+	}
+	if c.Backup.MaxAgeDays < 0 {
+		problems = append(problems, "backup.max_age_days must not be negative")
+	}
+	switch c.Storage.Backend {
+	case StorageR2, StorageMemory:
+	case StorageLocal:
+		if c.Storage.LocalDir == "" {
+			problems = append(problems, "storage.local_dir must not be empty for the local backend")
+		}
+	default:
+		problems = append(
+			problems, fmt.Sprintf("storage.backend must be %q, %q or %q", StorageR2, StorageLocal, StorageMemory),
+		)
+	}
+	if c.R2.MultipartThresholdMB < 1 {
+		problems = append(problems, "r2.multipart_threshold_mb must be positive")
+	}
+	if c.R2.PartSizeMB < 5 || c.R2.PartSizeMB > 5120 {
+		problems = append(problems, "r2.part_size_mb must be between 5 and 5120")
+	}
+	if c.R2.PartConcurrency < 1 || c.R2.PartConcurrency > 64 {
+		problems = append(problems, "r2.part_concurrency must be between 1 and 64")
+	}
+	for name, prefix := range map[string]string{
+		"r2.base_prefix":      c.R2.BasePrefix,
+		"r2.original_prefix":  c.R2.OriginalPrefix,
+		"r2.thumbnail_prefix": c.R2.ThumbnailPrefix,
+		"prune.trash_prefix":  c.Prune.TrashPrefix,
+	} {
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			problems = append(problems, fmt.Sprintf("%s must end with '/'", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// R2Configured reports whether all required R2 settings are present
+func (c *Config) R2Configured() bool {
+	return c.R2.Endpoint != "" && c.R2.Bucket != "" && c.R2.AccessKeyID != "" && c.R2.SecretAccessKey != ""
+}
+
+// ResolveRootDir returns the absolute root directory that relative paths are resolved against
+func (c *Config) ResolveRootDir() (string, error) {
+	if c.RootDir == "" {
+		return os.Getwd()
+	}
+	return filepath.Abs(c.RootDir)
+}
+
+// Print writes the effective configuration with secrets masked, annotating each value with its source
+func (c *Config) Print(w io.Writer) {
+	if c.File != "" {
+		fmt.Fprintf(w, "# config file: %s\n", c.File)
+	} else {
+		fmt.Fprintln(w, "# config file: none (defaults and environment)")
+	}
+	if c.EnvFile != "" {
+		fmt.Fprintf(w, "# env file: %s\n", c.EnvFile)
+	} else {
+		fmt.Fprintln(w, "# env file: none")
+	}
+
+	section := ""
+	for _, field := range configFields() {
+		name := field.Name
+		if i := strings.Index(name, "."); i >= 0 {
+			if name[:i] != section {
+				section = name[:i]
+				fmt.Fprintf(w, "%s:\n", section)
+			}
+			fmt.Fprintf(w, "  %s: %s # %s\n", name[i+1:], field.display(c), c.Sources[field.Name])
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", name, field.display(c), c.Sources[field.Name])
+	}
+}
+
+// quoteYAML quotes a scalar so that the printed configuration can be loaded again
+func quoteYAML(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return strconv.Quote(s)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// maskSecret hides all but the last four characters of a secret
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 4 {
+		return "****"
+	}
+	return "****" + s[len(s)-4:]
+}
+
+// configFlags are the flags shared by every command that reads the configuration
+type configFlags struct {
+	path       string
+	envFile    string
+	imgDir     string
+	outputFile string
+	storage    string
+	localDir   string
+}
+
+// addConfigFlags registers the shared configuration flags on fs
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	cf := &configFlags{}
+	fs.StringVar(&cf.path, "config", "", "config file (default $"+ConfigEnvVar+" or ./"+DefaultConfigFile+")")
+	fs.StringVar(&cf.envFile, "env-file", "", "env file with R2 settings (default ./.env, ./scripts/.env or the XDG config dir)")
+	fs.StringVar(&cf.imgDir, "img-dir", "", "override img_dir")
+	fs.StringVar(&cf.outputFile, "output", "", "override output_file")
+	fs.StringVar(&cf.storage, "storage", "", "override storage.backend (r2, local or memory)")
+	fs.StringVar(&cf.localDir, "local-dir", "", "override storage.local_dir")
+	return cf
+}
+
+// load returns the configuration with the shared flags applied on top
+func (cf *configFlags) load(fs *flag.FlagSet) (*Config, error) {
+	cfg, err := LoadConfig(cf.path, cf.envFile)
+	if err != nil {
+		return nil, err
+	}
+	if flagWasSet(fs, "img-dir") {
+		cfg.ImgDir = cf.imgDir
+		cfg.SetFromFlag("img_dir", "img-dir")
+	}
+	if flagWasSet(fs, "output") {
+		cfg.OutputFile = cf.outputFile
+		cfg.SetFromFlag("output_file", "output")
+	}
+	if flagWasSet(fs, "storage") {
+		cfg.Storage.Backend = cf.storage
+		cfg.SetFromFlag("storage.backend", "storage")
+	}
+	if flagWasSet(fs, "local-dir") {
+		cfg.Storage.LocalDir = cf.localDir
+		cfg.SetFromFlag("storage.local_dir", "local-dir")
+	}
+	return cfg, nil
+}
+
+// flagWasSet reports whether a flag was given explicitly on the command line
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,257 @@
+package scripts
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadConfigLayers tests that the config file overrides defaults and the environment overrides the file
+func TestLoadConfigLayers(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "gallery.yaml")
+	content := `root_dir: site
+img_dir: images
+max_concurrency: 4
+thumbnail:
+  max_width: 640
+r2:
+  bucket: file-bucket
+  cdn_url: https://cdn.example.com
+`
+	err := os.WriteFile(configFile, []byte(content), 0644)
+	assert.NoError(t, err)
+
+	t.Setenv("PHOTOS_MAX_CONCURRENCY", "6")
+	t.Setenv("PHOTOS_THUMBNAIL_WIDTHS", "300, 600")
+	t.Setenv("NUXT_PROVIDER_S3_BUCKET", "")
+	t.Setenv("R2_BUCKET", "env-bucket")
+	t.Setenv("PHOTOS_TIMEZONE_FOLDERS", "2024/japan=Asia/Tokyo, 2024/paris=+01:00")
+
+	cfg, err := LoadConfig(configFile, "")
+	assert.NoError(t, err)
+	assert.Equal(t, configFile, cfg.File)
+	assert.Equal(t, filepath.Join(tmpDir, "site"), cfg.RootDir)
+	assert.Equal(t, "images", cfg.ImgDir)
+	assert.Equal(t, OutputFile, cfg.OutputFile)
+	assert.Equal(t, 6, cfg.MaxConcurrency)
+	assert.Equal(t, 640, cfg.Thumbnail.MaxWidth)
+	assert.Equal(t, DefaultThumbnailConfig().Quality, cfg.Thumbnail.Quality)
+	assert.Equal(t, []int{300, 600}, cfg.Thumbnail.Widths)
+	assert.Equal(t, "env-bucket", cfg.R2.Bucket)
+	assert.Equal(t, "photos/", cfg.R2.BasePrefix)
+	assert.Equal(t, map[string]string{"2024/japan": "Asia/Tokyo", "2024/paris": "+01:00"}, cfg.Timezone.Folders)
+}
+
+// TestLoadConfigTOML tests that a .toml config file sets the same fields as YAML
+func TestLoadConfigTOML(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "gallery.toml")
+	content := `root_dir = "site"
+max_concurrency = 4
+
+[thumbnail]
+max_width = 640
+widths = [300, 600]
+
+[timezone.folders]
+"2024/japan" = "Asia/Tokyo"
+`
+	assert.NoError(t, os.WriteFile(configFile, []byte(content), 0644))
+	t.Setenv("PHOTOS_MAX_CONCURRENCY", "")
+
+	cfg, err := LoadConfig(configFile, "")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(tmpDir, "site"), cfg.RootDir)
+	assert.Equal(t, 4, cfg.MaxConcurrency)
+	assert.Equal(t, 640, cfg.Thumbnail.MaxWidth)
+	assert.Equal(t, []int{300, 600}, cfg.Thumbnail.Widths)
+	assert.Equal(t, map[string]string{"2024/japan": "Asia/Tokyo"}, cfg.Timezone.Folders)
+	assert.Equal(t, "config file", cfg.Sources["thumbnail.max_width"])
+}
+
+// TestLoadConfigErrors tests that broken config files and environment values are reported
+func TestLoadConfigErrors(t *testing.T) {
+	t.Run("Unknown field", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "gallery.yaml")
+		err := os.WriteFile(configFile, []byte("img_dri: images\n"), 0644)
+		assert.NoError(t, err)
+
+		_, err = LoadConfig(configFile, "")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "img_dri")
+	})
+
+	t.Run("Unknown field in TOML", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "gallery.toml")
+		assert.NoError(t, os.WriteFile(configFile, []byte("[thumbnail]\nmax_widht = 640\n"), 0644))
+
+		_, err := LoadConfig(configFile, "")
+		assert.ErrorContains(t, err, "max_widht")
+	})
+
+	t.Run("Invalid TOML", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "gallery.toml")
+		assert.NoError(t, os.WriteFile(configFile, []byte("img_dir: images\n"), 0644))
+
+		_, err := LoadConfig(configFile, "")
+		assert.ErrorContains(t, err, "failed to parse config file")
+	})
+
+	t.Run("Unsupported extension", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "gallery.json")
+		assert.NoError(t, os.WriteFile(configFile, []byte("{}"), 0644))
+
+		_, err := LoadConfig(configFile, "")
+		assert.ErrorContains(t, err, "must be YAML (.yaml, .yml) or TOML (.toml)")
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), "")
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid integer in environment", func(t *testing.T) {
+		t.Setenv(ConfigEnvVar, "")
+		t.Setenv("PHOTOS_THUMBNAIL_QUALITY", "high")
+		_, err := LoadConfig("", "")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "PHOTOS_THUMBNAIL_QUALITY")
+	})
+}
+
+// TestConfigValidate tests the validation rules
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		problem string
+	}{
+		{"Defaults are valid", func(c *Config) {}, ""},
+		{"Empty image dir", func(c *Config) { c.ImgDir = "" }, "img_dir"},
+		{"Output is not JSON", func(c *Config) { c.OutputFile = "photos.txt" }, "output_file"},
+		{"Regex does not compile", func(c *Config) { c.DateRegex = "DSC_(" }, "date_regex"},
+		{"Regex without groups", func(c *Config) { c.DateRegex = "DSC_" }, "date_regex"},
+		{"No workers", func(c *Config) { c.MaxConcurrency = 0 }, "max_concurrency"},
+		{"Unknown extractor", func(c *Config) { c.ExifExtractor = "magic" }, "exif_extractor"},
+		{"Quality out of range", func(c *Config) { c.Thumbnail.Quality = 101 }, "thumbnail.quality"},
+		{"Negative rendition width", func(c *Config) { c.Thumbnail.Widths = []int{400, -1} }, "thumbnail.widths"},
+		{"Prefix without slash", func(c *Config) { c.R2.BasePrefix = "photos" }, "r2.base_prefix"},
+		{"Part below the S3 minimum", func(c *Config) { c.R2.PartSizeMB = 4 }, "r2.part_size_mb"},
+		{"Unknown prune mode", func(c *Config) { c.Prune.Mode = "shred" }, "prune.mode"},
+		{"Trash inside the originals", func(c *Config) { c.Prune.TrashPrefix = "originals/trash/" }, "prune.trash_prefix"},
+		{"Negative backup count", func(c *Config) { c.Backup.Keep = -1 }, "backup.keep"},
+		{"Unknown default zone", func(c *Config) { c.Timezone.Default = "Mars/Olympus" }, "timezone.default"},
+		{
+			"Folder zone outside img_dir",
+			func(c *Config) { c.Timezone.Folders = map[string]string{"../trips": "Asia/Tokyo"} }, "timezone.folders",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.problem == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.problem)
+		})
+	}
+}
+
+// TestConfigPrintMasksSecrets tests that secrets never appear in `config print`
+func TestConfigPrintMasksSecrets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.R2.AccessKeyID = "AKIAEXAMPLEKEY1234"
+	cfg.R2.SecretAccessKey = "very-secret-value"
+
+	var buf bytes.Buffer
+	cfg.Print(&buf)
+
+	assert.NotContains(t, buf.String(), "AKIAEXAMPLEKEY1234")
+	assert.NotContains(t, buf.String(), "very-secret-value")
+	assert.Contains(t, buf.String(), "access_key_id: '****1234'")
+	assert.Contains(t, buf.String(), "img_dir: web/photography/gallery_images")
+}
+
+// TestLoadConfigEnvSources tests R2 resolution from the environment and env files, and the reported sources
+func TestLoadConfigEnvSources(t *testing.T) {
+	r2Vars := []string{
+		"NUXT_PROVIDER_S3_ENDPOINT", "R2_ENDPOINT", "NUXT_PROVIDER_S3_BUCKET", "R2_BUCKET",
+		"NUXT_PROVIDER_S3_ACCESS_KEY_ID", "R2_ACCESS_KEY_ID", "NUXT_PROVIDER_S3_SECRET_ACCESS_KEY", "R2_SECRET_ACCESS_KEY",
+	}
+	clearEnv := func(t *testing.T) {
+		for _, name := range r2Vars {
+			t.Setenv(name, "")
+		}
+		t.Setenv(ConfigEnvVar, "")
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		oldWd, _ := os.Getwd()
+		assert.NoError(t, os.Chdir(t.TempDir()))
+		t.Cleanup(func() { os.Chdir(oldWd) })
+	}
+
+	t.Run("Environment only, no env file", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("R2_ENDPOINT", "https://test.r2.cloudflarestorage.com")
+		t.Setenv("R2_BUCKET", "ci-bucket")
+		t.Setenv("R2_ACCESS_KEY_ID", "ci_key")
+		t.Setenv("R2_SECRET_ACCESS_KEY", "ci_secret")
+
+		config, err := LoadR2Config()
+		assert.NoError(t, err)
+		assert.Equal(t, "ci-bucket", config.Bucket)
+
+		cfg, err := LoadConfig("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "", cfg.EnvFile)
+		assert.Equal(t, "env (R2_BUCKET)", cfg.Sources["r2.bucket"])
+		assert.Equal(t, "default", cfg.Sources["r2.base_prefix"])
+	})
+
+	t.Run("Explicit env file", func(t *testing.T) {
+		clearEnv(t)
+		envFile := filepath.Join(t.TempDir(), "r2.env")
+		content := `NUXT_PROVIDER_S3_ENDPOINT=https://test.r2.cloudflarestorage.com
+NUXT_PROVIDER_S3_BUCKET=file-bucket
+NUXT_PROVIDER_S3_ACCESS_KEY_ID=file_key
+NUXT_PROVIDER_S3_SECRET_ACCESS_KEY=file_secret`
+		assert.NoError(t, os.WriteFile(envFile, []byte(content), 0644))
+		t.Setenv("R2_BUCKET", "env-bucket")
+
+		cfg, err := LoadConfig("", envFile)
+		assert.NoError(t, err)
+		assert.True(t, cfg.R2Configured())
+		assert.Equal(t, "env-bucket", cfg.R2.Bucket)
+		assert.Equal(t, "env (R2_BUCKET)", cfg.Sources["r2.bucket"])
+		assert.Equal(t, "env file "+envFile+" (NUXT_PROVIDER_S3_ACCESS_KEY_ID)", cfg.Sources["r2.access_key_id"])
+		assert.Equal(t, "", os.Getenv("NUXT_PROVIDER_S3_ACCESS_KEY_ID"), "env file must not leak into the environment")
+	})
+
+	t.Run("Missing explicit env file", func(t *testing.T) {
+		clearEnv(t)
+		_, err := LoadConfig("", filepath.Join(t.TempDir(), "missing.env"))
+		assert.Error(t, err)
+	})
+
+	t.Run("XDG config dir", func(t *testing.T) {
+		clearEnv(t)
+		dir := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), ConfigDirName)
+		assert.NoError(t, os.MkdirAll(dir, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("R2_BUCKET=xdg-bucket\n"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, DefaultConfigFile), []byte("img_dir: xdg-images\n"), 0644))
+
+		cfg, err := LoadConfig("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "xdg-bucket", cfg.R2.Bucket)
+		assert.Equal(t, "xdg-images", cfg.ImgDir)
+		assert.Equal(t, "config file", cfg.Sources["img_dir"])
+	})
+}
@@ -0,0 +1,237 @@
+package scripts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// AlbumDiff is the semantic difference between two versions of photos.json, matched by filename
+type AlbumDiff struct {
+	Added    []PhotoChange `json:"added"`
+	Removed  []PhotoChange `json:"removed"`
+	Moved    []PhotoChange `json:"moved"`    // Listed under another year, possibly with other changes
+	Modified []PhotoChange `json:"modified"` // Same year, changed fields
+}
+
+// PhotoChange is one photo that differs between two versions of photos.json
+type PhotoChange struct {
+	Filename string        `json:"filename"`
+	Year     string        `json:"year"`              // Year in the new version, in the old one for removed photos
+	OldYear  string        `json:"oldYear,omitempty"` // Moved photos only
+	Fields   []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is one changed field of a photo entry, EXIF fields are named exif.<tag> and
+// typed metadata fields metadata.<group>.<field>
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"` // Absent when the field was added
+	New   interface{} `json:"new,omitempty"` // Absent when the field was removed
+}
+
+// ANSI colors of the terminal diff
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+	colorDim    = "\033[2m"
+)
+
+// DiffPhotosJSON compares two photos.json documents, empty content counts as a gallery without photos
+func DiffPhotosJSON(oldContent, newContent []byte) (AlbumDiff, error) {
+	var oldAlbums, newAlbums []YearAlbum
+	if len(oldContent) > 0 {
+		if err := json.Unmarshal(oldContent, &oldAlbums); err != nil {
+			return AlbumDiff{}, fmt.Errorf("failed to parse the old photos.json: %w", err)
+		}
+	}
+	if len(newContent) > 0 {
+		if err := json.Unmarshal(newContent, &newAlbums); err != nil {
+			return AlbumDiff{}, fmt.Errorf("failed to parse the new photos.json: %w", err)
+		}
+	}
+	return DiffAlbums(oldAlbums, newAlbums), nil
+}
+
+// DiffAlbums compares the photos of two album lists by filename
+func DiffAlbums(oldAlbums, newAlbums []YearAlbum) AlbumDiff {
+	diff := AlbumDiff{Added: []PhotoChange{}, Removed: []PhotoChange{}, Moved: []PhotoChange{}, Modified: []PhotoChange{}}
+
+	oldPhotos, newPhotos := albumPhotos(oldAlbums), albumPhotos(newAlbums)
+	for filename, photo := range newPhotos {
+		old, ok := oldPhotos[filename]
+		if !ok {
+			diff.Added = append(diff.Added, PhotoChange{Filename: filename, Year: photo.Year})
+			continue
+		}
+		change := PhotoChange{Filename: filename, Year: photo.Year, Fields: diffPhotoFields(old, photo)}
+		switch {
+		case old.Year != photo.Year:
+			change.OldYear = old.Year
+			diff.Moved = append(diff.Moved, change)
+		case len(change.Fields) > 0:
+			diff.Modified = append(diff.Modified, change)
+		}
+	}
+	for filename, photo := range oldPhotos {
+		if _, ok := newPhotos[filename]; !ok {
+			diff.Removed = append(diff.Removed, PhotoChange{Filename: filename, Year: photo.Year})
+		}
+	}
+
+	for _, list := range [][]PhotoChange{diff.Added, diff.Removed, diff.Moved, diff.Modified} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Filename < list[j].Filename })
+	}
+	return diff
+}
+
+// albumPhotos indexes the photos of albums by filename; the album year wins over the year of the entry
+func albumPhotos(albums []YearAlbum) map[string]Photo {
+	photos := make(map[string]Photo)
+	for _, album := range albums {
+		for _, photo := range album.Photos {
+			photo.Year = album.Year
+			photos[photo.Filename] = photo
+		}
+	}
+	return photos
+}
+
+// diffPhotoFields compares two entries of the same photo field by field as they appear in photos.json.
+// The year is left out, a different year makes the photo moved.
+func diffPhotoFields(old, photo Photo) []FieldChange {
+	oldFields, newFields := photoFields(old), photoFields(photo)
+	delete(oldFields, "year")
+	delete(newFields, "year")
+
+	var changes []FieldChange
+	for _, name := range unionKeys(oldFields, newFields) {
+		oldValue, newValue := oldFields[name], newFields[name]
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, FieldChange{Field: name, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+// photoFields flattens the JSON form of a photo, with one exif.<tag> entry per EXIF field and
+// metadata.<group>.<field> entries for the typed metadata
+func photoFields(photo Photo) map[string]interface{} {
+	fields := make(map[string]interface{})
+	data, err := json.Marshal(photo)
+	if err != nil {
+		return fields
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fields
+	}
+	flattenFields("", object, fields)
+	return fields
+}
+
+// flattenFields adds the values of object to fields, naming those of nested objects <parent>.<name>
+func flattenFields(prefix string, object, fields map[string]interface{}) {
+	for name, value := range object {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenFields(prefix+name+".", nested, fields)
+			continue
+		}
+		fields[prefix+name] = value
+	}
+}
+
+// unionKeys returns the keys of both maps, sorted
+func unionKeys(a, b map[string]interface{}) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Empty reports whether no photo differs
+func (d AlbumDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Moved) == 0 && len(d.Modified) == 0
+}
+
+// Summary returns the number of photos per kind of change
+func (d AlbumDiff) Summary() string {
+	return fmt.Sprintf(
+		"%d added, %d removed, %d moved, %d modified", len(d.Added), len(d.Removed), len(d.Moved), len(d.Modified),
+	)
+}
+
+// PrintText writes the diff one photo per line with its changed fields below, in ANSI colors when color is set
+func (d AlbumDiff) PrintText(w io.Writer, color bool) {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+	printFields := func(fields []FieldChange) {
+		for _, field := range fields {
+			fmt.Fprintf(
+				w, "      %s: %s → %s\n",
+				field.Field, paint(colorDim, formatFieldValue(field.Old)), formatFieldValue(field.New),
+			)
+		}
+	}
+
+	for _, change := range d.Added {
+		fmt.Fprintln(w, paint(colorGreen, fmt.Sprintf("  + %s [%s]", change.Filename, change.Year)))
+	}
+	for _, change := range d.Removed {
+		fmt.Fprintln(w, paint(colorRed, fmt.Sprintf("  - %s [%s]", change.Filename, change.Year)))
+	}
+	for _, change := range d.Moved {
+		fmt.Fprintln(w, paint(colorCyan, fmt.Sprintf("  → %s [%s → %s]", change.Filename, change.OldYear, change.Year)))
+		printFields(change.Fields)
+	}
+	for _, change := range d.Modified {
+		fmt.Fprintln(w, paint(colorYellow, fmt.Sprintf("  ~ %s [%s]", change.Filename, change.Year)))
+		printFields(change.Fields)
+	}
+}
+
+// formatFieldValue formats a JSON value compactly for the terminal diff
+func formatFieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "(none)"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []interface{}:
+		return fmt.Sprintf("[%d items]", len(v))
+	case map[string]interface{}:
+		return fmt.Sprintf("{%d fields}", len(v))
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// useColor reports whether f is a terminal that accepts ANSI colors; NO_COLOR turns them off
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || strings.EqualFold(os.Getenv("TERM"), "dumb") {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
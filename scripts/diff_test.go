@@ -0,0 +1,101 @@
+package scripts
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDiffAlbums tests the photo by photo comparison of two versions of photos.json
+func TestDiffAlbums(t *testing.T) {
+	photo := func(filename, year string, exif map[string]interface{}) Photo {
+		return Photo{Filename: filename, Year: year, Hash: "aaaa", Exif: exif}
+	}
+	base := []YearAlbum{
+		{Year: "2025", Photos: []Photo{photo("DSC_a.jpg", "2025", map[string]interface{}{"Model": "Z 6", "ISO": 100.0})}},
+		{Year: "2024", Photos: []Photo{photo("DSC_b.jpg", "2024", nil)}},
+	}
+
+	tests := []struct {
+		name     string
+		newAlbum []YearAlbum
+		want     AlbumDiff
+	}{
+		{
+			"Identical",
+			base,
+			AlbumDiff{Added: []PhotoChange{}, Removed: []PhotoChange{}, Moved: []PhotoChange{}, Modified: []PhotoChange{}},
+		},
+		{
+			"Added and removed",
+			[]YearAlbum{{Year: "2025", Photos: []Photo{base[0].Photos[0], photo("DSC_c.jpg", "2025", nil)}}},
+			AlbumDiff{
+				Added:    []PhotoChange{{Filename: "DSC_c.jpg", Year: "2025"}},
+				Removed:  []PhotoChange{{Filename: "DSC_b.jpg", Year: "2024"}},
+				Moved:    []PhotoChange{},
+				Modified: []PhotoChange{},
+			},
+		},
+		{
+			"EXIF fields changed, added and removed",
+			[]YearAlbum{
+				{Year: "2025", Photos: []Photo{photo("DSC_a.jpg", "2025", map[string]interface{}{"Model": "Z 8", "FNumber": 2.8})}},
+				base[1],
+			},
+			AlbumDiff{
+				Added:   []PhotoChange{},
+				Removed: []PhotoChange{},
+				Moved:   []PhotoChange{},
+				Modified: []PhotoChange{{Filename: "DSC_a.jpg", Year: "2025", Fields: []FieldChange{
+					{Field: "exif.FNumber", New: 2.8},
+					{Field: "exif.ISO", Old: 100.0},
+					{Field: "exif.Model", Old: "Z 6", New: "Z 8"},
+				}}},
+			},
+		},
+		{
+			"Moved to another year",
+			[]YearAlbum{base[0], {Year: "2023", Photos: []Photo{photo("DSC_b.jpg", "2023", nil)}}},
+			AlbumDiff{
+				Added:    []PhotoChange{},
+				Removed:  []PhotoChange{},
+				Moved:    []PhotoChange{{Filename: "DSC_b.jpg", Year: "2023", OldYear: "2024"}},
+				Modified: []PhotoChange{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldContent, err := json.Marshal(base)
+			assert.NoError(t, err)
+			newContent, err := json.Marshal(tt.newAlbum)
+			assert.NoError(t, err)
+
+			diff, err := DiffPhotosJSON(oldContent, newContent)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, diff)
+			assert.Equal(t, JSONEqual(oldContent, newContent), diff.Empty())
+		})
+	}
+}
+
+// TestAlbumDiffPrintText tests the terminal output with and without colors
+func TestAlbumDiffPrintText(t *testing.T) {
+	diff := AlbumDiff{
+		Added: []PhotoChange{{Filename: "DSC_c.jpg", Year: "2025"}},
+		Moved: []PhotoChange{{Filename: "DSC_b.jpg", Year: "2023", OldYear: "2024", Fields: []FieldChange{
+			{Field: "hash", Old: "aaaa", New: "bbbb"},
+		}}},
+	}
+
+	var plain bytes.Buffer
+	diff.PrintText(&plain, false)
+	assert.Equal(t, "  + DSC_c.jpg [2025]\n  → DSC_b.jpg [2024 → 2023]\n      hash: \"aaaa\" → \"bbbb\"\n", plain.String())
+
+	var colored bytes.Buffer
+	diff.PrintText(&colored, true)
+	assert.Contains(t, colored.String(), colorGreen+"  + DSC_c.jpg [2025]"+colorReset)
+}
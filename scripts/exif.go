@@ -1,12 +1,18 @@
 package scripts
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"io"
+	"math"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dsoprea/go-exif/v3"
@@ -16,7 +22,8 @@ import (
 type ExifExtractor interface {
 	// Extract 从图片文件中提取 EXIF 数据
 	// 返回: EXIF 数据映射, 宽度, 高度, 拍摄时间, 错误
-	Extract(filePath string) (map[string]interface{}, int, int, time.Time, error)
+	// 取消 ctx 会中止提取
+	Extract(ctx context.Context, filePath string) (map[string]interface{}, int, int, time.Time, error)
 }
 
 // ExifExtractorType 定义提取器类型
@@ -29,14 +36,63 @@ const (
 
 // 全局配置:选择使用哪种 EXIF 提取器
 var (
-	CurrentExifExtractor = ExifExtractorExifTool // 默认使用 exiftool
+	CurrentExifExtractor = ExifExtractorExifTool // 默认使用 exiftool,未安装时回退到 go-exif
 )
 
+// exifAllowedFields 是写入 photos.json 的 EXIF 字段白名单,两种提取器输出相同的字段和格式
+var exifAllowedFields = map[string]bool{
+	"Aperture":                true,
+	"CreateDate":              true,
+	"DateTimeOriginal":        true,
+	"ExposureMode":            true,
+	"ExposureProgram":         true,
+	"ExposureTime":            true,
+	"FNumber":                 true,
+	"Flash":                   true,
+	"FocalLength":             true,
+	"FocalLengthIn35mmFormat": true,
+	"ISO":                     true,
+	"Keywords":                true,
+	"Lens":                    true,
+	"LensModel":               true,
+	"Make":                    true,
+	"MeteringMode":            true,
+	"Model":                   true,
+	"OffsetTime":              true,
+	"OffsetTimeOriginal":      true,
+	"Orientation":             true,
+	"Rating":                  true,
+	"SceneCaptureType":        true,
+	"ShutterSpeed":            true,
+	"Subject":                 true,
+	"WhiteBalance":            true,
+	"GPSAltitude":             true,
+	"GPSDateStamp":            true,
+	"GPSTimeStamp":            true,
+	"GPSLatitude":             true,
+	"GPSLatitudeRef":          true,
+	"GPSLongitude":            true,
+	"GPSLongitudeRef":         true,
+	"Title":                   true,
+	"Description":             true,
+	"HierarchicalSubject":     true,
+	"Label":                   true,
+	"Artist":                  true,
+	"Copyright":               true,
+	"City":                    true,
+	"Country":                 true,
+}
+
 // GoExifExtractor 使用 go-exif 库实现的提取器
 type GoExifExtractor struct{}
 
 // Extract 实现 ExifExtractor 接口
-func (e *GoExifExtractor) Extract(filePath string) (map[string]interface{}, int, int, time.Time, error) {
+func (e *GoExifExtractor) Extract(
+	ctx context.Context, filePath string,
+) (map[string]interface{}, int, int, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, 0, time.Time{}, err
+	}
 	return extractExifNative(filePath)
 }
 
@@ -44,21 +100,44 @@ func (e *GoExifExtractor) Extract(filePath string) (map[string]interface{}, int,
 type ExifToolExtractor struct{}
 
 // Extract 实现 ExifExtractor 接口
-func (e *ExifToolExtractor) Extract(filePath string) (map[string]interface{}, int, int, time.Time, error) {
-	return extractExifWithTool(filePath)
+func (e *ExifToolExtractor) Extract(
+	ctx context.Context, filePath string,
+) (map[string]interface{}, int, int, time.Time, error) {
+	return extractExifWithTool(ctx, filePath)
 }
 
 // GetExifExtractor 根据配置返回对应的提取器
 func GetExifExtractor() ExifExtractor {
 	switch CurrentExifExtractor {
 	case ExifExtractorExifTool:
-		return &ExifToolExtractor{}
+		if exifToolAvailable() {
+			return &ExifToolExtractor{}
+		}
+		return &GoExifExtractor{}
 	default:
 		return &GoExifExtractor{}
 	}
 }
 
-// extractExifNative uses go-exif to extract EXIF data
+var (
+	exifToolOnce  sync.Once
+	exifToolFound bool
+)
+
+// exifToolAvailable 检查 PATH 中是否有 exiftool,没有时提示一次并使用 go-exif
+func exifToolAvailable() bool {
+	exifToolOnce.Do(func() {
+		_, err := exec.LookPath("exiftool")
+		exifToolFound = err == nil
+		if !exifToolFound {
+			fmt.Println("⚠ exiftool not found in PATH, using the built-in go-exif extractor")
+		}
+	})
+	return exifToolFound
+}
+
+// extractExifNative uses go-exif to extract EXIF data with the field names and values of exiftool.
+// An image without EXIF yields no fields, like exiftool.
 func extractExifNative(filePath string) (map[string]interface{}, int, int, time.Time, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -71,91 +150,75 @@ func extractExifNative(filePath string) (map[string]interface{}, int, int, time.
 		}
 	}(f)
 
-	// Read first chunk of file to find EXIF
-	rawExif, err := exif.SearchAndExtractExifWithReader(f)
-	if err != nil {
+	// Width and height come from the image itself, as ImageWidth and ImageHeight of exiftool
+	var width, height int
+	config, format, err := image.DecodeConfig(f)
+	if err == nil {
+		width, height = config.Width, config.Height
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
 		return nil, 0, 0, time.Time{}, err
 	}
 
-	// Use GetFlatExifData to get all tags
-	entries, _, err := exif.GetFlatExifData(rawExif, nil)
-	if err != nil {
+	// Read first chunk of file to find EXIF
+	exifData := make(map[string]interface{})
+	rawExif, err := exif.SearchAndExtractExifWithReader(f)
+	if err != nil && !errors.Is(err, exif.ErrNoExif) {
 		return nil, 0, 0, time.Time{}, err
 	}
+	if err == nil {
+		// Use GetFlatExifData to get all tags
+		entries, _, err := exif.GetFlatExifData(rawExif, nil)
+		if err != nil {
+			return nil, 0, 0, time.Time{}, err
+		}
 
-	exifData := make(map[string]interface{})
-	var width, height int
-	var dateTaken time.Time
-
-	for _, entry := range entries {
-		// fmt.Printf("Debug Tag: ID=0x%04x Name=%s Value=%v\n", entry.TagId, entry.TagName, entry.Value)
-		exifData[entry.TagName] = entry.Formatted
-
-		// Handle specific fields
-		switch entry.TagName {
-		case "DateTimeOriginal":
-			if dateStr, ok := entry.Value.(string); ok {
-				// Format: "2006:01:02 15:04:05"
-				dateTaken, _ = time.Parse("2006:01:02 15:04:05", dateStr)
-				exifData["DateTimeOriginal"] = dateStr
-			} else {
-				exifData["DateTimeOriginal"] = entry.Formatted
-				dateTaken, _ = time.Parse("2006:01:02 15:04:05", entry.Formatted)
-			}
-		case "PixelXDimension":
-			if v, ok := entry.Value.([]uint32); ok && len(v) > 0 {
-				width = int(v[0])
-			} else if v, ok := entry.Value.([]int64); ok && len(v) > 0 {
-				width = int(v[0])
-			} else if v, ok := entry.Value.([]int); ok && len(v) > 0 {
-				width = int(v[0])
-			}
-		case "PixelYDimension":
-			if v, ok := entry.Value.([]uint32); ok && len(v) > 0 {
-				height = int(v[0])
-			} else if v, ok := entry.Value.([]int64); ok && len(v) > 0 {
-				height = int(v[0])
-			} else if v, ok := entry.Value.([]int); ok && len(v) > 0 {
-				height = int(v[0])
+		raw := make(map[string]interface{})
+		for _, entry := range entries {
+			// IFD0 comes first; the same tags of the thumbnail in IFD1 must not replace it
+			if _, ok := raw[entry.TagName]; ok {
+				continue
 			}
-		case "GPSLatitude":
-			exifData["GPSLatitude"] = entry.Formatted
-		case "GPSLongitude":
-			exifData["GPSLongitude"] = entry.Formatted
-		// Windows XP Tags
-		case "XPKeywords": // 0x9c9e
-			if bytes, ok := entry.Value.([]byte); ok {
-				exifData["XPKeywords"] = decodeUCS2(bytes)
-			}
-		case "XPSubject": // 0x9c9f
-			if bytes, ok := entry.Value.([]byte); ok {
-				exifData["XPSubject"] = decodeUCS2(bytes)
-			}
-		case "XPTitle": // 0x9c9c
-			if bytes, ok := entry.Value.([]byte); ok {
-				exifData["XPTitle"] = decodeUCS2(bytes)
-			}
-		case "XPComment": // 0x9c9c
-			if bytes, ok := entry.Value.([]byte); ok {
-				exifData["XPComment"] = decodeUCS2(bytes)
-			}
-		case "XPAuthor": // 0x9c9d
-			if bytes, ok := entry.Value.([]byte); ok {
-				exifData["XPAuthor"] = decodeUCS2(bytes)
+			raw[entry.TagName] = entry.Formatted
+			// Windows XP tags are UCS-2 text stored as bytes
+			if b, ok := entry.Value.([]byte); ok && strings.HasPrefix(entry.TagName, "XP") {
+				raw[entry.TagName] = decodeUCS2(b)
 			}
 		}
+		exifData = normalizeExif(raw)
+
+		// Formats the image package cannot decode fall back to the size recorded by the camera
+		if width == 0 || height == 0 {
+			width, _ = rawInt(raw, "PixelXDimension")
+			height, _ = rawInt(raw, "PixelYDimension")
+		}
 	}
 
-	// Normalize EXIF data
-	exifData = normalizeExif(exifData)
+	// IPTC-IIM in the APP13 segment of JPEG files
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, 0, time.Time{}, err
+		}
+		iptc, err := readIPTC(f)
+		if err != nil {
+			fmt.Printf("⚠ Ignoring the IPTC data of %s: %v\n", filePath, err)
+		}
+		for name, value := range iptc {
+			exifData[name] = value
+		}
+	}
+	applyIPTC(exifData)
+	exifData = filterExif(exifData)
+
+	dateTaken := NewPhotoMetadata(exifData).CaptureTime()
 
 	return exifData, width, height, dateTaken, nil
 }
 
 // extractExifWithTool uses exiftool command to extract EXIF data
-func extractExifWithTool(filePath string) (map[string]interface{}, int, int, time.Time, error) {
-	// 执行 exiftool -json 命令
-	cmd := exec.Command("exiftool", "-json", "-charset", "utf8", filePath)
+func extractExifWithTool(ctx context.Context, filePath string) (map[string]interface{}, int, int, time.Time, error) {
+	// 执行 exiftool -json 命令,取消 ctx 时结束进程
+	cmd := exec.CommandContext(ctx, "exiftool", "-json", "-charset", "utf8", filePath)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, 0, 0, time.Time{}, fmt.Errorf("exiftool command failed: %w", err)
@@ -182,321 +245,298 @@ func extractExifWithTool(filePath string) (map[string]interface{}, int, int, tim
 		height = int(h)
 	}
 
-	// 提取拍摄时间
-	var dateTaken time.Time
-	if dateStr, ok := rawExifData["DateTimeOriginal"].(string); ok {
-		// exiftool 格式: "2025:11:09 22:05:34"
-		dateTaken, _ = time.Parse("2006:01:02 15:04:05", dateStr)
-	} else if dateStr, ok := rawExifData["CreateDate"].(string); ok {
-		dateTaken, _ = time.Parse("2006:01:02 15:04:05", dateStr)
-	}
-
-	// 定义需要保留的字段白名单
-	allowedFields := map[string]bool{
-		"Aperture":                true,
-		"CreateDate":              true,
-		"DateTimeOriginal":        true,
-		"ExposureMode":            true,
-		"ExposureProgram":         true,
-		"ExposureTime":            true,
-		"FNumber":                 true,
-		"Flash":                   true,
-		"FocalLength":             true,
-		"FocalLengthIn35mmFormat": true,
-		"ISO":                     true,
-		"Keywords":                true,
-		"Lens":                    true,
-		"LensModel":               true,
-		"Make":                    true,
-		"MeteringMode":            true,
-		"Model":                   true,
-		"OffsetTime":              true,
-		"OffsetTimeOriginal":      true,
-		"Rating":                  true,
-		"SceneCaptureType":        true,
-		"ShutterSpeed":            true,
-		"Subject":                 true,
-		"WhiteBalance":            true,
-		"GPSAltitude":             true,
-		"GPSLatitude":             true,
-		"GPSLatitudeRef":          true,
-		"GPSLongitude":            true,
-		"GPSLongitudeRef":         true,
-	}
-
-	// 过滤字段,只保留白名单中的字段
-	filteredExifData := make(map[string]interface{})
-	for key, value := range rawExifData {
-		if allowedFields[key] {
-			filteredExifData[key] = value
-		}
+	// Windows XP 标签和 IPTC 字段映射到 photos.json 的字段,再过滤字段,只保留白名单中的字段
+	applyXPTags(rawExifData)
+	applyIPTC(rawExifData)
+	filteredExifData := filterExif(rawExifData)
+
+	// Orientation 统一为 1-8 的整数,与 go-exif 提取器一致
+	if orientation := parseOrientation(filteredExifData["Orientation"]); orientation > 0 {
+		filteredExifData["Orientation"] = orientation
+	} else {
+		delete(filteredExifData, "Orientation")
 	}
 
+	// 提取拍摄时间,有 OffsetTimeOriginal 时带上时区偏移
+	dateTaken := NewPhotoMetadata(filteredExifData).CaptureTime()
+
 	return filteredExifData, width, height, dateTaken, nil
 }
 
-// decodeUCS2 decodes a UCS-2 (UTF-16LE) byte slice to a UTF-8 string
-// Windows XP tags are stored as UCS-2, null-terminated.
-func decodeUCS2(b []byte) string {
-	// Remove trailing null bytes (usually 2 bytes)
-	for len(b) > 0 && b[len(b)-1] == 0 {
-		b = b[:len(b)-1]
-	}
+// orientationNames maps the exiftool descriptions of the EXIF Orientation tag to its values
+var orientationNames = map[string]int{
+	"Horizontal (normal)":                 1,
+	"Mirror horizontal":                   2,
+	"Rotate 180":                          3,
+	"Mirror vertical":                     4,
+	"Mirror horizontal and rotate 270 CW": 5,
+	"Rotate 90 CW":                        6,
+	"Mirror horizontal and rotate 90 CW":  7,
+	"Rotate 270 CW":                       8,
+}
 
-	if len(b)%2 != 0 {
-		return "" // Invalid length for UCS-2
+// parseOrientation converts an Orientation value from either extractor to 1-8, or 0 when unknown
+func parseOrientation(value interface{}) int {
+	var i int
+	switch v := value.(type) {
+	case int:
+		i = v
+	case float64:
+		i = int(v)
+	case string:
+		if n, ok := orientationNames[v]; ok {
+			return n
+		}
+		i, _ = strconv.Atoi(strings.Trim(v, "[]"))
 	}
+	if i < 1 || i > 8 {
+		return 0
+	}
+	return i
+}
 
-	runes := make([]rune, len(b)/2)
-	for i := 0; i < len(b); i += 2 {
-		// Little Endian
-		runes[i/2] = rune(uint16(b[i]) | uint16(b[i+1])<<8)
+// ExifOrientation returns the EXIF orientation of extracted data, 1 (normal) when absent
+func ExifOrientation(exifData map[string]interface{}) int {
+	if orientation := parseOrientation(exifData["Orientation"]); orientation > 0 {
+		return orientation
 	}
-	return string(runes)
+	return 1
 }
 
-// normalizeExif transforms raw go-exif data to legacy format
-func normalizeExif(raw map[string]interface{}) map[string]interface{} {
-	normalized := make(map[string]interface{})
+// Print conversions of exiftool for the EXIF enumerations
+var (
+	exposureModeNames    = map[int]string{0: "Auto", 1: "Manual", 2: "Auto bracket"}
+	exposureProgramNames = map[int]string{
+		0: "Not Defined",
+		1: "Manual",
+		2: "Program AE",
+		3: "Aperture-priority AE",
+		4: "Shutter speed priority AE",
+		5: "Creative (Slow speed)",
+		6: "Action (High speed)",
+		7: "Portrait",
+		8: "Landscape",
+		9: "Bulb",
+	}
+	meteringModeNames = map[int]string{
+		0:   "Unknown",
+		1:   "Average",
+		2:   "Center-weighted average",
+		3:   "Spot",
+		4:   "Multi-spot",
+		5:   "Multi-segment",
+		6:   "Partial",
+		255: "Other",
+	}
+	whiteBalanceNames     = map[int]string{0: "Auto", 1: "Manual"}
+	sceneCaptureTypeNames = map[int]string{0: "Standard", 1: "Landscape", 2: "Portrait", 3: "Night", 4: "Other"}
+	flashNames            = map[int]string{
+		0x00: "No Flash",
+		0x01: "Fired",
+		0x05: "Fired, Return not detected",
+		0x07: "Fired, Return detected",
+		0x08: "On, Did not fire",
+		0x09: "On, Fired",
+		0x0d: "On, Return not detected",
+		0x0f: "On, Return detected",
+		0x10: "Off, Did not fire",
+		0x14: "Off, Did not fire, Return not detected",
+		0x18: "Auto, Did not fire",
+		0x19: "Auto, Fired",
+		0x1d: "Auto, Fired, Return not detected",
+		0x1f: "Auto, Fired, Return detected",
+		0x20: "No flash function",
+		0x30: "Off, No flash function",
+		0x41: "Fired, Red-eye reduction",
+		0x45: "Fired, Red-eye reduction, Return not detected",
+		0x47: "Fired, Red-eye reduction, Return detected",
+		0x49: "On, Red-eye reduction",
+		0x4d: "On, Red-eye reduction, Return not detected",
+		0x4f: "On, Red-eye reduction, Return detected",
+		0x50: "Off, Red-eye reduction",
+		0x58: "Auto, Did not fire, Red-eye reduction",
+		0x59: "Auto, Fired, Red-eye reduction",
+		0x5d: "Auto, Fired, Red-eye reduction, Return not detected",
+		0x5f: "Auto, Fired, Red-eye reduction, Return detected",
+	}
+	gpsRefNames = map[string]string{"N": "North", "S": "South", "E": "East", "W": "West"}
+)
 
-	// Helper to get string value
-	getString := func(key string) string {
-		if v, ok := raw[key]; ok {
-			return fmt.Sprintf("%v", v)
-		}
-		return ""
+// rawString returns the go-exif formatted value of a tag, "" when absent
+func rawString(raw map[string]interface{}, key string) string {
+	if v, ok := raw[key]; ok {
+		return strings.TrimSpace(fmt.Sprintf("%v", v))
 	}
+	return ""
+}
 
-	// Helper to get int value from "[n]" string
-	getInt := func(key string) (int, bool) {
-		s := getString(key)
-		s = strings.Trim(s, "[]")
-		if i, err := strconv.Atoi(s); err == nil {
-			return i, true
-		}
-		return 0, false
-	}
+// rawInt returns the integer value of a tag formatted as "[n]" or "n"
+func rawInt(raw map[string]interface{}, key string) (int, bool) {
+	i, err := strconv.Atoi(strings.Trim(rawString(raw, key), "[]"))
+	return i, err == nil
+}
 
-	// 1. Aperture & FNumber
-	if val := getString("FNumber"); val != "" {
-		if f, err := parseRational(val); err == nil {
-			normalized["FNumber"] = f
-			normalized["Aperture"] = f
+// normalizeExif converts go-exif values to the fields of exiftool -json
+func normalizeExif(raw map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{})
+	setString := func(field, key string) {
+		if val := rawString(raw, key); val != "" {
+			normalized[field] = val
 		}
 	}
-
-	// 2. ExposureTime & ShutterSpeed
-	if val := getString("ExposureTime"); val != "" {
-		normalized["ExposureTime"] = formatExposure(val)
-		normalized["ShutterSpeed"] = formatExposure(val)
-	}
-
-	// 3. FocalLength
-	if val := getString("FocalLength"); val != "" {
-		if f, err := parseRational(val); err == nil {
-			s := fmt.Sprintf("%.1f mm", f)
-			normalized["FocalLength"] = s
-			normalized["FocalLengthIn35mmFormat"] = strings.Replace(s, ".0 mm", " mm", 1)
+	setInt := func(field, key string) {
+		if i, ok := rawInt(raw, key); ok {
+			normalized[field] = i
 		}
 	}
-	// Override FocalLengthIn35mmFormat if available directly
-	if val := getString("FocalLengthIn35mmFilm"); val != "" {
-		if f, err := parseRational(val); err == nil {
-			s := fmt.Sprintf("%.0f mm", f)
-			normalized["FocalLengthIn35mmFormat"] = s
+	setEnum := func(field string, names map[int]string) {
+		if i, ok := rawInt(raw, field); ok {
+			normalized[field] = enumName(names, i)
 		}
 	}
 
-	// 4. ISO
-	if val := getString("ISOSpeedRatings"); val != "" {
-		// Format: "[320]"
-		val = strings.Trim(val, "[]")
-		if i, err := strconv.Atoi(val); err == nil {
-			normalized["ISO"] = i
+	// 1. Text and dates; exiftool names DateTimeDigitized CreateDate
+	setString("Make", "Make")
+	setString("Model", "Model")
+	setString("LensModel", "LensModel")
+	setString("Lens", "LensModel")
+	setString("ImageDescription", "ImageDescription")
+	setString("Artist", "Artist")
+	setString("Copyright", "Copyright")
+	setString("DateTimeOriginal", "DateTimeOriginal")
+	setString("CreateDate", "DateTimeDigitized")
+	setString("OffsetTime", "OffsetTime")
+	setString("OffsetTimeOriginal", "OffsetTimeOriginal")
+
+	// 2. Aperture & FNumber, Aperture is the composite of FNumber
+	if f, err := parseRational(rawString(raw, "FNumber")); err == nil && f > 0 {
+		normalized["FNumber"] = formatFNumber(f)
+		normalized["Aperture"] = formatFNumber(f)
+	}
+
+	// 3. ExposureTime & ShutterSpeed, ShutterSpeed is the composite of ExposureTime
+	if secs, err := parseRational(rawString(raw, "ExposureTime")); err == nil && secs > 0 {
+		normalized["ExposureTime"] = formatExposure(secs)
+		normalized["ShutterSpeed"] = formatExposure(secs)
+	}
+
+	// 4. FocalLength, the 35mm equivalent only when recorded
+	if f, err := parseRational(rawString(raw, "FocalLength")); err == nil {
+		normalized["FocalLength"] = fmt.Sprintf("%.1f mm", f)
+	}
+	if i, ok := rawInt(raw, "FocalLengthIn35mmFilm"); ok {
+		normalized["FocalLengthIn35mmFormat"] = fmt.Sprintf("%d mm", i)
+	}
+
+	// 5. Numbers
+	setInt("ISO", "ISOSpeedRatings")
+	if _, ok := normalized["ISO"]; !ok {
+		setInt("ISO", "RecommendedExposureIndex")
+	}
+	setInt("Rating", "Rating")
+	if i, ok := rawInt(raw, "Orientation"); ok && i >= 1 && i <= 8 {
+		normalized["Orientation"] = i
+	}
+
+	// 6. Enumerations
+	setEnum("ExposureMode", exposureModeNames)
+	setEnum("ExposureProgram", exposureProgramNames)
+	setEnum("MeteringMode", meteringModeNames)
+	setEnum("WhiteBalance", whiteBalanceNames)
+	setEnum("SceneCaptureType", sceneCaptureTypeNames)
+	setEnum("Flash", flashNames)
+
+	// 7. GPS, "37 deg 46' 29.70\" N" with the full name in the Ref field
+	for _, key := range []string{"GPSLatitude", "GPSLongitude"} {
+		ref := rawString(raw, key+"Ref")
+		if formatted, err := formatGPS(rawString(raw, key), ref); err == nil {
+			normalized[key] = formatted
+			if name, ok := gpsRefNames[ref]; ok {
+				normalized[key+"Ref"] = name
+			}
 		}
-	} else if val := getString("RecommendedExposureIndex"); val != "" {
-		val = strings.Trim(val, "[]")
-		if i, err := strconv.Atoi(val); err == nil {
-			normalized["ISO"] = i
+	}
+	if alt, err := parseRational(rawString(raw, "GPSAltitude")); err == nil {
+		suffix := "Above Sea Level"
+		if ref, ok := rawInt(raw, "GPSAltitudeRef"); ok && ref == 1 {
+			suffix = "Below Sea Level"
 		}
+		alt = math.Trunc(alt*10) / 10
+		normalized["GPSAltitude"] = strconv.FormatFloat(alt, 'f', -1, 64) + " m " + suffix
 	}
 
-	// 5. Lens
-	if val := getString("LensModel"); val != "" {
-		normalized["LensModel"] = val
-		normalized["Lens"] = val
+	// 8. GPS time, UTC as "13:15:08" with the date as written, "2024:12:31"
+	if formatted, err := formatGPSTime(rawString(raw, "GPSTimeStamp")); err == nil {
+		normalized["GPSTimeStamp"] = formatted
 	}
+	setString("GPSDateStamp", "GPSDateStamp")
 
-	// 6. Model & Make
-	if val := getString("Model"); val != "" {
-		normalized["Model"] = val
-	}
-	if val := getString("Make"); val != "" {
-		normalized["Make"] = val
+	// 9. Windows XP tags, as the fields exiftool fills from other tags
+	for _, f := range xpFields {
+		setString(f.field, f.tag)
 	}
 
-	// 7. DateTime
-	if val := getString("DateTimeOriginal"); val != "" {
-		normalized["DateTimeOriginal"] = val
-		normalized["CreateDate"] = val
-	}
+	return normalized
+}
 
-	// 8. GPS
-	if lat := getString("GPSLatitude"); lat != "" {
-		ref := getString("GPSLatitudeRef")
-		if formatted, err := formatGPS(lat, ref); err == nil {
-			normalized["GPSLatitude"] = formatted
-			// Legacy format included Ref in the string: "30 deg 33' 44.70\" N"
-			// And also had separate Ref field
-			normalized["GPSLatitudeRef"] = mapGPSRef(ref)
-		}
-	}
-	if lon := getString("GPSLongitude"); lon != "" {
-		ref := getString("GPSLongitudeRef")
-		if formatted, err := formatGPS(lon, ref); err == nil {
-			normalized["GPSLongitude"] = formatted
-			normalized["GPSLongitudeRef"] = mapGPSRef(ref)
-		}
-	}
-	if alt := getString("GPSAltitude"); alt != "" {
-		ref := getString("GPSAltitudeRef") // 0 = Above Sea Level, 1 = Below
-		if val, err := parseRational(alt); err == nil {
-			suffix := "Above Sea Level"
-			if ref == "1" || ref == "01" { // Check raw value
-				suffix = "Below Sea Level"
-			}
-			normalized["GPSAltitude"] = fmt.Sprintf("%.1f m %s", val, suffix)
-		}
-	}
+// xpFields maps the Windows XP tags to the fields of photos.json
+var xpFields = []struct{ tag, field string }{
+	{"XPTitle", "Title"},
+	{"XPKeywords", "Keywords"},
+	{"XPSubject", "Subject"},
+	{"XPComment", "Comment"},
+}
 
-	// 9. Enums mappings
-	if i, ok := getInt("ExposureMode"); ok {
-		// 0: Auto, 1: Manual, 2: Auto Bracket
-		switch i {
-		case 0:
-			normalized["ExposureMode"] = "Auto"
-		case 1:
-			normalized["ExposureMode"] = "Manual"
-		case 2:
-			normalized["ExposureMode"] = "Auto Bracket"
-		default:
-			normalized["ExposureMode"] = getString("ExposureMode")
+// applyXPTags moves the Windows XP tags of exiftool output to their fields, unless those are already set
+func applyXPTags(data map[string]interface{}) {
+	for _, f := range xpFields {
+		value, ok := data[f.tag]
+		if !ok {
+			continue
 		}
-	}
-
-	if i, ok := getInt("ExposureProgram"); ok {
-		// 0: Not Defined, 1: Manual, 2: Normal Program, 3: Aperture-priority AE, 4: Shutter speed priority AE
-		// 5: Creative (Slow speed), 6: Action (High speed), 7: Portrait, 8: Landscape
-		switch i {
-		case 1:
-			normalized["ExposureProgram"] = "Manual"
-		case 2:
-			normalized["ExposureProgram"] = "Normal program"
-		case 3:
-			normalized["ExposureProgram"] = "Aperture-priority AE"
-		case 4:
-			normalized["ExposureProgram"] = "Shutter speed priority AE"
-		case 5:
-			normalized["ExposureProgram"] = "Creative program"
-		case 6:
-			normalized["ExposureProgram"] = "Action program"
-		case 7:
-			normalized["ExposureProgram"] = "Portrait mode"
-		case 8:
-			normalized["ExposureProgram"] = "Landscape mode"
-		default:
-			normalized["ExposureProgram"] = getString("ExposureProgram")
+		delete(data, f.tag)
+		if _, ok := data[f.field]; !ok {
+			data[f.field] = value
 		}
 	}
+}
 
-	if i, ok := getInt("MeteringMode"); ok {
-		// 0: Unknown, 1: Average, 2: Center-weighted average, 3: Spot, 4: Multi-spot, 5: Multi-segment (Pattern), 6: Partial
-		switch i {
-		case 1:
-			normalized["MeteringMode"] = "Average"
-		case 2:
-			normalized["MeteringMode"] = "Center-weighted average"
-		case 3:
-			normalized["MeteringMode"] = "Spot"
-		case 4:
-			normalized["MeteringMode"] = "Multi-spot"
-		case 5:
-			normalized["MeteringMode"] = "Multi-segment"
-		case 6:
-			normalized["MeteringMode"] = "Partial"
-		default:
-			normalized["MeteringMode"] = getString("MeteringMode")
-		}
+// decodeUCS2 decodes a UCS-2 (UTF-16LE) byte slice to a UTF-8 string
+// Windows XP tags are stored as UCS-2, null-terminated.
+func decodeUCS2(b []byte) string {
+	if len(b)%2 != 0 {
+		return "" // Invalid length for UCS-2
 	}
 
-	if i, ok := getInt("Flash"); ok {
-		// Bitmask. 0 = No Flash.
-		if i == 0 {
-			normalized["Flash"] = "No Flash"
-		} else {
-			// Simplified mapping for common cases
-			if i&1 == 1 {
-				normalized["Flash"] = "Fired"
-			} else {
-				normalized["Flash"] = "Off, Did not fire"
-			}
-		}
+	runes := make([]rune, len(b)/2)
+	for i := 0; i < len(b); i += 2 {
+		// Little Endian
+		runes[i/2] = rune(uint16(b[i]) | uint16(b[i+1])<<8)
 	}
-
-	if i, ok := getInt("WhiteBalance"); ok {
-		// 0: Auto, 1: Manual
-		switch i {
-		case 0:
-			normalized["WhiteBalance"] = "Auto"
-		case 1:
-			normalized["WhiteBalance"] = "Manual"
-		default:
-			normalized["WhiteBalance"] = getString("WhiteBalance")
-		}
+	// Remove the trailing null characters; trimming bytes would cut the high byte of the last character
+	for len(runes) > 0 && runes[len(runes)-1] == 0 {
+		runes = runes[:len(runes)-1]
 	}
+	return string(runes)
+}
 
-	if i, ok := getInt("SceneCaptureType"); ok {
-		// 0: Standard, 1: Landscape, 2: Portrait, 3: Night Scene
-		switch i {
-		case 0:
-			normalized["SceneCaptureType"] = "Standard"
-		case 1:
-			normalized["SceneCaptureType"] = "Landscape"
-		case 2:
-			normalized["SceneCaptureType"] = "Portrait"
-		case 3:
-			normalized["SceneCaptureType"] = "Night Scene"
-		default:
-			normalized["SceneCaptureType"] = getString("SceneCaptureType")
+// filterExif 过滤字段,只保留白名单中的字段
+func filterExif(data map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{})
+	for key, value := range data {
+		if exifAllowedFields[key] {
+			filtered[key] = value
 		}
 	}
+	return filtered
+}
 
-	// Pass through others if needed or requested
-	if val := getString("Software"); val != "" {
-		normalized["Software"] = val
-	}
-	if val := getString("Artist"); val != "" {
-		normalized["Artist"] = val
-	}
-	if val := getString("Copyright"); val != "" {
-		normalized["Copyright"] = val
-	}
-
-	// Windows XP Tags Mapping
-	if val := getString("XPKeywords"); val != "" {
-		normalized["Keywords"] = val
-	}
-	if val := getString("XPSubject"); val != "" {
-		normalized["Subject"] = val
-	}
-	if val := getString("XPTitle"); val != "" {
-		normalized["Title"] = val
-	}
-	if val := getString("XPComment"); val != "" {
-		normalized["Comment"] = val
+// enumName returns the exiftool name of an enumeration value, "Unknown (n)" for values it does not know
+func enumName(names map[int]string, i int) string {
+	if name, ok := names[i]; ok {
+		return name
 	}
-
-	return normalized
+	return fmt.Sprintf("Unknown (%d)", i)
 }
 
 // parseRational parses "[n/d]" or "[n]" string to float64
@@ -515,28 +555,42 @@ func parseRational(s string) (float64, error) {
 	return 0, fmt.Errorf("invalid rational: %s", s)
 }
 
-// formatExposure formats exposure time (e.g. "1/200")
-func formatExposure(s string) string {
-	// Input: "[1/50]" or "[10/300]"
-	s = strings.Trim(s, "[]")
-	parts := strings.Split(s, "/")
-	if len(parts) == 2 {
-		n, _ := strconv.ParseFloat(parts[0], 64)
-		d, _ := strconv.ParseFloat(parts[1], 64)
-		if n == 1 {
-			return fmt.Sprintf("1/%.0f", d)
-		}
-		if n > 0 && d > 0 {
-			val := n / d
-			if val >= 1 {
-				return fmt.Sprintf("%.1f", val) // e.g. 1.5
-			}
-			// Try to find common denominator?
-			// Simple approach: 1 / (d/n)
-			return fmt.Sprintf("1/%.0f", d/n)
-		}
+// formatGPSTime formats a GPS time of day as exiftool does, "13:15:08" or "13:15:08.52"
+func formatGPSTime(raw string) (string, error) {
+	// Raw: "[13/1 15/1 852/100]"
+	parts := strings.Fields(strings.Trim(raw, "[]"))
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid gps time format")
+	}
+
+	h, err1 := parseRational(parts[0])
+	m, err2 := parseRational(parts[1])
+	sec, err3 := parseRational(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", fmt.Errorf("error parsing gps time components")
 	}
-	return s
+
+	seconds := strconv.FormatFloat(math.Round(sec*100)/100, 'f', -1, 64)
+	if sec < 10 {
+		seconds = "0" + seconds
+	}
+	return fmt.Sprintf("%02.0f:%02.0f:%s", h, m, seconds), nil
+}
+
+// formatFNumber rounds an f-number like exiftool: one decimal, two below f/1
+func formatFNumber(f float64) float64 {
+	if f < 1 {
+		return math.Round(f*100) / 100
+	}
+	return math.Round(f*10) / 10
+}
+
+// formatExposure formats an exposure time like exiftool: "1/200" up to 1/4 s, seconds with one decimal above
+func formatExposure(secs float64) interface{} {
+	if secs < 0.25001 {
+		return fmt.Sprintf("1/%d", int(0.5+1/secs))
+	}
+	return math.Round(secs*10) / 10
 }
 
 // formatGPS formats GPS coordinates to "30 deg 33' 44.70\" N"
@@ -556,37 +610,9 @@ func formatGPS(raw, ref string) (string, error) {
 		return "", fmt.Errorf("error parsing gps components")
 	}
 
-	ref = mapGPSRef(ref)
-
-	return fmt.Sprintf("%.0f deg %.0f' %.2f\" %s", deg, min, sec, ref), nil
-}
-
-func mapGPSRef(ref string) string {
-	switch ref {
-	case "N", "S", "E", "W":
-		return ref // Already correct
-	case "0":
-		return "North" // Or undefined? Usually N/S/E/W in EXIF
-	case "1":
-		return "South"
-		// Add more if needed based on raw values
-	}
-	// Sometimes ref is "N" or "S" directly
-	if ref == "North" || ref == "South" || ref == "East" || ref == "West" {
-		return ref
-	}
-	// Map single letters to full names if legacy requires full names?
-	// Legacy example: "GPSLatitudeRef": "North"
-	// Legacy example: "GPSLatitude": "30 deg ... N" (Wait, legacy example has "N" in string, but "North" in Ref field)
-	switch ref {
-	case "N":
-		return "North"
-	case "S":
-		return "South"
-	case "E":
-		return "East"
-	case "W":
-		return "West"
-	}
-	return ref
+	formatted := fmt.Sprintf("%.0f deg %.0f' %.2f\"", deg, min, sec)
+	if ref != "" {
+		formatted += " " + ref
+	}
+	return formatted, nil
 }
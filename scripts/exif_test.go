@@ -0,0 +1,127 @@
+package scripts
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// updateGolden rewrites the golden files from exiftool: go test ./scripts -run TestExifGolden -update
+var updateGolden = flag.Bool("update", false, "rewrite testdata/exif/*.golden.json with exiftool")
+
+// exifGolden is what an extractor returns for one image, as stored in testdata/exif/<name>.golden.json
+type exifGolden struct {
+	Width     int                    `json:"width"`
+	Height    int                    `json:"height"`
+	DateTaken string                 `json:"dateTaken,omitempty"`
+	Exif      map[string]interface{} `json:"exif"`
+}
+
+// newExifGolden builds the golden form of an extraction, with values as they appear in JSON
+func newExifGolden(t *testing.T, exifData map[string]interface{}, width, height int, dateTaken time.Time) exifGolden {
+	golden := exifGolden{Width: width, Height: height, Exif: map[string]interface{}{}}
+	if !dateTaken.IsZero() {
+		golden.DateTaken = dateTaken.Format(time.RFC3339)
+	}
+	data, err := json.Marshal(exifData)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &golden.Exif))
+	return golden
+}
+
+// TestExifGolden tests that the go-exif extractor returns what exiftool returns for the sample images
+func TestExifGolden(t *testing.T) {
+	images, err := filepath.Glob(filepath.Join("testdata", "exif", "*.jpg"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, images)
+
+	if *updateGolden {
+		if _, err := exec.LookPath("exiftool"); err != nil {
+			t.Skip("exiftool is required to update the golden files")
+		}
+	}
+
+	for _, image := range images {
+		goldenPath := strings.TrimSuffix(image, filepath.Ext(image)) + ".golden.json"
+		t.Run(filepath.Base(image), func(t *testing.T) {
+			if *updateGolden {
+				exifData, width, height, dateTaken, err := extractExifWithTool(t.Context(), image)
+				assert.NoError(t, err)
+				data, err := json.MarshalIndent(newExifGolden(t, exifData, width, height, dateTaken), "", "  ")
+				assert.NoError(t, err)
+				assert.NoError(t, os.WriteFile(goldenPath, append(data, '\n'), 0644))
+				return
+			}
+
+			content, err := os.ReadFile(goldenPath)
+			assert.NoError(t, err)
+			var want exifGolden
+			assert.NoError(t, json.Unmarshal(content, &want))
+			if want.Exif == nil {
+				want.Exif = map[string]interface{}{}
+			}
+
+			exifData, width, height, dateTaken, err := (&GoExifExtractor{}).Extract(t.Context(), image)
+			assert.NoError(t, err)
+			assert.Equal(t, want, newExifGolden(t, exifData, width, height, dateTaken))
+		})
+	}
+}
+
+// TestNormalizeExif tests the exiftool print conversions of values the sample images do not cover
+func TestNormalizeExif(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			"Fast lens and long exposure",
+			map[string]interface{}{"FNumber": "[95/100]", "ExposureTime": "[13/10]"},
+			map[string]interface{}{"FNumber": 0.95, "Aperture": 0.95, "ExposureTime": 1.3, "ShutterSpeed": 1.3},
+		},
+		{
+			"Exposure rounded to the nearest fraction",
+			map[string]interface{}{"ExposureTime": "[10/3000]"},
+			map[string]interface{}{"ExposureTime": "1/300", "ShutterSpeed": "1/300"},
+		},
+		{
+			"Unknown enumeration values",
+			map[string]interface{}{"MeteringMode": "[7]", "Flash": "[2]", "ExposureProgram": "[9]"},
+			map[string]interface{}{"MeteringMode": "Unknown (7)", "Flash": "Unknown (2)", "ExposureProgram": "Bulb"},
+		},
+		{
+			"Red-eye flash and orientation out of range",
+			map[string]interface{}{"Flash": "[89]", "Orientation": "[9]"},
+			map[string]interface{}{"Flash": "Auto, Fired, Red-eye reduction"},
+		},
+		{
+			"ISO from the recommended exposure index",
+			map[string]interface{}{"RecommendedExposureIndex": "[3200]", "LensModel": "NIKKOR Z 24-70mm f/4 S"},
+			map[string]interface{}{"ISO": 3200, "LensModel": "NIKKOR Z 24-70mm f/4 S", "Lens": "NIKKOR Z 24-70mm f/4 S"},
+		},
+		{
+			"Windows XP tags and tags without an exiftool field",
+			map[string]interface{}{"Software": "Ver.01.60", "XPTitle": "Harbour", "Artist": "Vincent"},
+			map[string]interface{}{"Title": "Harbour", "Artist": "Vincent"},
+		},
+		{
+			"Latitude without a reference",
+			map[string]interface{}{"GPSLatitude": "[30/1 33/1 4470/100]"},
+			map[string]interface{}{"GPSLatitude": "30 deg 33' 44.70\""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeExif(tt.raw))
+		})
+	}
+}
@@ -2,19 +2,42 @@ package scripts
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/jpeg"
+	"image/png"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 
 	"github.com/chai2010/webp"
 	"golang.org/x/image/draw"
 )
 
+// Thumbnail formats
+const (
+	FormatWebP = "webp"
+	FormatAVIF = "avif" // Encoded with the external avifenc tool from libavif
+	FormatJPEG = "jpeg"
+)
+
+// formatExts maps thumbnail formats to file extensions
+var formatExts = map[string]string{
+	FormatWebP: ExtWebP,
+	FormatAVIF: ".avif",
+	FormatJPEG: ExtJPG,
+}
+
 // ThumbnailConfig holds configuration for thumbnail generation
 type ThumbnailConfig struct {
-	MaxWidth int
-	Quality  int // 1-100 for JPEG/WebP
+	MaxWidth int      `yaml:"max_width"`
+	Quality  int      `yaml:"quality"` // 1-100 for JPEG/WebP/AVIF
+	Widths   []int    `yaml:"widths"`  // Widths of the responsive renditions listed in srcset
+	Formats  []string `yaml:"formats"` // Formats of every rendition: webp, avif and/or jpeg
 }
 
 // DefaultThumbnailConfig returns the default thumbnail configuration
@@ -22,104 +45,302 @@ func DefaultThumbnailConfig() ThumbnailConfig {
 	return ThumbnailConfig{
 		MaxWidth: 800,
 		Quality:  85,
+		Widths:   []int{400, 800, 1600, 2400},
+		Formats:  []string{FormatWebP, FormatJPEG},
 	}
 }
 
-// GenerateThumbnail generates a WebP thumbnail from an image file
-func GenerateThumbnail(imagePath string, config ThumbnailConfig) ([]byte, error) {
-	// Read the image file
+// Rendition is one resized and encoded version of an image
+type Rendition struct {
+	Width     int
+	Height    int
+	Format    string
+	Data      []byte
+	Thumbnail bool // The WebP at ThumbnailWidth, the thumbnail of photos.json
+}
+
+// FormatContentType returns the MIME type of a thumbnail format
+func FormatContentType(format string) string {
+	return "image/" + format
+}
+
+// FormatExt returns the file extension of a thumbnail format
+func FormatExt(format string) string {
+	return formatExts[format]
+}
+
+// RenditionWidths returns the configured widths that fit an image of the given width, in ascending order.
+// Renditions are never upscaled: an image narrower than every configured width gets one rendition at its own width.
+func RenditionWidths(imageWidth int, widths []int) []int {
+	var fit []int
+	seen := make(map[int]bool)
+	for _, w := range widths {
+		if w > 0 && w <= imageWidth && !seen[w] {
+			fit = append(fit, w)
+			seen[w] = true
+		}
+	}
+	sort.Ints(fit)
+	if len(fit) == 0 && imageWidth > 0 && len(widths) > 0 {
+		fit = []int{imageWidth}
+	}
+	return fit
+}
+
+// ThumbnailWidth returns the width of the thumbnail of an image displayed at imageWidth: thumbnail.max_width,
+// or the image width when the image is narrower
+func ThumbnailWidth(imageWidth int, config ThumbnailConfig) int {
+	if imageWidth > 0 && imageWidth < config.MaxWidth {
+		return imageWidth
+	}
+	return config.MaxWidth
+}
+
+// GenerateRenditions decodes an image once and encodes every configured format
+// for every width returned by RenditionWidths, ordered by width and then by format.
+// The WebP at ThumbnailWidth, which serves as the thumbnail, is always among them, even when that width
+// or WebP is not configured.
+// Renditions are rotated and flipped according to the EXIF orientation (1-8), so widths are display widths.
+// Canceling ctx stops the encoding between renditions and ends a running avifenc.
+func GenerateRenditions(ctx context.Context, imagePath string, config ThumbnailConfig, orientation int) ([]Rendition, error) {
+	img, err := decodeImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	displayWidth, _ := OrientedSize(img.Bounds().Dx(), img.Bounds().Dy(), orientation)
+	thumbnailWidth := ThumbnailWidth(displayWidth, config)
+	configured := RenditionWidths(displayWidth, config.Widths)
+	var renditions []Rendition
+	for _, width := range RenditionWidths(displayWidth, append([]int{thumbnailWidth}, configured...)) {
+		var formats []string
+		if slices.Contains(configured, width) {
+			formats = config.Formats
+		}
+		if width == thumbnailWidth && !slices.Contains(formats, FormatWebP) {
+			formats = append(slices.Clip(formats), FormatWebP)
+		}
+
+		dst := resizeOriented(img, width, orientation)
+		for _, format := range formats {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			data, err := encodeImage(ctx, dst, format, config.Quality)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %dw %s rendition: %w", width, format, err)
+			}
+			renditions = append(
+				renditions,
+				Rendition{
+					Width: dst.Bounds().Dx(), Height: dst.Bounds().Dy(), Format: format, Data: data,
+					Thumbnail: width == thumbnailWidth && format == FormatWebP,
+				},
+			)
+		}
+	}
+	return renditions, nil
+}
+
+// imageSize reads the stored size of an image file from its header
+func imageSize(imagePath string) (int, int, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// decodeImage reads and decodes an image file
+func decodeImage(imagePath string) (image.Image, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open image: %w", err)
 	}
 	defer file.Close()
 
-	// Decode the image
 	img, _, err := image.Decode(file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image: %w", err)
 	}
+	return img, nil
+}
 
-	// Get original dimensions
+// resizeToWidth scales img to maxWidth keeping the aspect ratio, without upscaling
+func resizeToWidth(img image.Image, maxWidth int) *image.RGBA {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
 
-	// Calculate new dimensions maintaining aspect ratio
-	newWidth := config.MaxWidth
+	newWidth := maxWidth
 	newHeight := height * newWidth / width
-
-	// If image is already smaller, don't upscale
-	if width <= config.MaxWidth {
+	if width <= maxWidth {
 		newWidth = width
 		newHeight = height
 	}
 
-	// Create a new image with the target dimensions
-	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	return scaleImage(img, newWidth, newHeight)
+}
 
-	// Resize using high-quality interpolation
+// scaleImage scales img to width x height
+func scaleImage(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
 	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return dst
+}
 
-	// Encode to WebP
-	var buf bytes.Buffer
+// OrientedSize returns the displayed size of an image stored as width x height with the given EXIF orientation
+func OrientedSize(width, height, orientation int) (int, int) {
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+	return width, height
+}
 
-	// WebP encoding options
-	options := &webp.Options{
-		Lossless: false,
-		Quality:  float32(config.Quality),
+// resizeOriented scales img so that its displayed width is at most maxWidth, then applies the orientation.
+// Scaling first keeps the pixel shuffling of applyOrientation cheap.
+func resizeOriented(img image.Image, maxWidth, orientation int) *image.RGBA {
+	if orientation >= 5 && orientation <= 8 {
+		// The stored height becomes the displayed width, which must be exactly maxWidth;
+		// the stored width is rounded rather than truncated
+		bounds := img.Bounds()
+		if maxWidth >= bounds.Dy() {
+			return applyOrientation(scaleImage(img, bounds.Dx(), bounds.Dy()), orientation)
+		}
+		newWidth := max((maxWidth*bounds.Dx()+bounds.Dy()/2)/bounds.Dy(), 1)
+		return applyOrientation(scaleImage(img, newWidth, maxWidth), orientation)
 	}
+	return applyOrientation(resizeToWidth(img, maxWidth), orientation)
+}
 
-	err = webp.Encode(&buf, dst, options)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode WebP thumbnail: %w", err)
+// applyOrientation rotates and flips img from its stored to its displayed orientation.
+// Orientation values follow the EXIF specification; unknown values leave the image unchanged.
+func applyOrientation(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
 	}
 
-	return buf.Bytes(), nil
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := OrientedSize(w, h, orientation)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	// src returns the stored pixel shown at display position (x, y)
+	var src func(x, y int) (int, int)
+	switch orientation {
+	case 2: // Mirror horizontal
+		src = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // Rotate 180
+		src = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // Mirror vertical
+		src = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // Mirror horizontal and rotate 270 CW
+		src = func(x, y int) (int, int) { return y, x }
+	case 6: // Rotate 90 CW
+		src = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // Mirror horizontal and rotate 90 CW
+		src = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // Rotate 270 CW
+		src = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	origin := img.Bounds().Min
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			from := img.PixOffset(origin.X+sx, origin.Y+sy)
+			to := dst.PixOffset(x, y)
+			copy(dst.Pix[to:to+4], img.Pix[from:from+4])
+		}
+	}
+	return dst
 }
 
-// GenerateThumbnailJPEG generates a JPEG thumbnail (fallback option)
-func GenerateThumbnailJPEG(imagePath string, config ThumbnailConfig) ([]byte, error) {
-	// Read the image file
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open image: %w", err)
+// encodeImage encodes img in the given thumbnail format
+func encodeImage(ctx context.Context, img image.Image, format string, quality int) ([]byte, error) {
+	switch format {
+	case FormatWebP:
+		return encodeWebP(img, quality)
+	case FormatJPEG:
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatAVIF:
+		return encodeAVIF(ctx, img, quality)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
 	}
-	defer file.Close()
+}
 
-	// Decode the image
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %w", err)
+// encodeWebP encodes img as lossy WebP
+func encodeWebP(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: float32(quality)}); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	// Get original dimensions
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
+// encodeAVIF encodes img with avifenc, as there is no pure Go AVIF encoder.
+// The image is handed over as a lossless PNG in a temporary directory; canceling ctx kills avifenc.
+func encodeAVIF(ctx context.Context, img image.Image, quality int) ([]byte, error) {
+	if _, err := exec.LookPath("avifenc"); err != nil {
+		return nil, fmt.Errorf("avifenc not found in PATH, install libavif or remove avif from thumbnail.formats")
+	}
 
-	// Calculate new dimensions maintaining aspect ratio
-	newWidth := config.MaxWidth
-	newHeight := height * newWidth / width
+	dir, err := os.MkdirTemp("", "avif-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
 
-	// If image is already smaller, don't upscale
-	if width <= config.MaxWidth {
-		newWidth = width
-		newHeight = height
+	input := filepath.Join(dir, "input.png")
+	output := filepath.Join(dir, "output.avif")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(input, buf.Bytes(), 0644); err != nil {
+		return nil, err
 	}
 
-	// Create a new image with the target dimensions
-	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	cmd := exec.CommandContext(ctx, "avifenc", "-q", strconv.Itoa(quality), input, output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("avifenc failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return os.ReadFile(output)
+}
 
-	// Resize using high-quality interpolation
-	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+// GenerateThumbnailFormat generates a thumbnail no wider than config.MaxWidth in the given format,
+// rotated and flipped according to the EXIF orientation
+func GenerateThumbnailFormat(
+	ctx context.Context, imagePath string, config ThumbnailConfig, format string, orientation int,
+) ([]byte, error) {
+	img, err := decodeImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
 
-	// Encode to JPEG
-	var buf bytes.Buffer
-	err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: config.Quality})
+	data, err := encodeImage(ctx, resizeOriented(img, config.MaxWidth, orientation), format, config.Quality)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode JPEG thumbnail: %w", err)
+		return nil, fmt.Errorf("failed to encode %s thumbnail: %w", format, err)
 	}
+	return data, nil
+}
 
-	return buf.Bytes(), nil
+// GenerateThumbnail generates a WebP thumbnail from an image file
+func GenerateThumbnail(ctx context.Context, imagePath string, config ThumbnailConfig, orientation int) ([]byte, error) {
+	return GenerateThumbnailFormat(ctx, imagePath, config, FormatWebP, orientation)
+}
+
+// GenerateThumbnailJPEG generates a JPEG thumbnail (fallback option)
+func GenerateThumbnailJPEG(ctx context.Context, imagePath string, config ThumbnailConfig, orientation int) ([]byte, error) {
+	return GenerateThumbnailFormat(ctx, imagePath, config, FormatJPEG, orientation)
 }
@@ -0,0 +1,270 @@
+package scripts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRenditionWidths tests which configured widths apply to an image
+func TestRenditionWidths(t *testing.T) {
+	tests := []struct {
+		name       string
+		imageWidth int
+		widths     []int
+		expected   []int
+	}{
+		{"All fit", 3000, []int{400, 800, 1600, 2400}, []int{400, 800, 1600, 2400}},
+		{"No upscaling", 1200, []int{400, 800, 1600, 2400}, []int{400, 800}},
+		{"Exact width", 800, []int{400, 800, 1600}, []int{400, 800}},
+		{"Smaller than every width", 300, []int{400, 800}, []int{300}},
+		{"Unsorted with duplicates", 2000, []int{1600, 400, 1600}, []int{400, 1600}},
+		{"No widths configured", 2000, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, RenditionWidths(tt.imageWidth, tt.widths))
+		})
+	}
+}
+
+// TestGenerateRenditions tests the sizes of generated renditions
+func TestGenerateRenditions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_2025-01-02_test.jpg")
+	writeTestJPEG(t, path, 1000, 500)
+
+	config := DefaultThumbnailConfig()
+	config.Formats = []string{FormatWebP}
+	renditions, err := GenerateRenditions(t.Context(), path, config, 1)
+	assert.NoError(t, err)
+	assert.Len(t, renditions, 2)
+	assert.Equal(t, 400, renditions[0].Width)
+	assert.Equal(t, 200, renditions[0].Height)
+	assert.Equal(t, 800, renditions[1].Width)
+	assert.Equal(t, 400, renditions[1].Height)
+	assert.Less(t, len(renditions[0].Data), len(renditions[1].Data))
+}
+
+// TestGenerateRenditionsFormats tests that every width is encoded in every configured format
+func TestGenerateRenditionsFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_2025-01-02_test.jpg")
+	writeTestJPEG(t, path, 500, 500)
+
+	// Stand-in for avifenc that copies its input, so the plumbing can be tested without libavif
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nwhile [ $# -gt 2 ]; do shift; done\ncp \"$1\" \"$2\"\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(binDir, "avifenc"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := DefaultThumbnailConfig()
+	config.MaxWidth = 400
+	config.Widths = []int{400}
+	config.Formats = []string{FormatAVIF, FormatWebP, FormatJPEG}
+	renditions, err := GenerateRenditions(t.Context(), path, config, 1)
+	assert.NoError(t, err)
+	assert.Len(t, renditions, 3)
+
+	var formats []string
+	for _, r := range renditions {
+		formats = append(formats, r.Format)
+		assert.Equal(t, 400, r.Width)
+	}
+	assert.Equal(t, []string{FormatAVIF, FormatWebP, FormatJPEG}, formats)
+	assert.Equal(t, []byte("\x89PNG"), renditions[0].Data[:4])
+	assert.Equal(t, []byte("RIFF"), renditions[1].Data[:4])
+	assert.Equal(t, []byte{0xff, 0xd8}, renditions[2].Data[:2])
+}
+
+// TestGenerateRenditionsThumbnail tests that the WebP thumbnail is generated when its width or WebP
+// is not configured
+func TestGenerateRenditionsThumbnail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_2025-01-02_test.jpg")
+	writeTestJPEG(t, path, 1000, 500)
+
+	tests := []struct {
+		name    string
+		widths  []int
+		formats []string
+		want    []string
+	}{
+		{"Thumbnail among the renditions", []int{400, 800}, []string{FormatWebP, FormatJPEG}, []string{
+			"400w " + FormatWebP, "400w " + FormatJPEG, "800w " + FormatWebP, "800w " + FormatJPEG,
+		}},
+		{"Width not configured", []int{400}, []string{FormatJPEG}, []string{"400w " + FormatJPEG, "800w " + FormatWebP}},
+		{"WebP not configured", []int{800}, []string{FormatJPEG}, []string{"800w " + FormatJPEG, "800w " + FormatWebP}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultThumbnailConfig()
+			config.Widths = tt.widths
+			config.Formats = tt.formats
+			renditions, err := GenerateRenditions(t.Context(), path, config, 1)
+			assert.NoError(t, err)
+
+			var got []string
+			for _, r := range renditions {
+				got = append(got, fmt.Sprintf("%dw %s", r.Width, r.Format))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestGenerateRenditionsWithoutAvifenc tests the error when avifenc is not installed, when the configuration
+// is validated and when encoding
+func TestGenerateRenditionsWithoutAvifenc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_2025-01-02_test.jpg")
+	writeTestJPEG(t, path, 500, 500)
+	t.Setenv("PATH", t.TempDir())
+
+	config := DefaultThumbnailConfig()
+	config.Formats = []string{FormatAVIF}
+	cfg := DefaultConfig()
+	cfg.Thumbnail = config
+	assert.ErrorContains(t, cfg.Validate(), "avifenc is not in PATH")
+
+	_, err := GenerateRenditions(t.Context(), path, config, 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "avifenc")
+}
+
+// TestGenerateRenditionsCanceled tests that canceling the context ends a running avifenc
+func TestGenerateRenditionsCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_2025-01-02_test.jpg")
+	writeTestJPEG(t, path, 500, 500)
+
+	// Stand-in for an avifenc that hangs
+	binDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(binDir, "avifenc"), []byte("#!/bin/sh\nexec sleep 30\n"), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := DefaultThumbnailConfig()
+	config.Formats = []string{FormatAVIF}
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := GenerateRenditions(ctx, path, config, 1)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+// TestApplyOrientation tests where the stored top-left pixel ends up for all 8 EXIF orientations
+func TestApplyOrientation(t *testing.T) {
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		markerX     int
+		markerY     int
+	}{
+		{1, 2, 3, 0, 0},
+		{2, 2, 3, 1, 0},
+		{3, 2, 3, 1, 2},
+		{4, 2, 3, 0, 2},
+		{5, 3, 2, 0, 0},
+		{6, 3, 2, 2, 0},
+		{7, 3, 2, 2, 1},
+		{8, 3, 2, 0, 1},
+		{0, 2, 3, 0, 0},
+		{9, 2, 3, 0, 0},
+	}
+
+	red := color.RGBA{R: 255, A: 255}
+	for _, tt := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, 2, 3))
+		img.Set(0, 0, red)
+
+		got := applyOrientation(img, tt.orientation)
+		assert.Equal(t, tt.width, got.Bounds().Dx(), "orientation %d", tt.orientation)
+		assert.Equal(t, tt.height, got.Bounds().Dy(), "orientation %d", tt.orientation)
+		assert.Equal(t, red, got.RGBAAt(tt.markerX, tt.markerY), "orientation %d", tt.orientation)
+
+		w, h := OrientedSize(2, 3, tt.orientation)
+		assert.Equal(t, tt.width, w)
+		assert.Equal(t, tt.height, h)
+	}
+}
+
+// TestGenerateRenditionsOrientation tests that rotated photos get renditions at their display width
+func TestGenerateRenditionsOrientation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_2025-01-02_portrait.jpg")
+	writeTestJPEG(t, path, 1000, 500)
+
+	config := DefaultThumbnailConfig()
+	config.Formats = []string{FormatJPEG}
+	renditions, err := GenerateRenditions(t.Context(), path, config, 6)
+	assert.NoError(t, err)
+	// The 800w rendition would upscale the 500px display width, the thumbnail is at the display width
+	if !assert.Equal(t, 2, len(renditions)) {
+		return
+	}
+	assert.Equal(t, 400, renditions[0].Width)
+	assert.Equal(t, 800, renditions[0].Height)
+	assert.Equal(t, 500, renditions[1].Width)
+	assert.Equal(t, 1000, renditions[1].Height)
+	assert.Equal(t, FormatWebP, renditions[1].Format)
+
+	decoded, _, err := image.DecodeConfig(bytes.NewReader(renditions[0].Data))
+	assert.NoError(t, err)
+	assert.Equal(t, 400, decoded.Width)
+	assert.Equal(t, 800, decoded.Height)
+}
+
+// TestGenerateRenditionsOrientationOddRatio tests that rotated photos whose aspect ratio does not divide evenly
+// get renditions at exactly the requested display width
+func TestGenerateRenditionsOrientationOddRatio(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_2025-01-02_portrait.jpg")
+	writeTestJPEG(t, path, 1511, 1007)
+
+	config := DefaultThumbnailConfig()
+	config.Widths = []int{400, 800}
+	config.Formats = []string{FormatWebP}
+	for orientation := 5; orientation <= 8; orientation++ {
+		renditions, err := GenerateRenditions(t.Context(), path, config, orientation)
+		assert.NoError(t, err)
+		if !assert.Len(t, renditions, 2, "orientation %d", orientation) {
+			continue
+		}
+		for i, want := range []struct{ width, height int }{{400, 600}, {800, 1200}} {
+			assert.Equal(t, want.width, renditions[i].Width, "orientation %d", orientation)
+			assert.Equal(t, want.height, renditions[i].Height, "orientation %d", orientation)
+			decoded, _, err := image.DecodeConfig(bytes.NewReader(renditions[i].Data))
+			assert.NoError(t, err)
+			assert.Equal(t, want.width, decoded.Width, "orientation %d", orientation)
+		}
+	}
+}
+
+// TestParseOrientation tests the Orientation values produced by both extractors
+func TestParseOrientation(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected int
+	}{
+		{"Rotate 90 CW", 6},
+		{"Horizontal (normal)", 1},
+		{"Mirror horizontal and rotate 270 CW", 5},
+		{float64(8), 8},
+		{3, 3},
+		{"[7]", 7},
+		{"Unknown (0)", 0},
+		{float64(12), 0},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, parseOrientation(tt.value), "%v", tt.value)
+	}
+
+	assert.Equal(t, 1, ExifOrientation(nil))
+	assert.Equal(t, 6, ExifOrientation(map[string]interface{}{"Orientation": 6}))
+}
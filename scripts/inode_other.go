@@ -0,0 +1,10 @@
+//go:build !unix
+
+package scripts
+
+import "os"
+
+// fileInode returns 0 where inode numbers are not available; size and modification time still apply
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package scripts
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode number of a file, so that replacing a file with another one of the
+// same size and modification time is still noticed
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package scripts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// photoshopHeader starts the APP13 segment of a JPEG, which holds Photoshop image resources
+var photoshopHeader = []byte("Photoshop 3.0\x00")
+
+// photoshopIPTCResource is the image resource holding the IPTC-IIM records
+const photoshopIPTCResource = 0x0404
+
+// iptcDatasets names the IPTC-IIM application record (2) datasets that are read, as exiftool does
+var iptcDatasets = map[byte]string{
+	5:   "ObjectName",
+	25:  "Keywords",
+	80:  "By-line",
+	90:  "City",
+	101: "Country-PrimaryLocationName",
+	116: "CopyrightNotice",
+	120: "Caption-Abstract",
+}
+
+// iptcRepeatable lists the datasets that may occur more than once
+var iptcRepeatable = map[string]bool{"Keywords": true, "By-line": true}
+
+// iptcFields maps the IPTC datasets to the fields of photos.json
+var iptcFields = []struct{ dataset, field string }{
+	{"ObjectName", "Title"},
+	{"Caption-Abstract", "Description"},
+	{"By-line", "Artist"},
+	{"CopyrightNotice", "Copyright"},
+	{"City", "City"},
+	{"Country-PrimaryLocationName", "Country"},
+	{"Keywords", "Keywords"},
+}
+
+// applyIPTC moves the IPTC datasets in data to their fields, replacing the EXIF values of those fields.
+// The EXIF ImageDescription becomes the Description when nothing else provides one.
+func applyIPTC(data map[string]interface{}) {
+	if description, ok := data["ImageDescription"]; ok {
+		if _, ok := data["Description"]; !ok {
+			data["Description"] = description
+		}
+		delete(data, "ImageDescription")
+	}
+	for _, f := range iptcFields {
+		if value, ok := data[f.dataset]; ok {
+			delete(data, f.dataset)
+			data[f.field] = value
+		}
+	}
+}
+
+// readIPTC reads the IPTC-IIM datasets of a JPEG from its APP13 segments, named as by exiftool.
+// Repeated datasets with more than one value become a []string, as in exiftool -json.
+func readIPTC(r io.Reader) (map[string]interface{}, error) {
+	segments, err := readJPEGSegments(r, 0xED)
+	if err != nil {
+		return nil, err
+	}
+	var resources []byte
+	for _, segment := range segments {
+		if data, ok := bytes.CutPrefix(segment.Data, photoshopHeader); ok {
+			resources = append(resources, data...)
+		}
+	}
+	if resources == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	records, err := photoshopResource(resources, photoshopIPTCResource)
+	if err != nil {
+		return nil, err
+	}
+	return parseIIM(records)
+}
+
+// photoshopResource returns the data of an image resource from "8BIM" resource blocks, nil when absent
+func photoshopResource(data []byte, id uint16) ([]byte, error) {
+	for len(data) > 0 {
+		if len(data) < 8 || !bytes.HasPrefix(data, []byte("8BIM")) {
+			return nil, errors.New("invalid Photoshop image resource")
+		}
+		resourceID := binary.BigEndian.Uint16(data[4:6])
+		// Pascal string name, padded to an even size
+		pos := 6 + 1 + int(data[6])
+		pos += pos % 2
+		if pos+4 > len(data) {
+			return nil, errors.New("truncated Photoshop image resource")
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+		if size > len(data)-pos {
+			return nil, errors.New("truncated Photoshop image resource")
+		}
+		if resourceID == id {
+			return data[pos : pos+size], nil
+		}
+		pos += size + size%2
+		if pos > len(data) {
+			pos = len(data)
+		}
+		data = data[pos:]
+	}
+	return nil, nil
+}
+
+// parseIIM reads the datasets of iptcDatasets from IPTC-IIM records. Text is UTF-8 when record 1
+// declares it and otherwise taken as UTF-8 when valid, Latin-1 when not.
+func parseIIM(data []byte) (map[string]interface{}, error) {
+	values := make(map[string][]string)
+	declaredUTF8 := false
+	for i := 0; i < len(data); {
+		if data[i] == 0 { // Padding after the last record
+			break
+		}
+		if data[i] != 0x1C || i+5 > len(data) {
+			return nil, fmt.Errorf("invalid IPTC record at offset %d", i)
+		}
+		record, dataset := data[i+1], data[i+2]
+		length := int(binary.BigEndian.Uint16(data[i+3:]))
+		i += 5
+		if length&0x8000 != 0 { // Extended dataset, the low bits give the size of the length
+			n := length & 0x7FFF
+			if n > 4 || i+n > len(data) {
+				return nil, fmt.Errorf("invalid IPTC dataset length at offset %d", i)
+			}
+			length = 0
+			for _, b := range data[i : i+n] {
+				length = length<<8 | int(b)
+			}
+			i += n
+		}
+		if length > len(data)-i {
+			return nil, fmt.Errorf("truncated IPTC dataset %d:%d", record, dataset)
+		}
+		value := data[i : i+length]
+		i += length
+
+		switch {
+		case record == 1 && dataset == 90: // CodedCharacterSet, ESC % G is UTF-8
+			declaredUTF8 = bytes.Equal(value, []byte("\x1b%G"))
+		case record == 2:
+			name, ok := iptcDatasets[dataset]
+			if !ok {
+				continue
+			}
+			text := strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+			if !declaredUTF8 && !utf8.ValidString(text) {
+				text = decodeLatin1([]byte(text))
+			}
+			if text == "" {
+				continue
+			}
+			values[name] = append(values[name], text)
+		}
+	}
+
+	fields := make(map[string]interface{})
+	for name, v := range values {
+		if iptcRepeatable[name] && len(v) > 1 {
+			fields[name] = v
+		} else {
+			fields[name] = v[0]
+		}
+	}
+	return fields, nil
+}
+
+// decodeLatin1 decodes ISO 8859-1 text, the usual IPTC encoding of older software
+func decodeLatin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,93 @@
+package scripts
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// iimDataset encodes one IPTC-IIM dataset
+func iimDataset(record, dataset byte, value string) []byte {
+	out := []byte{0x1C, record, dataset, 0, 0}
+	binary.BigEndian.PutUint16(out[3:], uint16(len(value)))
+	return append(out, value...)
+}
+
+// TestParseIIM tests reading IPTC-IIM datasets in their encodings
+func TestParseIIM(t *testing.T) {
+	join := func(datasets ...[]byte) []byte {
+		var out []byte
+		for _, d := range datasets {
+			out = append(out, d...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			"Latin-1 without a declared character set",
+			join(iimDataset(2, 90, "M\xfcnchen"), iimDataset(2, 25, "Alps"), iimDataset(2, 200, "ignored")),
+			map[string]interface{}{"City": "München", "Keywords": "Alps"},
+			false,
+		},
+		{
+			"Declared UTF-8 and repeated datasets",
+			join(
+				iimDataset(1, 90, "\x1b%G"), iimDataset(2, 25, "travel"), iimDataset(2, 25, "sea"),
+				iimDataset(2, 5, "Harbour"), iimDataset(2, 5, "Second name"), iimDataset(2, 120, "  "),
+			),
+			map[string]interface{}{"Keywords": []string{"travel", "sea"}, "ObjectName": "Harbour"},
+			false,
+		},
+		{
+			"Extended dataset length and padding",
+			join([]byte{0x1C, 2, 120, 0x80, 0x02, 0x00, 0x03}, []byte("Cap"), []byte{0, 0}),
+			map[string]interface{}{"Caption-Abstract": "Cap"},
+			false,
+		},
+		{"Truncated dataset", iimDataset(2, 120, "Caption")[:8], nil, true},
+		{"Not a dataset", []byte("8BIM"), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := parseIIM(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, fields)
+		})
+	}
+}
+
+// TestApplyIPTC tests that IPTC datasets replace the EXIF fields they map to
+func TestApplyIPTC(t *testing.T) {
+	data := map[string]interface{}{
+		"ImageDescription": "OLYMPUS DIGITAL CAMERA",
+		"Artist":           "Camera owner",
+		"Copyright":        "EXIF copyright",
+		"By-line":          "Vincent",
+		"City":             "Sydney",
+		"Make":             "OLYMPUS",
+	}
+	applyIPTC(data)
+	assert.Equal(t, map[string]interface{}{
+		"Description": "OLYMPUS DIGITAL CAMERA",
+		"Artist":      "Vincent",
+		"Copyright":   "EXIF copyright",
+		"City":        "Sydney",
+		"Make":        "OLYMPUS",
+	}, data)
+
+	data = map[string]interface{}{"ImageDescription": "EXIF", "Caption-Abstract": "IPTC"}
+	applyIPTC(data)
+	assert.Equal(t, map[string]interface{}{"Description": "IPTC"}, data)
+}
@@ -0,0 +1,213 @@
+package scripts
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LocalStorage stores objects as files below a directory, using the key as relative path
+type LocalStorage struct {
+	dir     string
+	baseURL string
+	mu      sync.Mutex // Makes checking the conditions of a Put and replacing the file one step
+}
+
+// NewLocalStorage creates a LocalStorage rooted at dir.
+// The directory is created by the first Put, so dry runs leave the file system untouched.
+// Object URLs are baseURL followed by the key, "/" when baseURL is empty.
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("local storage directory must not be empty")
+	}
+	if baseURL == "" {
+		baseURL = "/"
+	}
+	return &LocalStorage{dir: dir, baseURL: baseURL}, nil
+}
+
+// Dir returns the root directory of the store
+func (l *LocalStorage) Dir() string {
+	return l.dir
+}
+
+// path maps a key to a file path, rejecting keys that would escape the root directory
+func (l *LocalStorage) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(l.dir, clean), nil
+}
+
+// Put writes body to a temporary file and renames it into place
+func (l *LocalStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	target, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return ObjectInfo{}, err
+	}
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		var stored *ObjectInfo
+		current, err := l.Head(ctx, key)
+		if err == nil {
+			stored = &current
+		} else if !errors.Is(err, ErrNotFound) {
+			return ObjectInfo{}, err
+		}
+		if err := checkPrecondition(key, stored, opts); err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: size, ETag: fmt.Sprintf("%x", hash.Sum(nil)), LastModified: info.ModTime()}, nil
+}
+
+// Head stats the file of an object; the ETag is the MD5 of its content, as for single part S3 uploads
+func (l *LocalStorage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	target, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(target)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	etag, err := calculateFileHash(target)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ETag: etag, LastModified: info.ModTime()}, nil
+}
+
+// Delete removes the file of an object
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Copy copies the file of an object
+func (l *LocalStorage) Copy(ctx context.Context, srcKey, dstKey string) (ObjectInfo, error) {
+	source, err := l.path(srcKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	file, err := os.Open(source)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer file.Close()
+	return l.Put(ctx, dstKey, file, PutOptions{})
+}
+
+// DeleteMany removes the files of several objects
+func (l *LocalStorage) DeleteMany(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := l.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List walks the directory and returns the objects below prefix; none before the first Put created it
+func (l *LocalStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(
+		l.dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil && path == l.dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(l.dir, path)
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+			if !strings.HasPrefix(key, prefix) || strings.HasPrefix(d.Name(), ".upload-") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", l.dir, err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// URL joins the base URL and the key
+func (l *LocalStorage) URL(key string) string {
+	return strings.TrimRight(l.baseURL, "/") + "/" + key
+}
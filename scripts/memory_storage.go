@@ -0,0 +1,151 @@
+package scripts
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in memory. It is safe for concurrent use.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+	baseURL string
+}
+
+// memoryObject is a stored object with its metadata
+type memoryObject struct {
+	data []byte
+	opts PutOptions
+	info ObjectInfo
+}
+
+// NewMemoryStorage creates an empty MemoryStorage; URLs are baseURL followed by the key
+func NewMemoryStorage(baseURL string) *MemoryStorage {
+	if baseURL == "" {
+		baseURL = "memory://"
+	}
+	return &MemoryStorage{objects: make(map[string]memoryObject), baseURL: baseURL}
+}
+
+// Put stores a copy of body
+func (m *MemoryStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to read body for %s: %w", key, err)
+	}
+
+	info := ObjectInfo{
+		Key:          key,
+		Size:         int64(len(data)),
+		ETag:         fmt.Sprintf("%x", md5.Sum(data)),
+		LastModified: time.Now(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var stored *ObjectInfo
+	if obj, ok := m.objects[key]; ok {
+		stored = &obj.info
+	}
+	if err := checkPrecondition(key, stored, opts); err != nil {
+		return ObjectInfo{}, err
+	}
+	opts.IfMatch, opts.IfNoneMatch = "", ""
+	m.objects[key] = memoryObject{data: data, opts: opts, info: info}
+	return info, nil
+}
+
+// Head returns the metadata of an object
+func (m *MemoryStorage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return obj.info, nil
+}
+
+// Delete removes an object
+func (m *MemoryStorage) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// Copy copies an object with its metadata
+func (m *MemoryStorage) Copy(ctx context.Context, srcKey, dstKey string) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[srcKey]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	obj.info.Key = dstKey
+	obj.info.LastModified = time.Now()
+	m.objects[dstKey] = obj
+	return obj.info, nil
+}
+
+// DeleteMany removes several objects
+func (m *MemoryStorage) DeleteMany(ctx context.Context, keys []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.objects, key)
+	}
+	return nil
+}
+
+// List returns the objects below prefix
+func (m *MemoryStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var objects []ObjectInfo
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// URL joins the base URL and the key
+func (m *MemoryStorage) URL(key string) string {
+	return m.baseURL + key
+}
+
+// Get returns the content and options of an object, for tests and previews
+func (m *MemoryStorage) Get(key string) ([]byte, PutOptions, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[key]
+	return obj.data, obj.opts, ok
+}
@@ -0,0 +1,432 @@
+package scripts
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetadataVersion is the layout version of PhotoMetadata; entries of an older version are rebuilt from exif
+const MetadataVersion = 1
+
+// exifDateFormat is the EXIF date format, captureDateFormat the one of PhotoMetadata without offset
+const (
+	exifDateFormat    = "2006:01:02 15:04:05"
+	captureDateFormat = "2006-01-02T15:04:05"
+)
+
+// PhotoMetadata is the typed metadata of a photo in photos.json. It is built from the fields the
+// extractors return, so every extractor yields the same values; LegacyExif writes it back as the
+// exif keys older versions of photos.json had.
+type PhotoMetadata struct {
+	Version     int           `json:"version"`
+	Camera      *CameraInfo   `json:"camera,omitempty"`
+	Lens        *LensInfo     `json:"lens,omitempty"`
+	Exposure    *ExposureInfo `json:"exposure,omitempty"`
+	GPS         *GPSInfo      `json:"gps,omitempty"`
+	Capture     *CaptureInfo  `json:"capture,omitempty"`
+	Orientation int           `json:"orientation,omitempty"` // 1-8 as in EXIF
+
+	Title                string   `json:"title,omitempty"`
+	Description          string   `json:"description,omitempty"`
+	Artist               string   `json:"artist,omitempty"`
+	Copyright            string   `json:"copyright,omitempty"`
+	City                 string   `json:"city,omitempty"`
+	Country              string   `json:"country,omitempty"`
+	Keywords             []string `json:"keywords,omitempty"`
+	HierarchicalKeywords []string `json:"hierarchicalKeywords,omitempty"` // "Places|France|Paris"
+	Rating               int      `json:"rating,omitempty"`               // -1 (rejected) to 5, 0 when unrated
+	Label                string   `json:"label,omitempty"`                // Color label
+}
+
+// CameraInfo is the camera a photo was taken with
+type CameraInfo struct {
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+}
+
+// LensInfo is the lens and focal length of a photo
+type LensInfo struct {
+	Model           string  `json:"model,omitempty"`
+	FocalLength     float64 `json:"focalLength,omitempty"`     // Millimeters
+	FocalLength35mm int     `json:"focalLength35mm,omitempty"` // 35mm equivalent in millimeters
+}
+
+// ExposureInfo is the exposure of a photo; the modes are the names exiftool prints
+type ExposureInfo struct {
+	FNumber      float64 `json:"fNumber,omitempty"`
+	ExposureTime float64 `json:"exposureTime,omitempty"` // Seconds
+	ISO          int     `json:"iso,omitempty"`
+	Program      string  `json:"program,omitempty"`
+	Mode         string  `json:"mode,omitempty"`
+	Metering     string  `json:"metering,omitempty"`
+	WhiteBalance string  `json:"whiteBalance,omitempty"`
+	Flash        string  `json:"flash,omitempty"`
+	SceneType    string  `json:"sceneType,omitempty"`
+}
+
+// GPSInfo is where a photo was taken, in decimal degrees (negative south and west) and meters
+type GPSInfo struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"` // Negative below sea level
+	Time      string   `json:"time,omitempty"`     // UTC time of the fix, 2006-01-02T15:04:05Z
+}
+
+// CaptureInfo is when a photo was taken, in the local time of the camera
+type CaptureInfo struct {
+	Original  string `json:"original,omitempty"`  // 2006-01-02T15:04:05
+	Digitized string `json:"digitized,omitempty"` // 2006-01-02T15:04:05
+	Offset    string `json:"offset,omitempty"`    // UTC offset of Original, "+08:00"; empty when unknown
+}
+
+// gpsDMSPattern matches coordinates as exiftool prints them, "37 deg 46' 29.70\" N"
+var gpsDMSPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?) deg (\d+(?:\.\d+)?)' (\d+(?:\.\d+)?)"(?: ([NSEW]))?$`)
+
+// leadingNumber matches the number a value like "50.0 mm" or "-5 m" starts with
+var leadingNumber = regexp.MustCompile(`^-?\d+(?:\.\d+)?`)
+
+// NewPhotoMetadata builds the typed metadata from the fields of an extractor or the exif of photos.json.
+// Values may have the types of either extractor or of JSON, e.g. ISO 400 or 400.0 and keywords as a string or a list.
+func NewPhotoMetadata(fields map[string]interface{}) PhotoMetadata {
+	m := PhotoMetadata{Version: MetadataVersion}
+	text := func(key string) string { return metaString(fields[key]) }
+	number := func(key string) float64 { f, _ := metaFloat(fields[key]); return f }
+
+	if camera := (CameraInfo{Make: text("Make"), Model: text("Model")}); camera != (CameraInfo{}) {
+		m.Camera = &camera
+	}
+
+	lens := LensInfo{
+		Model:           text("LensModel"),
+		FocalLength:     number("FocalLength"),
+		FocalLength35mm: int(number("FocalLengthIn35mmFormat")),
+	}
+	if lens.Model == "" {
+		lens.Model = text("Lens")
+	}
+	if lens != (LensInfo{}) {
+		m.Lens = &lens
+	}
+
+	exposure := ExposureInfo{
+		FNumber:      number("FNumber"),
+		ExposureTime: number("ExposureTime"),
+		ISO:          int(number("ISO")),
+		Program:      text("ExposureProgram"),
+		Mode:         text("ExposureMode"),
+		Metering:     text("MeteringMode"),
+		WhiteBalance: text("WhiteBalance"),
+		Flash:        text("Flash"),
+		SceneType:    text("SceneCaptureType"),
+	}
+	if exposure.FNumber == 0 {
+		exposure.FNumber = number("Aperture")
+	}
+	if exposure.ExposureTime == 0 {
+		exposure.ExposureTime = number("ShutterSpeed")
+	}
+	if exposure != (ExposureInfo{}) {
+		m.Exposure = &exposure
+	}
+
+	latitude, latOK := parseCoordinate(fields["GPSLatitude"], text("GPSLatitudeRef"))
+	longitude, lonOK := parseCoordinate(fields["GPSLongitude"], text("GPSLongitudeRef"))
+	if latOK && lonOK {
+		m.GPS = &GPSInfo{Latitude: latitude, Longitude: longitude}
+		if altitude, ok := parseAltitude(fields["GPSAltitude"]); ok {
+			m.GPS.Altitude = &altitude
+		}
+		m.GPS.Time = gpsTime(text("GPSDateStamp"), text("GPSTimeStamp"))
+	}
+
+	capture := CaptureInfo{
+		Original:  captureDate(text("DateTimeOriginal")),
+		Digitized: captureDate(text("CreateDate")),
+		Offset:    text("OffsetTimeOriginal"),
+	}
+	if capture.Offset == "" {
+		capture.Offset = text("OffsetTime")
+	}
+	if capture.Original == "" {
+		capture.Original = capture.Digitized
+	}
+	if capture.Original != "" {
+		m.Capture = &capture
+	}
+
+	m.Orientation = parseOrientation(fields["Orientation"])
+	m.Title = text("Title")
+	m.Description = text("Description")
+	m.Artist = text("Artist")
+	m.Copyright = text("Copyright")
+	m.City = text("City")
+	m.Country = text("Country")
+	// XMP keywords replace the IPTC ones, see xmpSupersedes
+	if m.Keywords = metaStrings(fields["Subject"]); m.Keywords == nil {
+		m.Keywords = metaStrings(fields["Keywords"])
+	}
+	m.HierarchicalKeywords = metaStrings(fields["HierarchicalSubject"])
+	m.Rating = int(number("Rating"))
+	m.Label = text("Label")
+	return m
+}
+
+// LegacyExif returns the metadata as the exif object of photos.json, with the keys and formats of
+// exiftool that gallery.js reads
+func (m PhotoMetadata) LegacyExif() map[string]interface{} {
+	exifData := make(map[string]interface{})
+	setString := func(key, value string) {
+		if value != "" {
+			exifData[key] = value
+		}
+	}
+
+	if m.Camera != nil {
+		setString("Make", m.Camera.Make)
+		setString("Model", m.Camera.Model)
+	}
+	if m.Lens != nil {
+		setString("LensModel", m.Lens.Model)
+		if m.Lens.FocalLength > 0 {
+			exifData["FocalLength"] = fmt.Sprintf("%.1f mm", m.Lens.FocalLength)
+		}
+		if m.Lens.FocalLength35mm > 0 {
+			exifData["FocalLengthIn35mmFormat"] = fmt.Sprintf("%d mm", m.Lens.FocalLength35mm)
+		}
+	}
+	if e := m.Exposure; e != nil {
+		if e.FNumber > 0 {
+			exifData["FNumber"] = formatFNumber(e.FNumber)
+			exifData["Aperture"] = formatFNumber(e.FNumber)
+		}
+		if e.ExposureTime > 0 {
+			exifData["ExposureTime"] = formatExposure(e.ExposureTime)
+			exifData["ShutterSpeed"] = formatExposure(e.ExposureTime)
+		}
+		if e.ISO > 0 {
+			exifData["ISO"] = e.ISO
+		}
+		setString("ExposureProgram", e.Program)
+		setString("ExposureMode", e.Mode)
+		setString("MeteringMode", e.Metering)
+		setString("WhiteBalance", e.WhiteBalance)
+		setString("Flash", e.Flash)
+		setString("SceneCaptureType", e.SceneType)
+	}
+	if g := m.GPS; g != nil {
+		exifData["GPSLatitude"], exifData["GPSLatitudeRef"] = formatCoordinate(g.Latitude, "N", "S")
+		exifData["GPSLongitude"], exifData["GPSLongitudeRef"] = formatCoordinate(g.Longitude, "E", "W")
+		if g.Altitude != nil {
+			suffix := "Above Sea Level"
+			if *g.Altitude < 0 {
+				suffix = "Below Sea Level"
+			}
+			exifData["GPSAltitude"] = strconv.FormatFloat(math.Abs(*g.Altitude), 'f', -1, 64) + " m " + suffix
+		}
+		if t, err := time.Parse(time.RFC3339Nano, g.Time); err == nil {
+			exifData["GPSDateStamp"] = t.Format("2006:01:02")
+			exifData["GPSTimeStamp"] = t.Format("15:04:05.99")
+		}
+	}
+	if c := m.Capture; c != nil {
+		setString("DateTimeOriginal", exifDate(c.Original))
+		setString("CreateDate", exifDate(c.Digitized))
+		setString("OffsetTimeOriginal", c.Offset)
+	}
+	if m.Orientation > 0 {
+		exifData["Orientation"] = m.Orientation
+	}
+
+	setString("Title", m.Title)
+	setString("Description", m.Description)
+	setString("Artist", m.Artist)
+	setString("Copyright", m.Copyright)
+	setString("City", m.City)
+	setString("Country", m.Country)
+	if len(m.Keywords) > 0 {
+		exifData["Keywords"] = m.Keywords
+	}
+	if len(m.HierarchicalKeywords) > 0 {
+		exifData["HierarchicalSubject"] = m.HierarchicalKeywords
+	}
+	if m.Rating != 0 {
+		exifData["Rating"] = m.Rating
+	}
+	setString("Label", m.Label)
+	return exifData
+}
+
+// CaptureTime returns the capture time of the camera, in its UTC offset when recorded and as UTC otherwise;
+// zero when unknown
+func (m PhotoMetadata) CaptureTime() time.Time {
+	if m.Capture == nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(captureDateFormat, m.Capture.Original)
+	if err != nil {
+		return time.Time{}
+	}
+	if offset, ok := parseOffset(m.Capture.Offset); ok {
+		return withOffset(t, offset)
+	}
+	return t
+}
+
+// SetMetadata sets the typed metadata of a photo and its legacy exif object
+func (photo *Photo) SetMetadata(m PhotoMetadata) {
+	photo.Metadata = &m
+	photo.Exif = m.LegacyExif()
+}
+
+// upgradeMetadata builds the typed metadata of entries written before it existed or with an older layout
+func upgradeMetadata(photo Photo) Photo {
+	if (photo.Metadata != nil && photo.Metadata.Version >= MetadataVersion) || photo.Exif == nil {
+		return photo
+	}
+	photo.SetMetadata(NewPhotoMetadata(photo.Exif))
+	return photo
+}
+
+// metaString returns a value as text; lists are joined with ", "
+func metaString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string, []interface{}:
+		return strings.Join(metaStrings(v), ", ")
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+// metaStrings returns a value that may be a single string or a list as a list, nil when empty
+func metaStrings(value interface{}) []string {
+	var items []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	switch v := value.(type) {
+	case []string:
+		for _, s := range v {
+			add(s)
+		}
+	case []interface{}:
+		for _, s := range v {
+			add(metaString(s))
+		}
+	default:
+		add(metaString(v))
+	}
+	return items
+}
+
+// metaFloat returns the number a value holds, from a number, a fraction like "1/200" or text like "50.0 mm"
+func metaFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		if n, d, ok := strings.Cut(v, "/"); ok {
+			num, err1 := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			den, err2 := strconv.ParseFloat(strings.TrimSpace(d), 64)
+			if err1 == nil && err2 == nil && den != 0 {
+				return num / den, true
+			}
+			return 0, false
+		}
+		if match := leadingNumber.FindString(strings.TrimSpace(v)); match != "" {
+			f, err := strconv.ParseFloat(match, 64)
+			return f, err == nil
+		}
+	}
+	return 0, false
+}
+
+// parseCoordinate converts a coordinate as exiftool prints it, or in decimal degrees, to signed decimal degrees
+func parseCoordinate(value interface{}, ref string) (float64, bool) {
+	var degrees float64
+	switch v := value.(type) {
+	case float64:
+		degrees = v
+	case string:
+		match := gpsDMSPattern.FindStringSubmatch(strings.TrimSpace(v))
+		if match == nil {
+			return 0, false
+		}
+		d, _ := strconv.ParseFloat(match[1], 64)
+		m, _ := strconv.ParseFloat(match[2], 64)
+		s, _ := strconv.ParseFloat(match[3], 64)
+		degrees = d + m/60 + s/3600
+		if match[4] != "" {
+			ref = match[4]
+		}
+	default:
+		return 0, false
+	}
+	if ref != "" && strings.ContainsAny(ref[:1], "SsWw") {
+		degrees = -math.Abs(degrees)
+	}
+	return degrees, true
+}
+
+// parseAltitude converts "123.4 m Above Sea Level" or a number of meters to meters, negative below sea level
+func parseAltitude(value interface{}) (float64, bool) {
+	altitude, ok := metaFloat(value)
+	if !ok {
+		return 0, false
+	}
+	if s, isString := value.(string); isString && strings.Contains(s, "Below") {
+		altitude = -math.Abs(altitude)
+	}
+	return altitude, true
+}
+
+// formatCoordinate formats signed decimal degrees as exiftool does, "37 deg 46' 29.70\" N", and the reference name
+func formatCoordinate(degrees float64, positive, negative string) (string, string) {
+	ref := positive
+	if degrees < 0 {
+		ref = negative
+	}
+	hundredths := int64(math.Round(math.Abs(degrees) * 3600 * 100)) // Hundredths of an arcsecond
+	d, rest := hundredths/360000, hundredths%360000
+	m, s := rest/6000, float64(rest%6000)/100
+	return fmt.Sprintf("%d deg %d' %.2f\" %s", d, m, s, ref), gpsRefNames[ref]
+}
+
+// captureDate converts an EXIF date to the PhotoMetadata format, "" when it cannot be parsed
+func captureDate(exifValue string) string {
+	t, err := time.Parse(exifDateFormat, exifValue)
+	if err != nil {
+		return ""
+	}
+	return t.Format(captureDateFormat)
+}
+
+// gpsTime combines the GPS date and time of day, which are UTC, to RFC 3339; "" when either is missing
+func gpsTime(date, timeOfDay string) string {
+	t, err := time.Parse(exifDateFormat, date+" "+timeOfDay)
+	if err != nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// exifDate converts a PhotoMetadata date back to the EXIF format
+func exifDate(value string) string {
+	t, err := time.Parse(captureDateFormat, value)
+	if err != nil {
+		return ""
+	}
+	return t.Format(exifDateFormat)
+}
@@ -0,0 +1,109 @@
+package scripts
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewPhotoMetadata tests that exiftool output, go-exif output and photos.json give the same metadata
+func TestNewPhotoMetadata(t *testing.T) {
+	goldens, err := filepath.Glob(filepath.Join("testdata", "exif", "*.golden.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, goldens)
+
+	for _, goldenPath := range goldens {
+		t.Run(filepath.Base(goldenPath), func(t *testing.T) {
+			content, err := os.ReadFile(goldenPath)
+			assert.NoError(t, err)
+			var golden exifGolden
+			assert.NoError(t, json.Unmarshal(content, &golden))
+
+			// The golden files hold the exiftool output as JSON types, the extractor returns Go types
+			image := strings.TrimSuffix(goldenPath, ".golden.json") + ".jpg"
+			exifData, _, _, _, err := (&GoExifExtractor{}).Extract(t.Context(), image)
+			assert.NoError(t, err)
+			metadata := NewPhotoMetadata(exifData)
+			assert.Equal(t, NewPhotoMetadata(golden.Exif), metadata)
+			assert.Equal(t, MetadataVersion, metadata.Version)
+
+			// The legacy keys are read back unchanged
+			assert.Equal(t, metadata, NewPhotoMetadata(metadata.LegacyExif()))
+		})
+	}
+}
+
+// TestNewPhotoMetadataValues tests the conversion of the printed values to typed ones
+func TestNewPhotoMetadataValues(t *testing.T) {
+	metadata := NewPhotoMetadata(map[string]interface{}{
+		"Make":                    "Apple",
+		"Lens":                    "iPhone 15 Pro back camera",
+		"FocalLength":             "6.9 mm",
+		"FocalLengthIn35mmFormat": "24 mm",
+		"Aperture":                1.8,
+		"ExposureTime":            "1/120",
+		"ISO":                     "1000",
+		"GPSLatitude":             "37 deg 46' 29.70\" N",
+		"GPSLongitude":            "122 deg 25' 9.84\" W",
+		"GPSAltitude":             "5 m Below Sea Level",
+		"CreateDate":              "2024:05:01 18:30:00",
+		"OffsetTime":              "-07:00",
+		"Orientation":             "Rotate 90 CW",
+		"Keywords":                "travel",
+		"Subject":                 []interface{}{"sea", "boats"},
+		"Rating":                  5.0,
+	})
+
+	assert.Equal(t, &CameraInfo{Make: "Apple"}, metadata.Camera)
+	assert.Equal(t, &LensInfo{Model: "iPhone 15 Pro back camera", FocalLength: 6.9, FocalLength35mm: 24}, metadata.Lens)
+	assert.Equal(t, &ExposureInfo{FNumber: 1.8, ExposureTime: 1.0 / 120, ISO: 1000}, metadata.Exposure)
+	if assert.NotNil(t, metadata.GPS) && assert.NotNil(t, metadata.GPS.Altitude) {
+		assert.InDelta(t, 37.774917, metadata.GPS.Latitude, 1e-6)
+		assert.InDelta(t, -122.419400, metadata.GPS.Longitude, 1e-6)
+		assert.Equal(t, -5.0, *metadata.GPS.Altitude)
+	}
+	assert.Equal(t, &CaptureInfo{Original: "2024-05-01T18:30:00", Digitized: "2024-05-01T18:30:00", Offset: "-07:00"}, metadata.Capture)
+	assert.Equal(t, 6, metadata.Orientation)
+	assert.Equal(t, []string{"sea", "boats"}, metadata.Keywords, "XMP keywords replace the IPTC ones")
+	assert.Equal(t, 5, metadata.Rating)
+
+	legacy := metadata.LegacyExif()
+	assert.Equal(t, "1/120", legacy["ShutterSpeed"])
+	assert.Equal(t, "37 deg 46' 29.70\" N", legacy["GPSLatitude"])
+	assert.Equal(t, "West", legacy["GPSLongitudeRef"])
+	assert.Equal(t, "5 m Below Sea Level", legacy["GPSAltitude"])
+	assert.Equal(t, "2024:05:01 18:30:00", legacy["DateTimeOriginal"])
+
+	assert.Equal(t, PhotoMetadata{Version: MetadataVersion}, NewPhotoMetadata(nil))
+}
+
+// TestRestorePhoto tests that entries written before the typed metadata get it when read back
+func TestRestorePhoto(t *testing.T) {
+	old := Photo{Filename: "DSC_a.jpg", Exif: map[string]interface{}{
+		"DateTimeOriginal": "2025:11:09 22:05:34",
+		"Model":            "NIKON Z 6_2",
+		"Subject":          "travel",
+		"ISO":              400.0,
+	}}
+
+	photo := restorePhoto(old)
+	if assert.NotNil(t, photo.Metadata) {
+		assert.Equal(t, &CameraInfo{Model: "NIKON Z 6_2"}, photo.Metadata.Camera)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"DateTimeOriginal": "2025:11:09 22:05:34",
+		"Model":            "NIKON Z 6_2",
+		"Keywords":         []string{"travel"},
+		"ISO":              400,
+	}, photo.Exif)
+	assert.Equal(t, int64(1762725934), photo.Timestamp)
+
+	// Current entries are kept as they are
+	current := photo
+	current.Exif = map[string]interface{}{"Model": "edited"}
+	assert.Equal(t, current.Exif, restorePhoto(current).Exif)
+}
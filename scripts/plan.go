@@ -0,0 +1,195 @@
+package scripts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SyncPlan describes everything a sync run would change, computed without any writes
+type SyncPlan struct {
+	New           []PlannedPhoto `json:"new"`
+	Changed       []PlannedPhoto `json:"changed"`
+	Unchanged     []string       `json:"unchanged"`
+	Deleted       []PlannedPhoto `json:"deleted"`
+	PutKeys       []string       `json:"putKeys"`
+	DeleteKeys    []string       `json:"deleteKeys"`
+	DeleteBlocked string         `json:"deleteBlocked,omitempty"` // Why the prune would abort, empty within the limits
+	PhotosJSON    PhotosJSONDiff `json:"photosJson"`
+	Failed        []PhotoFailure `json:"failed"`
+}
+
+// PlannedPhoto is a photo that a sync would upload or delete
+type PlannedPhoto struct {
+	Filename string `json:"filename"`
+	Year     string `json:"year"`
+	Hash     string `json:"hash,omitempty"`
+	OldHash  string `json:"oldHash,omitempty"`
+}
+
+// PhotosJSONDiff describes how photos.json would change and be published
+type PhotosJSONDiff struct {
+	Changed  bool           `json:"changed"`
+	Outcome  PublishOutcome `json:"outcome"`
+	Conflict string         `json:"conflict,omitempty"` // Why publishing would fail without -overwrite
+	AlbumDiff
+}
+
+// BuildSyncPlan compares the processed photos of a dry run against the existing metadata
+func (p *PhotoProcessor) BuildSyncPlan(
+	ctx context.Context, allPhotos []Photo, newAlbums []YearAlbum, existingContent []byte,
+) (*SyncPlan, error) {
+	plan := &SyncPlan{
+		New:        []PlannedPhoto{},
+		Changed:    []PlannedPhoto{},
+		Unchanged:  []string{},
+		Deleted:    []PlannedPhoto{},
+		PutKeys:    []string{},
+		DeleteKeys: []string{},
+		Failed:     []PhotoFailure{},
+	}
+
+	for _, photo := range allPhotos {
+		planned := PlannedPhoto{Filename: photo.Filename, Year: photo.Year, Hash: photo.Hash}
+		switch photo.Status {
+		case PhotoNew:
+			plan.New = append(plan.New, planned)
+		case PhotoChanged:
+			planned.OldHash = p.ExistingPhotos[photo.Filename].Hash
+			plan.Changed = append(plan.Changed, planned)
+		case PhotoStale:
+			// Listed with the failures
+			continue
+		default:
+			plan.Unchanged = append(plan.Unchanged, photo.Filename)
+			continue
+		}
+		if p.Storage != nil {
+			plan.PutKeys = append(plan.PutKeys, p.photoKeys(photo)...)
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, photo := range allPhotos {
+		present[photo.Filename] = true
+	}
+	for filename, existing := range p.ExistingPhotos {
+		if !present[filename] {
+			plan.Deleted = append(plan.Deleted, PlannedPhoto{Filename: filename, Year: existing.Year, OldHash: existing.Hash})
+		}
+	}
+	if p.Storage != nil {
+		keys, stored, err := p.OrphanKeys(ctx, allPhotos)
+		if err != nil {
+			return nil, err
+		}
+		plan.DeleteKeys = append(plan.DeleteKeys, keys...)
+		if err := p.Config.Prune.CheckDeletion(len(keys), stored); err != nil {
+			plan.DeleteBlocked = err.Error()
+		}
+	}
+
+	jsonData, err := json.Marshal(newAlbums)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	diff, err := DiffPhotosJSON(existingContent, jsonData)
+	if err != nil {
+		// An unreadable photos.json is replaced as a whole
+		fmt.Printf("⚠ Warning: %v\n", err)
+		diff = DiffAlbums(nil, newAlbums)
+	}
+	plan.PhotosJSON = PhotosJSONDiff{Changed: !JSONEqual(existingContent, jsonData), AlbumDiff: diff}
+	content := existingContent
+	plan.PhotosJSON.Outcome = PublishUnchanged
+	if plan.PhotosJSON.Changed {
+		content = jsonData
+		plan.PhotosJSON.Outcome = PublishLocalOnly
+	}
+	if p.Storage != nil {
+		current, _, err := p.RemotePhotosJSONCurrent(ctx, existingContent, content)
+		switch {
+		case errors.Is(err, ErrRemoteChanged):
+			plan.PhotosJSON.Conflict = err.Error()
+		case err != nil:
+			return nil, err
+		case !current:
+			plan.PhotosJSON.Outcome = PublishPublished
+			plan.PutKeys = append(plan.PutKeys, p.photosJSONKey())
+		}
+	}
+
+	for _, list := range [][]PlannedPhoto{plan.New, plan.Changed, plan.Deleted} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Filename < list[j].Filename })
+	}
+	sort.Strings(plan.Unchanged)
+	sort.Strings(plan.PutKeys)
+
+	return plan, nil
+}
+
+// PrintText writes the plan in a human readable form, the photos.json diff in ANSI colors when color is set
+func (plan *SyncPlan) PrintText(w io.Writer, color bool) {
+	fmt.Fprintln(w, "Sync plan (dry run, nothing was changed):")
+
+	printPhotos := func(title string, photos []PlannedPhoto) {
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(photos))
+		for _, photo := range photos {
+			fmt.Fprintf(w, "  %s [%s]\n", photo.Filename, photo.Year)
+		}
+	}
+	printKeys := func(title string, keys []string) {
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(keys))
+		for _, key := range keys {
+			fmt.Fprintf(w, "  %s\n", key)
+		}
+	}
+
+	printPhotos("+ New photos", plan.New)
+	printPhotos("~ Changed photos", plan.Changed)
+	printPhotos("- Deleted photos", plan.Deleted)
+	fmt.Fprintf(w, "\n= Unchanged photos: %d\n", len(plan.Unchanged))
+	if len(plan.Failed) > 0 {
+		fmt.Fprintf(w, "\n! Failed photos, previous entries kept where there are any (%d):\n", len(plan.Failed))
+		for _, failure := range plan.Failed {
+			fmt.Fprintf(w, "  %s: %s\n", failure.Filename, failure.Error)
+		}
+	}
+	printKeys("Storage keys to put", plan.PutKeys)
+	printKeys("Storage keys to delete", plan.DeleteKeys)
+	if plan.DeleteBlocked != "" {
+		fmt.Fprintf(w, "  ⚠ The sync would abort without -force: %s\n", plan.DeleteBlocked)
+	}
+
+	fmt.Fprintln(w)
+	if plan.PhotosJSON.Changed {
+		fmt.Fprintf(w, "photos.json: %s\n", plan.PhotosJSON.Summary())
+		plan.PhotosJSON.PrintText(w, color)
+	} else {
+		fmt.Fprintln(w, "photos.json: unchanged")
+	}
+	if plan.PhotosJSON.Conflict != "" {
+		fmt.Fprintf(w, "  ⚠ The sync would not publish without -overwrite: %s\n", plan.PhotosJSON.Conflict)
+	} else {
+		fmt.Fprintf(w, "  outcome: %s\n", plan.PhotosJSON.Outcome)
+	}
+}
+
+// WriteJSON writes the plan as indented JSON to path
+func (plan *SyncPlan) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling plan: %w", err)
+	}
+	data = append(data, '\n')
+
+	if err := os.WriteFile(filepath.Clean(path), data, 0644); err != nil {
+		return fmt.Errorf("error writing plan: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package scripts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prune modes
+const (
+	PruneTrash  = "trash"  // Move orphaned objects below the trash prefix, where restore can bring them back
+	PruneDelete = "delete" // Delete orphaned objects permanently
+)
+
+// trashBatchFormat names the trash batch of one prune run after its UTC start time
+const trashBatchFormat = "20060102T150405Z"
+
+var (
+	// ErrDeletionLimit is returned when a prune would remove more objects than prune.max_delete_* allow
+	ErrDeletionLimit = errors.New("deletion limit exceeded")
+	// ErrDeletionDeclined is returned when the confirmation before a prune is answered with no
+	ErrDeletionDeclined = errors.New("deletion not confirmed")
+)
+
+// ConfirmFunc asks the user a yes or no question
+type ConfirmFunc func(ctx context.Context, prompt string) bool
+
+// PruneConfig guards the removal of objects that no photo references
+type PruneConfig struct {
+	Mode             string `yaml:"mode"`               // trash or delete
+	MaxDeletePercent int    `yaml:"max_delete_percent"` // Abort when a larger share of the stored objects would go, 0 disables
+	MaxDeleteCount   int    `yaml:"max_delete_count"`   // Abort when more objects would go, 0 disables
+	TrashPrefix      string `yaml:"trash_prefix"`       // Below r2.base_prefix
+	TrashTTLDays     int    `yaml:"trash_ttl_days"`     // Trash batches are deleted after this many days, 0 keeps them
+}
+
+// DefaultPruneConfig returns the default prune safeguards
+func DefaultPruneConfig() PruneConfig {
+	return PruneConfig{Mode: PruneTrash, MaxDeletePercent: 25, TrashPrefix: "trash/", TrashTTLDays: 30}
+}
+
+// CheckDeletion returns ErrDeletionLimit when removing count of stored objects exceeds the limits
+func (c PruneConfig) CheckDeletion(count, stored int) error {
+	return c.checkRemoval("stored objects", count, stored)
+}
+
+// checkRemoval returns ErrDeletionLimit when removing count of total items exceeds the limits
+func (c PruneConfig) checkRemoval(items string, count, total int) error {
+	if c.MaxDeleteCount > 0 && count > c.MaxDeleteCount {
+		return fmt.Errorf(
+			"%w: %d %s would be removed, prune.max_delete_count is %d", ErrDeletionLimit, count, items, c.MaxDeleteCount,
+		)
+	}
+	if c.MaxDeletePercent > 0 && total > 0 && count*100 > c.MaxDeletePercent*total {
+		return fmt.Errorf(
+			"%w: %d of %d %s (%d%%) would be removed, prune.max_delete_percent is %d",
+			ErrDeletionLimit, count, total, items, count*100/total, c.MaxDeletePercent,
+		)
+	}
+	return nil
+}
+
+// CheckRemovals applies the prune limits to the photos a sync drops from photos.json and the state database,
+// whether or not it prunes: a failed mount or a wrong img_dir scans no photos, which would publish an empty
+// gallery and forget every upload. It refuses with ErrDeletionLimit unless Force is set.
+func (p *PhotoProcessor) CheckRemovals(jobs []Job, allPhotos []Photo) error {
+	kept := make(map[string]bool, len(allPhotos))
+	for _, photo := range allPhotos {
+		kept[photo.Filename] = true
+	}
+	removed := 0
+	for filename := range p.ExistingPhotos {
+		if !kept[filename] {
+			removed++
+		}
+	}
+
+	err := p.Config.Prune.checkRemoval("photos in photos.json", removed, len(p.ExistingPhotos))
+	if err == nil && p.State != nil {
+		err = p.Config.Prune.checkRemoval("photos in the state database", len(p.forgottenRecords(jobs)), p.State.Len())
+	}
+	if err != nil && p.Force {
+		fmt.Printf("⚠ %v, continuing because of -force\n", err)
+		return nil
+	}
+	return err
+}
+
+// TrashBatch is the set of objects moved to the trash by one prune
+type TrashBatch struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Keys []string  `json:"keys"` // Keys in the trash
+	Size int64     `json:"size"`
+}
+
+// trashPrefix returns the storage prefix that holds all trash batches
+func (p *PhotoProcessor) trashPrefix() string {
+	return p.Config.R2.BasePrefix + p.Config.Prune.TrashPrefix
+}
+
+// trashKey returns the key of an object in a trash batch
+func (p *PhotoProcessor) trashKey(batch, key string) string {
+	return p.trashPrefix() + batch + "/" + strings.TrimPrefix(key, p.Config.R2.BasePrefix)
+}
+
+// restoredKey returns the key an object in a trash batch was moved from
+func (p *PhotoProcessor) restoredKey(batch, trashKey string) string {
+	return p.Config.R2.BasePrefix + strings.TrimPrefix(trashKey, p.trashPrefix()+batch+"/")
+}
+
+// moveToTrash copies keys into a new trash batch and deletes the originals once every copy succeeded
+func (p *PhotoProcessor) moveToTrash(ctx context.Context, batch string, keys []string) error {
+	for _, key := range keys {
+		if _, err := p.Storage.Copy(ctx, key, p.trashKey(batch, key)); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to move %s to the trash: %w", key, err)
+		}
+	}
+	if err := p.Storage.DeleteMany(ctx, keys); err != nil {
+		return fmt.Errorf("error deleting objects: %w", err)
+	}
+	return nil
+}
+
+// TrashBatches lists the trash, oldest batch first
+func (p *PhotoProcessor) TrashBatches(ctx context.Context) ([]TrashBatch, error) {
+	if p.Storage == nil {
+		return nil, nil
+	}
+	objects, err := p.Storage.List(ctx, p.trashPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the trash: %w", err)
+	}
+
+	batches := make(map[string]*TrashBatch)
+	for _, obj := range objects {
+		name, _, ok := strings.Cut(strings.TrimPrefix(obj.Key, p.trashPrefix()), "/")
+		if !ok {
+			continue
+		}
+		batch, ok := batches[name]
+		if !ok {
+			batch = &TrashBatch{Name: name}
+			// Batches whose name is not a time are never purged
+			batch.Time, _ = time.Parse(trashBatchFormat, name)
+			batches[name] = batch
+		}
+		batch.Keys = append(batch.Keys, obj.Key)
+		batch.Size += obj.Size
+	}
+
+	list := make([]TrashBatch, 0, len(batches))
+	for _, batch := range batches {
+		list = append(list, *batch)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Restore moves the objects of a trash batch back to their original keys
+func (p *PhotoProcessor) Restore(ctx context.Context, batch TrashBatch) error {
+	for _, key := range batch.Keys {
+		target := p.restoredKey(batch.Name, key)
+		if _, err := p.Storage.Copy(ctx, key, target); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		fmt.Printf("✓ Restored %s\n", target)
+	}
+	if err := p.Storage.DeleteMany(ctx, batch.Keys); err != nil {
+		return fmt.Errorf("failed to empty trash batch %s: %w", batch.Name, err)
+	}
+	return nil
+}
+
+// PurgeTrash deletes the trash batches older than prune.trash_ttl_days and returns the number of objects removed
+func (p *PhotoProcessor) PurgeTrash(ctx context.Context, now time.Time) (int, error) {
+	ttl := p.Config.Prune.TrashTTLDays
+	if p.Storage == nil || ttl == 0 {
+		return 0, nil
+	}
+	batches, err := p.TrashBatches(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var expired []string
+	for _, batch := range batches {
+		if !batch.Time.IsZero() && now.Sub(batch.Time) > time.Duration(ttl)*24*time.Hour {
+			fmt.Printf("🟢 Purging trash batch %s (%d objects)\n", batch.Name, len(batch.Keys))
+			expired = append(expired, batch.Keys...)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	if err := p.Storage.DeleteMany(ctx, expired); err != nil {
+		return 0, fmt.Errorf("failed to purge the trash: %w", err)
+	}
+	return len(expired), nil
+}
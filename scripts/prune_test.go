@@ -0,0 +1,104 @@
+package scripts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckDeletion tests the prune limits
+func TestCheckDeletion(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        PruneConfig
+		count, stored int
+		blocked       bool
+	}{
+		{"Within the percentage", PruneConfig{MaxDeletePercent: 25}, 25, 100, false},
+		{"Above the percentage", PruneConfig{MaxDeletePercent: 25}, 26, 100, true},
+		{"Everything gone", PruneConfig{MaxDeletePercent: 25}, 40, 40, true},
+		{"Above the count", PruneConfig{MaxDeleteCount: 10}, 11, 1000, true},
+		{"Limits disabled", PruneConfig{}, 40, 40, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.CheckDeletion(tt.count, tt.stored)
+			if tt.blocked {
+				assert.ErrorIs(t, err, ErrDeletionLimit)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestPruneTrash tests the limits, the confirmation, moving to the trash, restoring and purging
+func TestPruneTrash(t *testing.T) {
+	storage := NewMemoryStorage("")
+	p := &PhotoProcessor{Config: DefaultConfig(), Storage: storage}
+	keys := []string{"photos/originals/aaaaaaaa/DSC_a.jpg", "photos/thumbnails/11111111/DSC_a.webp"}
+	for _, key := range keys {
+		_, err := putBytes(t.Context(), storage, key, []byte(key), PutOptions{ContentType: "image/jpeg"})
+		assert.NoError(t, err)
+	}
+
+	// Removing everything trips the limit unless forced
+	assert.ErrorIs(t, p.DeleteOrphans(t.Context(), keys, len(keys)), ErrDeletionLimit)
+	p.Force = true
+	var prompts []string
+	p.Confirm = func(_ context.Context, prompt string) bool {
+		prompts = append(prompts, prompt)
+		return false
+	}
+	assert.ErrorIs(t, p.DeleteOrphans(t.Context(), keys, len(keys)), ErrDeletionDeclined)
+	assert.Len(t, prompts, 1)
+	assert.Contains(t, prompts[0], "Move 2 orphaned files to photos/trash/")
+	for _, key := range keys {
+		exists, err := objectExists(t.Context(), storage, key)
+		assert.NoError(t, err)
+		assert.True(t, exists, "nothing is touched before the confirmation")
+	}
+
+	p.Confirm = func(context.Context, string) bool { return true }
+	assert.NoError(t, p.DeleteOrphans(t.Context(), keys, len(keys)))
+	batches, err := p.TrashBatches(t.Context())
+	assert.NoError(t, err)
+	assert.Len(t, batches, 1)
+	assert.Equal(t, "photos/trash/"+batches[0].Name+"/originals/aaaaaaaa/DSC_a.jpg", batches[0].Keys[0])
+	assert.WithinDuration(t, time.Now(), batches[0].Time, time.Minute)
+	for _, key := range keys {
+		exists, err := objectExists(t.Context(), storage, key)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	}
+
+	// Restoring brings the objects back with their metadata and empties the batch
+	assert.NoError(t, p.Restore(t.Context(), batches[0]))
+	for _, key := range keys {
+		_, opts, ok := storage.Get(key)
+		assert.True(t, ok)
+		assert.Equal(t, "image/jpeg", opts.ContentType)
+	}
+	batches, err = p.TrashBatches(t.Context())
+	assert.NoError(t, err)
+	assert.Empty(t, batches)
+
+	// Batches are purged once they are older than the TTL
+	assert.NoError(t, p.DeleteOrphans(t.Context(), keys[:1], len(keys)))
+	purged, err := p.PurgeTrash(t.Context(), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+	purged, err = p.PurgeTrash(t.Context(), time.Now().AddDate(0, 0, 31))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	// Delete mode removes the objects without keeping a copy
+	p.Config.Prune.Mode = PruneDelete
+	assert.NoError(t, p.DeleteOrphans(t.Context(), keys[1:], len(keys)))
+	objects, err := storage.List(t.Context(), "photos/")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+}
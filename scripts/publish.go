@@ -0,0 +1,206 @@
+package scripts
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PublishOutcome tells what publishing photos.json changed
+type PublishOutcome string
+
+// Publish outcomes
+const (
+	PublishUnchanged PublishOutcome = "unchanged"  // The local file and the remote copy were up to date
+	PublishLocalOnly PublishOutcome = "local-only" // The local file was written, the remote copy was not updated
+	PublishPublished PublishOutcome = "published"  // The remote copy was updated
+)
+
+// ErrRemoteChanged is returned when the remote photos.json is not the version last published from this machine
+var ErrRemoteChanged = errors.New("remote photos.json was changed elsewhere")
+
+// PublishRecord is the version of photos.json last uploaded to one backend from this machine
+type PublishRecord struct {
+	Key         string    `json:"key"`
+	ETag        string    `json:"etag"` // MD5 of the content for single part uploads
+	Size        int64     `json:"size"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// PublishRecordPath returns the file that keeps the publish records, next to the state database
+func (c *Config) PublishRecordPath(rootDir string) string {
+	path := c.StatePath(rootDir)
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".publish.json"
+}
+
+// contentETag returns the ETag a single part upload of content gets
+func contentETag(content []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(content))
+}
+
+// publishRecords loads the publish records by backend; a missing or unreadable file yields none
+func (p *PhotoProcessor) publishRecords() map[string]PublishRecord {
+	records := make(map[string]PublishRecord)
+	content, err := os.ReadFile(p.Config.PublishRecordPath(p.RootDir))
+	if err != nil {
+		return records
+	}
+	if err := json.Unmarshal(content, &records); err != nil {
+		fmt.Printf("⚠ Ignoring invalid %s: %v\n", p.Config.PublishRecordPath(p.RootDir), err)
+	}
+	return records
+}
+
+// savePublishRecord records the upload of photos.json to the current backend
+func (p *PhotoProcessor) savePublishRecord(record PublishRecord) error {
+	records := p.publishRecords()
+	records[p.Config.Storage.Backend] = record
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.Config.PublishRecordPath(p.RootDir), append(data, '\n'), 0644)
+}
+
+// Publish brings photos.json up to date in four steps: compute the new content, compare it with the local
+// file and the remote copy, write the local file, upload the remote copy. Nothing is written when the
+// comparison fails, e.g. with ErrRemoteChanged. Without upload or storage only the local file is written.
+func (p *PhotoProcessor) Publish(
+	ctx context.Context, newAlbums []YearAlbum, existingContent []byte, upload bool,
+) (PublishOutcome, error) {
+	// Compute
+	jsonData, err := json.Marshal(newAlbums)
+	if err != nil {
+		return PublishUnchanged, fmt.Errorf("error marshaling JSON: %w", err)
+	}
+
+	// Compare; an unchanged local file keeps its bytes, so the remote copy is compared with those
+	localChanged := !JSONEqual(existingContent, jsonData)
+	content := existingContent
+	if localChanged {
+		content = jsonData
+	}
+	upload = upload && p.Storage != nil
+	remoteCurrent, storedETag := false, ""
+	if upload {
+		if remoteCurrent, storedETag, err = p.RemotePhotosJSONCurrent(ctx, existingContent, content); err != nil {
+			return PublishUnchanged, err
+		}
+	}
+	if !localChanged && (!upload || remoteCurrent) {
+		fmt.Println("✓ photos.json has not changed. Skipping backup, file write, and upload.")
+		return PublishUnchanged, nil
+	}
+
+	// Write local
+	if localChanged {
+		if diff, err := DiffPhotosJSON(existingContent, jsonData); err == nil {
+			fmt.Printf("🟢 photos.json: %s\n", diff.Summary())
+		}
+		if len(existingContent) > 0 {
+			if _, err := p.BackupPhotosJSON(existingContent, time.Now()); err != nil {
+				fmt.Printf("⚠ Warning: Could not back up %s: %v\n", p.Config.OutputFile, err)
+			}
+		}
+		if err := writeFileAtomic(p.OutputFilePath(), jsonData, 0644); err != nil {
+			return PublishUnchanged, fmt.Errorf("error writing output file: %w", err)
+		}
+	} else {
+		fmt.Printf("🟢 photos.json has not changed, but the copy in %s is out of date\n", p.Config.Storage.Backend)
+	}
+
+	// Upload remote
+	if !upload || remoteCurrent {
+		return PublishLocalOnly, nil
+	}
+	if err := p.UploadPhotosJSON(ctx, content, storedETag); err != nil {
+		return PublishLocalOnly, err
+	}
+	return PublishPublished, nil
+}
+
+// storedPhotosJSON returns the ETag of the stored photos.json, empty when none is stored
+func (p *PhotoProcessor) storedPhotosJSON(ctx context.Context) (string, error) {
+	info, err := p.Storage.Head(ctx, p.photosJSONKey())
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check the stored photos.json: %w", err)
+	}
+	return info.ETag, nil
+}
+
+// RemotePhotosJSONCurrent reports whether the stored photos.json already holds content, and returns the ETag
+// of the stored copy, empty when none is stored, for UploadPhotosJSON to replace exactly that copy.
+// A stored copy that differs from content fails with ErrRemoteChanged, unless Overwrite is set, when it is not
+// the version last published from here, or, without a publish record, not base, the local photos.json that
+// content was built from. This keeps a run with outdated local state from undoing another publish.
+func (p *PhotoProcessor) RemotePhotosJSONCurrent(ctx context.Context, base, content []byte) (bool, string, error) {
+	etag, err := p.storedPhotosJSON(ctx)
+	if err != nil || etag == "" {
+		return false, "", err
+	}
+	if etag == contentETag(content) {
+		return true, etag, nil
+	}
+
+	record, ok := p.publishRecords()[p.Config.Storage.Backend]
+	switch {
+	case ok && record.ETag != etag:
+		err = fmt.Errorf(
+			"%w: %s has ETag %s, but %s was published from here on %s",
+			ErrRemoteChanged, p.photosJSONKey(), etag, record.ETag, record.PublishedAt.Local().Format("2006-01-02 15:04"),
+		)
+	case !ok && etag != contentETag(base):
+		err = fmt.Errorf(
+			"%w: %s has ETag %s, which differs from the local %s, and no publish from here is recorded",
+			ErrRemoteChanged, p.photosJSONKey(), etag, p.Config.OutputFile,
+		)
+	}
+	if err != nil {
+		if !p.Overwrite {
+			return false, etag, err
+		}
+		fmt.Printf("⚠ %v, overwriting because of -overwrite\n", err)
+	}
+	return false, etag, nil
+}
+
+// UploadPhotosJSON uploads photos.json content to storage and records the version. The upload only replaces
+// the stored copy with storedETag, or only creates photos.json when storedETag is empty; a copy published
+// elsewhere in the meantime fails with ErrRemoteChanged.
+func (p *PhotoProcessor) UploadPhotosJSON(ctx context.Context, jsonData []byte, storedETag string) error {
+	if p.Storage == nil {
+		return nil
+	}
+
+	// PutOptions{ContentType: "application/json", CacheControl: "public, max-age=720, must-revalidate"},
+	opts := PutOptions{ContentType: "application/json", CacheControl: "public, max-age=720", IfMatch: storedETag}
+	if storedETag == "" {
+		opts.IfNoneMatch = "*"
+	}
+	info, err := putBytes(ctx, p.Storage, p.photosJSONKey(), jsonData, opts)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return fmt.Errorf("%w: %s was published elsewhere while uploading: %w", ErrRemoteChanged, p.photosJSONKey(), err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload photos.json: %w", err)
+	}
+	if info.ETag == "" {
+		info.ETag = contentETag(jsonData)
+	}
+	fmt.Printf("✓ Uploaded photos.json to %s (ETag %s)\n", p.Config.Storage.Backend, info.ETag)
+
+	record := PublishRecord{Key: p.photosJSONKey(), ETag: info.ETag, Size: int64(len(jsonData)), PublishedAt: time.Now().UTC()}
+	if err := p.savePublishRecord(record); err != nil {
+		fmt.Printf("⚠ Warning: Could not record the published version: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package scripts
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPublish tests the outcomes of publishing photos.json and the detection of a stored copy changed elsewhere
+func TestPublish(t *testing.T) {
+	storage := NewMemoryStorage("")
+	p := &PhotoProcessor{Config: DefaultConfig(), RootDir: t.TempDir(), Storage: storage}
+	first := []YearAlbum{{Year: "2024", Photos: []Photo{{Filename: "DSC_2024-01-01_a.jpg"}}}}
+	second := []YearAlbum{{Year: "2025", Photos: []Photo{{Filename: "DSC_2025-01-01_b.jpg"}}}}
+	third := []YearAlbum{{Year: "2025", Photos: []Photo{{Filename: "DSC_2025-02-01_c.jpg"}}}}
+	local := func() []byte {
+		content, err := os.ReadFile(p.OutputFilePath())
+		assert.NoError(t, err)
+		return content
+	}
+	stored := func() []byte {
+		content, _, ok := storage.Get(p.photosJSONKey())
+		assert.True(t, ok)
+		return content
+	}
+
+	steps := []struct {
+		name    string
+		albums  []YearAlbum
+		upload  bool
+		outcome PublishOutcome
+		backups int
+	}{
+		{"First publish", first, true, PublishPublished, 0},
+		{"Nothing changed", first, true, PublishUnchanged, 0},
+		{"Local only", second, false, PublishLocalOnly, 1},
+		{"Stored copy behind the local file", second, true, PublishPublished, 1},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			existing, _ := os.ReadFile(p.OutputFilePath())
+			outcome, err := p.Publish(t.Context(), step.albums, existing, step.upload)
+			assert.NoError(t, err)
+			assert.Equal(t, step.outcome, outcome)
+			if step.upload {
+				assert.Equal(t, local(), stored())
+			}
+			backups, err := p.Backups()
+			assert.NoError(t, err)
+			assert.Len(t, backups, step.backups)
+		})
+	}
+
+	// Another machine publishes; this one must not undo it with its outdated view
+	_, err := putBytes(t.Context(), storage, p.photosJSONKey(), []byte(`[]`), PutOptions{ContentType: "application/json"})
+	assert.NoError(t, err)
+	before := local()
+	outcome, err := p.Publish(t.Context(), third, before, true)
+	assert.ErrorIs(t, err, ErrRemoteChanged)
+	assert.Equal(t, PublishUnchanged, outcome)
+	assert.Equal(t, before, local(), "nothing is written when the comparison fails")
+	assert.Equal(t, []byte(`[]`), stored())
+
+	p.Overwrite = true
+	outcome, err = p.Publish(t.Context(), third, before, true)
+	assert.NoError(t, err)
+	assert.Equal(t, PublishPublished, outcome)
+	assert.Equal(t, local(), stored())
+	p.Overwrite = false
+
+	// Another machine publishes between the comparison and the upload
+	storedETag, err := p.storedPhotosJSON(t.Context())
+	assert.NoError(t, err)
+	_, err = putBytes(t.Context(), storage, p.photosJSONKey(), []byte(`[]`), PutOptions{ContentType: "application/json"})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, p.UploadPhotosJSON(t.Context(), local(), storedETag), ErrRemoteChanged)
+	assert.Equal(t, []byte(`[]`), stored())
+}
+
+// TestPublishWithoutRecord tests that a stored copy other than the local file is not replaced when nothing
+// was published from here
+func TestPublishWithoutRecord(t *testing.T) {
+	storage := NewMemoryStorage("")
+	p := &PhotoProcessor{Config: DefaultConfig(), RootDir: t.TempDir(), Storage: storage}
+	albums := []YearAlbum{{Year: "2025", Photos: []Photo{{Filename: "DSC_2025-01-01_b.jpg"}}}}
+	base := []byte(`[{"year":"2024","photos":[]}]`)
+	_, err := putBytes(t.Context(), storage, p.photosJSONKey(), []byte(`[]`), PutOptions{ContentType: "application/json"})
+	assert.NoError(t, err)
+
+	_, err = p.Publish(t.Context(), albums, base, true)
+	assert.ErrorIs(t, err, ErrRemoteChanged)
+
+	// The stored copy is the local file this run started from
+	_, err = putBytes(t.Context(), storage, p.photosJSONKey(), base, PutOptions{ContentType: "application/json"})
+	assert.NoError(t, err)
+	outcome, err := p.Publish(t.Context(), albums, base, true)
+	assert.NoError(t, err)
+	assert.Equal(t, PublishPublished, outcome)
+}
@@ -3,9 +3,13 @@ package scripts
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,68 +24,98 @@ const R2RequestTimeout = 360 * time.Second
 
 // R2Config holds the configuration for Cloudflare R2
 type R2Config struct {
-	Endpoint        string
-	Bucket          string
-	Region          string
-	AccessKeyID     string
-	SecretAccessKey string
-	CDNUrl          string
-	BasePrefix      string // e.g., "photos/"
-	OriginalPrefix  string // e.g., "originals/"
-	ThumbnailPrefix string // e.g., "thumbnails/"
+	Endpoint        string `yaml:"endpoint"`
+	Bucket          string `yaml:"bucket"`
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	CDNUrl          string `yaml:"cdn_url"`
+	BasePrefix      string `yaml:"base_prefix"`      // e.g., "photos/"
+	OriginalPrefix  string `yaml:"original_prefix"`  // e.g., "originals/"
+	ThumbnailPrefix string `yaml:"thumbnail_prefix"` // e.g., "thumbnails/"
+
+	MultipartThresholdMB int `yaml:"multipart_threshold_mb"` // Files at least this large are uploaded in parts
+	PartSizeMB           int `yaml:"part_size_mb"`           // Size of each part, at least 5
+	PartConcurrency      int `yaml:"part_concurrency"`       // Parts of one file uploaded at the same time
 }
 
 // R2Client wraps the S3 client for R2 operations
 type R2Client struct {
-	client *s3.Client
+	client s3API
 	config R2Config
 }
 
-// LoadR2Config loads R2 configuration from .env file
-func LoadR2Config() (*R2Config, error) {
-	// Try to load .env file from current directory or scripts directory
-	envPaths := []string{
-		".env",
-		"scripts/.env",
-		filepath.Join(
-			os.Getenv("HOME"), "Developer/code/go_code/src/github.com/vincenty1ung/vincenty1ung.github.io/scripts/.env",
-		),
+// ConfigDirName is the directory below $XDG_CONFIG_HOME searched for user-wide .env and photos.yaml files
+const ConfigDirName = "photo-gallery"
+
+// xdgConfigDir returns $XDG_CONFIG_HOME/photo-gallery, falling back to ~/.config/photo-gallery
+func xdgConfigDir() string {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, ConfigDirName)
+}
+
+// envFileCandidates lists the .env files searched, in order, when none is given explicitly
+func envFileCandidates() []string {
+	candidates := []string{".env", "scripts/.env"}
+	if dir := xdgConfigDir(); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, ".env"))
+	}
+	return candidates
+}
+
+// readEnvFile reads the variables of envFile, or of the first candidate that exists when envFile is empty.
+// The process environment is left untouched. Finding no candidate is not an error, a missing explicit file is.
+func readEnvFile(envFile string) (map[string]string, string, error) {
+	if envFile != "" {
+		vars, err := godotenv.Read(envFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load env file %s: %w", envFile, err)
+		}
+		return vars, envFile, nil
 	}
 
-	var err error
-	for _, path := range envPaths {
-		err = godotenv.Load(path)
-		if err == nil {
-			fmt.Printf("✓ Loaded .env from: %s\n", path)
-			break
+	for _, path := range envFileCandidates() {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		vars, err := godotenv.Read(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load env file %s: %w", path, err)
 		}
+		return vars, path, nil
 	}
+	return nil, "", nil
+}
 
+// LoadR2Config loads R2 configuration from environment variables and the first .env file found.
+// Variables set in the environment take precedence over the .env file, which is optional.
+func LoadR2Config() (*R2Config, error) {
+	vars, path, err := readEnvFile("")
 	if err != nil {
-		return nil, fmt.Errorf("failed to load .env file: %w", err)
-	}
-
-	// Read configuration from environment variables
-	config := &R2Config{
-		Endpoint:        getEnv("NUXT_PROVIDER_S3_ENDPOINT", "R2_ENDPOINT"),
-		Bucket:          getEnv("NUXT_PROVIDER_S3_BUCKET", "R2_BUCKET"),
-		Region:          getEnv("NUXT_PROVIDER_S3_REGION", "R2_REGION"),
-		AccessKeyID:     getEnv("NUXT_PROVIDER_S3_ACCESS_KEY_ID", "R2_ACCESS_KEY_ID"),
-		SecretAccessKey: getEnv("NUXT_PROVIDER_S3_SECRET_ACCESS_KEY", "R2_SECRET_ACCESS_KEY"),
-		CDNUrl:          getEnv("NUXT_PROVIDER_S3_CDN_URL", "R2_CDN_URL"),
-		BasePrefix:      getEnvWithDefault("photos/", "NUXT_PROVIDER_S3_BASE_PREFIX", "R2_BASE_PREFIX"),
-		OriginalPrefix:  getEnvWithDefault("originals/", "NUXT_PROVIDER_S3_ORIGINAL_PREFIX", "R2_ORIGINAL_PREFIX"),
-		ThumbnailPrefix: getEnvWithDefault(
-			"thumbnails/", "NUXT_PROVIDER_S3_PREFIX_THUMBNAIL_BASE", "R2_THUMBNAIL_PREFIX",
-		),
+		return nil, err
+	}
+	if path != "" {
+		fmt.Printf("✓ Loaded .env from: %s\n", path)
+	}
+
+	cfg := DefaultConfig()
+	if err := cfg.applyEnv(vars, path, "r2."); err != nil {
+		return nil, err
 	}
 
 	// Validate required fields
-	if config.Endpoint == "" || config.Bucket == "" || config.AccessKeyID == "" || config.SecretAccessKey == "" {
+	if !cfg.R2Configured() {
 		return nil, fmt.Errorf("missing required R2 configuration")
 	}
 
-	return config, nil
+	return &cfg.R2, nil
 }
 
 // getEnv tries multiple environment variable names and returns the first non-empty value
@@ -117,10 +151,12 @@ func NewR2Client(config *R2Config) (*R2Client, error) {
 		},
 	)
 
-	// Create AWS config
+	// Create AWS config. The SDK does not retry on its own: RetryingStorage is the only retry policy,
+	// so every attempt is backed off, counted in the summary and charged to the error budget.
 	cfg := aws.Config{
 		Region:                      config.Region,
 		EndpointResolverWithOptions: customResolver,
+		Retryer:                     func() aws.Retryer { return aws.NopRetryer{} },
 		Credentials: credentials.NewStaticCredentialsProvider(
 			config.AccessKeyID,
 			config.SecretAccessKey,
@@ -139,51 +175,14 @@ func NewR2Client(config *R2Config) (*R2Client, error) {
 
 // CheckFileExists checks if a file exists in R2
 func (r *R2Client) CheckFileExists(key string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
-	defer cancel()
-
-	_, err := r.client.HeadObject(
-		ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(r.config.Bucket),
-			Key:    aws.String(key),
-		},
-	)
+	_, err := r.Head(context.Background(), key)
 	return err == nil
 }
 
-// UploadFile uploads a file to R2
+// UploadFile streams a file to R2, in parts when it is larger than the multipart threshold
 func (r *R2Client) UploadFile(localPath, key, cacheControl string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
-	defer cancel()
-
-	// Read file
-	fileData, err := os.ReadFile(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
-	}
-
-	// Determine content type
-	contentType := getContentType(localPath)
-
-	// Upload to R2
-	input := &s3.PutObjectInput{
-		Bucket:      aws.String(r.config.Bucket),
-		Key:         aws.String(key),
-		Body:        bytes.NewReader(fileData),
-		ContentType: aws.String(contentType),
-	}
-
-	if cacheControl != "" {
-		input.CacheControl = aws.String(cacheControl)
-	}
-
-	_, err = r.client.PutObject(ctx, input)
-
-	if err != nil {
-		return fmt.Errorf("failed to upload to R2: %w", err)
-	}
-
-	return nil
+	_, err := putFile(context.Background(), r, key, localPath, PutOptions{CacheControl: cacheControl})
+	return err
 }
 
 // UploadBytes uploads byte data to R2
@@ -213,7 +212,17 @@ func (r *R2Client) UploadBytes(data []byte, key, contentType, cacheControl strin
 
 // DeleteObject del data to R2
 func (r *R2Client) DeleteObject(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
+	return r.Delete(context.Background(), key)
+}
+
+// DeleteObjects deletes multiple objects from R2 in a batch
+func (r *R2Client) DeleteObjects(keys []string) error {
+	return r.DeleteMany(context.Background(), keys)
+}
+
+// Delete removes an object from R2
+func (r *R2Client) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
 	defer cancel()
 
 	_, err := r.client.DeleteObject(
@@ -239,13 +248,13 @@ func (r *R2Client) DeleteObject(key string) error {
 	return nil
 }
 
-// DeleteObjects deletes multiple objects from R2 in a batch
-func (r *R2Client) DeleteObjects(keys []string) error {
+// DeleteMany removes several objects from R2 in batches
+func (r *R2Client) DeleteMany(ctx context.Context, keys []string) error {
 	if len(keys) == 0 {
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
 	defer cancel()
 
 	var objects []types.ObjectIdentifier
@@ -289,6 +298,154 @@ func (r *R2Client) GetCDNUrl(key string) string {
 	return fmt.Sprintf("%s/%s/%s", r.config.Endpoint, r.config.Bucket, key)
 }
 
+// Put uploads body to R2. Bodies should be seekable (bytes.Reader, os.File) so the request can be signed.
+// Seekable bodies at least as large as the multipart threshold are streamed in parts.
+func (r *R2Client) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	if readerAt, size, ok := sizedReaderAt(body); ok && size >= r.multipartThreshold() {
+		return r.putMultipart(ctx, key, readerAt, size, opts)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(r.config.Bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	input.IfMatch, input.IfNoneMatch = conditionHeaders(opts)
+
+	out, err := r.client.PutObject(ctx, input)
+	if isPreconditionFailed(err) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s changed in R2: %w", ErrPreconditionFailed, key, err)
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to upload to R2: %w", err)
+	}
+
+	info := ObjectInfo{Key: key, ETag: strings.Trim(aws.ToString(out.ETag), `"`), LastModified: time.Now()}
+	if seeker, ok := body.(io.Seeker); ok {
+		if size, err := seeker.Seek(0, io.SeekEnd); err == nil {
+			info.Size = size
+		}
+	}
+	return info, nil
+}
+
+// conditionHeaders returns the If-Match and If-None-Match values of a conditional write, nil when unset
+func conditionHeaders(opts PutOptions) (*string, *string) {
+	var ifMatch, ifNoneMatch *string
+	if opts.IfMatch != "" {
+		ifMatch = aws.String(`"` + opts.IfMatch + `"`)
+	}
+	if opts.IfNoneMatch != "" {
+		ifNoneMatch = aws.String(opts.IfNoneMatch)
+	}
+	return ifMatch, ifNoneMatch
+}
+
+// Head returns the metadata of an object, or ErrNotFound
+func (r *R2Client) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	out, err := r.client.HeadObject(
+		ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(r.config.Bucket),
+			Key:    aws.String(key),
+		},
+	)
+	if err != nil {
+		var notFound *types.NotFound
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to head object in R2: %w", err)
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// Copy copies an object within the bucket on the server side. Objects larger than 5 GB cannot be copied this way.
+func (r *R2Client) Copy(ctx context.Context, srcKey, dstKey string) (ObjectInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	segments := strings.Split(srcKey, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	out, err := r.client.CopyObject(
+		ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(r.config.Bucket),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(r.config.Bucket + "/" + strings.Join(segments, "/")),
+		},
+	)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to copy %s to %s in R2: %w", srcKey, dstKey, err)
+	}
+
+	info := ObjectInfo{Key: dstKey, LastModified: time.Now()}
+	if out.CopyObjectResult != nil {
+		info.ETag = strings.Trim(aws.ToString(out.CopyObjectResult.ETag), `"`)
+		info.LastModified = aws.ToTime(out.CopyObjectResult.LastModified)
+	}
+	return info, nil
+}
+
+// List returns all objects whose key starts with prefix
+func (r *R2Client) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(
+		r.client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(r.config.Bucket),
+			Prefix: aws.String(prefix),
+		},
+	)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in R2: %w", err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// URL returns the CDN URL of an object
+func (r *R2Client) URL(key string) string {
+	return r.GetCDNUrl(key)
+}
+
 // getContentType determines the content type based on file extension
 func getContentType(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
@@ -2,11 +2,24 @@ package scripts
 
 import (
 	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -310,6 +323,58 @@ func TestNewR2Client(t *testing.T) {
 	assert.Equal(t, config.Bucket, client.config.Bucket)
 }
 
+// TestR2ClientSingleRetryPolicy tests that the SDK does not retry below RetryingStorage
+func TestR2ClientSingleRetryPolicy(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := NewR2Client(&R2Config{
+		Endpoint: server.URL, Bucket: "b", Region: "auto", AccessKeyID: "key", SecretAccessKey: "secret",
+		MultipartThresholdMB: DefaultMultipartThresholdMB,
+	})
+	assert.NoError(t, err)
+	retrying := NewRetryingStorage(client, RetryConfig{MaxAttempts: 3, BaseDelayMS: 1, MaxDelayMS: 1, ErrorBudget: 5})
+	retrying.sleep = func(context.Context, time.Duration) error { return nil }
+
+	_, err = putBytes(t.Context(), retrying, "a.jpg", []byte("data"), PutOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), requests.Load(), "one request per attempt")
+	assert.Equal(t, map[ErrorClass]int{ErrorThrottled: 2}, retrying.Summary().Retries, "503 is S3 ServiceUnavailable")
+}
+
+// TestR2ClientConditionalPut tests that the conditions of a Put are sent and a refusal is ErrPreconditionFailed
+func TestR2ClientConditionalPut(t *testing.T) {
+	var requests atomic.Int32
+	var ifMatch, ifNoneMatch string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		ifMatch, ifNoneMatch = r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		_, _ = w.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`))
+	}))
+	defer server.Close()
+
+	client, err := NewR2Client(&R2Config{
+		Endpoint: server.URL, Bucket: "b", Region: "auto", AccessKeyID: "key", SecretAccessKey: "secret",
+		MultipartThresholdMB: DefaultMultipartThresholdMB,
+	})
+	assert.NoError(t, err)
+	retrying := NewRetryingStorage(client, RetryConfig{MaxAttempts: 3, BaseDelayMS: 1, MaxDelayMS: 1, ErrorBudget: 5})
+
+	_, err = putBytes(t.Context(), retrying, "photos.json", []byte("[]"), PutOptions{IfMatch: "abc"})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.Equal(t, `"abc"`, ifMatch)
+	_, err = putBytes(t.Context(), retrying, "photos.json", []byte("[]"), PutOptions{IfNoneMatch: "*"})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.Equal(t, "*", ifNoneMatch)
+	assert.Equal(t, int32(2), requests.Load(), "a refused write is not retried")
+	assert.Empty(t, retrying.Summary().Failures, "a refused write is an answer, not a storage failure")
+}
+
 // TestGetCDNUrl tests the GetCDNUrl method
 func TestGetCDNUrl(t *testing.T) {
 	t.Run("With CDN URL", func(t *testing.T) {
@@ -541,3 +606,225 @@ func TestR2ConfigValidation(t *testing.T) {
 		})
 	}
 }
+
+// fakeS3 is an in-memory S3 with multipart uploads. Methods it does not implement panic.
+type fakeS3 struct {
+	s3API
+	mu        sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]*fakeUpload
+	nextID    int
+	failPart  int32 // UploadPart fails once for this part number
+	pageSize  int   // Uploads per ListMultipartUploads page, 0 lists all at once
+	badETag   bool  // CompleteMultipartUpload returns a wrong ETag
+	partCalls []int32
+	putCalls  int
+}
+
+// fakeUpload is an unfinished multipart upload
+type fakeUpload struct {
+	key       string
+	initiated time.Time
+	parts     map[int32][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]*fakeUpload)}
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.putCalls++
+	f.objects[aws.ToString(params.Key)] = data
+	return &s3.PutObjectOutput{ETag: aws.String(fmt.Sprintf(`"%x"`, md5.Sum(data)))}, nil
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data)))}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := fmt.Sprintf("upload-%d", f.nextID)
+	f.uploads[id] = &fakeUpload{
+		key: aws.ToString(params.Key), initiated: time.Unix(int64(f.nextID), 0), parts: make(map[int32][]byte),
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeS3) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	number := aws.ToInt32(params.PartNumber)
+	f.mu.Lock()
+	f.partCalls = append(f.partCalls, number)
+	if number == f.failPart {
+		f.failPart = 0
+		f.mu.Unlock()
+		return nil, errors.New("connection reset")
+	}
+	f.mu.Unlock()
+
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads[aws.ToString(params.UploadId)].parts[number] = data
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"%x"`, md5.Sum(data)))}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload := f.uploads[aws.ToString(params.UploadId)]
+	var data []byte
+	hash := md5.New()
+	for _, part := range params.MultipartUpload.Parts {
+		body := upload.parts[aws.ToInt32(part.PartNumber)]
+		sum := md5.Sum(body)
+		hash.Write(sum[:])
+		data = append(data, body...)
+	}
+	delete(f.uploads, aws.ToString(params.UploadId))
+	f.objects[upload.key] = data
+	etag := fmt.Sprintf(`"%x-%d"`, hash.Sum(nil), len(params.MultipartUpload.Parts))
+	if f.badETag {
+		etag = fmt.Sprintf(`"%032x-%d"`, 0, len(params.MultipartUpload.Parts))
+	}
+	return &s3.CompleteMultipartUploadOutput{ETag: aws.String(etag)}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.uploads, aws.ToString(params.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Ordered by key and upload ID, continuing after the markers
+	var uploads []types.MultipartUpload
+	keyMarker, idMarker := aws.ToString(params.KeyMarker), aws.ToString(params.UploadIdMarker)
+	for id, upload := range f.uploads {
+		after := keyMarker == "" || upload.key > keyMarker || (upload.key == keyMarker && id > idMarker)
+		if strings.HasPrefix(upload.key, aws.ToString(params.Prefix)) && after {
+			uploads = append(
+				uploads,
+				types.MultipartUpload{
+					Key: aws.String(upload.key), UploadId: aws.String(id), Initiated: aws.Time(upload.initiated),
+				},
+			)
+		}
+	}
+	sort.Slice(
+		uploads, func(i, j int) bool {
+			if *uploads[i].Key != *uploads[j].Key {
+				return *uploads[i].Key < *uploads[j].Key
+			}
+			return *uploads[i].UploadId < *uploads[j].UploadId
+		},
+	)
+
+	out := &s3.ListMultipartUploadsOutput{IsTruncated: aws.Bool(false)}
+	if f.pageSize > 0 && len(uploads) > f.pageSize {
+		uploads = uploads[:f.pageSize]
+		last := uploads[len(uploads)-1]
+		out.IsTruncated, out.NextKeyMarker, out.NextUploadIdMarker = aws.Bool(true), last.Key, last.UploadId
+	}
+	out.Uploads = uploads
+	return out, nil
+}
+
+func (f *fakeS3) ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &s3.ListPartsOutput{IsTruncated: aws.Bool(false)}
+	for number, data := range f.uploads[aws.ToString(params.UploadId)].parts {
+		out.Parts = append(
+			out.Parts, types.Part{
+				PartNumber: aws.Int32(number),
+				Size:       aws.Int64(int64(len(data))),
+				ETag:       aws.String(fmt.Sprintf(`"%x"`, md5.Sum(data))),
+			},
+		)
+	}
+	return out, nil
+}
+
+// TestR2MultipartUpload tests streaming uploads in parts, resuming after a failed part and the final checksum
+func TestR2MultipartUpload(t *testing.T) {
+	data := make([]byte, 11<<20) // Three 5 MB parts, the last one short
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), "large.jpg")
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	fake := newFakeS3()
+	client := &R2Client{
+		client: fake,
+		config: R2Config{Bucket: "b", MultipartThresholdMB: 1, PartSizeMB: 5, PartConcurrency: 1},
+	}
+
+	// Small bodies use a single request
+	_, err := putBytes(t.Context(), client, "small.webp", []byte("thumb"), PutOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.putCalls)
+
+	fake.failPart = 3
+	_, err = putFile(t.Context(), client, "photos/originals/large.jpg", path, PutOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, []int32{1, 2, 3}, fake.partCalls)
+	assert.Len(t, fake.uploads, 1, "an interrupted upload is kept for resuming")
+
+	fake.partCalls = nil
+	info, err := putFile(t.Context(), client, "photos/originals/large.jpg", path, PutOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{3}, fake.partCalls, "stored parts are not uploaded again")
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Regexp(t, `^[0-9a-f]{32}-3$`, info.ETag)
+	assert.Equal(t, data, fake.objects["photos/originals/large.jpg"])
+	assert.Empty(t, fake.uploads)
+
+	fake.badETag = true
+	_, err = putFile(t.Context(), client, "photos/originals/other.jpg", path, PutOptions{})
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+// TestR2FindUploadPages tests that the upload to resume is found on a later page of unfinished uploads
+func TestR2FindUploadPages(t *testing.T) {
+	fake := newFakeS3()
+	fake.pageSize = 2
+	client := &R2Client{client: fake, config: R2Config{Bucket: "b", PartSizeMB: 5}}
+
+	var newest string
+	for _, key := range []string{"photos/large.jpg", "photos/large.jpg", "photos/large.jpg", "photos/large.jpg.old"} {
+		out, err := fake.CreateMultipartUpload(
+			t.Context(), &s3.CreateMultipartUploadInput{Bucket: aws.String("b"), Key: aws.String(key)},
+		)
+		assert.NoError(t, err)
+		if key == "photos/large.jpg" {
+			newest = aws.ToString(out.UploadId)
+		}
+	}
+
+	uploadID, parts, err := client.findUpload(t.Context(), "photos/large.jpg", 5<<20, 11<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, newest, uploadID, "the newest upload is on the second page")
+	assert.Empty(t, parts)
+}
@@ -0,0 +1,362 @@
+package scripts
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Multipart upload defaults, used when the configuration leaves them at zero
+const (
+	DefaultMultipartThresholdMB = 64
+	DefaultPartSizeMB           = 16
+	DefaultPartConcurrency      = 4
+
+	minPartSize  = 5 << 20 // S3 rejects smaller parts except the last one
+	maxPartCount = 10000
+)
+
+// multipartETag matches the ETag S3 computes for multipart objects: the MD5 of the part MD5s and the part count
+var multipartETag = regexp.MustCompile(`^[0-9a-f]{32}-\d+$`)
+
+// s3API is the part of the S3 client used by R2Client
+type s3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(
+		ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options),
+	) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(
+		ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options),
+	) (*s3.DeleteObjectsOutput, error)
+	ListObjectsV2(
+		ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options),
+	) (*s3.ListObjectsV2Output, error)
+	CreateMultipartUpload(
+		ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options),
+	) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(
+		ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options),
+	) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(
+		ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options),
+	) (*s3.AbortMultipartUploadOutput, error)
+	ListMultipartUploads(
+		ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options),
+	) (*s3.ListMultipartUploadsOutput, error)
+	ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error)
+}
+
+// sizedReaderAt returns body as an io.ReaderAt with its size when it supports random access, like *os.File
+func sizedReaderAt(body io.Reader) (io.ReaderAt, int64, bool) {
+	readerAt, ok := body.(io.ReaderAt)
+	seeker, ok2 := body.(io.Seeker)
+	if !ok || !ok2 {
+		return nil, 0, false
+	}
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, false
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, false
+	}
+	return readerAt, size, true
+}
+
+// multipartThreshold returns the size from which Put uploads in parts
+func (r *R2Client) multipartThreshold() int64 {
+	if r.config.MultipartThresholdMB > 0 {
+		return int64(r.config.MultipartThresholdMB) << 20
+	}
+	return DefaultMultipartThresholdMB << 20
+}
+
+// partSize returns the part size for an object of the given size, grown when the object would need too many parts
+func (r *R2Client) partSize(size int64) int64 {
+	partSize := int64(DefaultPartSizeMB) << 20
+	if r.config.PartSizeMB > 0 {
+		partSize = int64(r.config.PartSizeMB) << 20
+	}
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	if min := (size + maxPartCount - 1) / maxPartCount; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+// partConcurrency returns how many parts of one object are uploaded at the same time
+func (r *R2Client) partConcurrency() int {
+	if r.config.PartConcurrency > 0 {
+		return r.config.PartConcurrency
+	}
+	return DefaultPartConcurrency
+}
+
+// putMultipart streams body to key in parts. Parts are read straight from body, so memory use is bounded
+// by the parts in flight. An unfinished upload of the same key is resumed: stored parts whose size and
+// MD5 match are kept. An interrupted upload is left in place for the next attempt. The completed object
+// is checked against the ETag expected from the part checksums, or against its size when the service
+// uses another ETag scheme.
+func (r *R2Client) putMultipart(
+	ctx context.Context, key string, body io.ReaderAt, size int64, opts PutOptions,
+) (ObjectInfo, error) {
+	partSize := r.partSize(size)
+	count := int((size + partSize - 1) / partSize)
+
+	uploadID, stored, err := r.findUpload(ctx, key, partSize, size)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if uploadID == "" {
+		createCtx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+		input := &s3.CreateMultipartUploadInput{Bucket: aws.String(r.config.Bucket), Key: aws.String(key)}
+		if opts.ContentType != "" {
+			input.ContentType = aws.String(opts.ContentType)
+		}
+		if opts.CacheControl != "" {
+			input.CacheControl = aws.String(opts.CacheControl)
+		}
+		out, err := r.client.CreateMultipartUpload(createCtx, input)
+		cancel()
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to start multipart upload to R2: %w", err)
+		}
+		uploadID = aws.ToString(out.UploadId)
+	} else {
+		fmt.Printf("🟢 Resuming upload of %s (%d of %d parts stored)\n", key, len(stored), count)
+	}
+
+	completed := make([]types.CompletedPart, count)
+	sums := make([][]byte, count)
+	jobs := make(chan int, count)
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	workers := r.partConcurrency()
+	if workers > count {
+		workers = count
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errMu.Lock()
+				failed := firstErr != nil
+				errMu.Unlock()
+				if failed || ctx.Err() != nil {
+					return
+				}
+
+				number := int32(i + 1)
+				offset := int64(i) * partSize
+				length := partSize
+				if offset+length > size {
+					length = size - offset
+				}
+
+				sum, etag, err := r.uploadPart(ctx, key, uploadID, number, io.NewSectionReader(body, offset, length), stored)
+				if err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+					return
+				}
+				sums[i] = sum
+				completed[i] = types.CompletedPart{PartNumber: aws.Int32(number), ETag: aws.String(etag)}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		fmt.Printf("⚠ Upload of %s interrupted, the stored parts are reused by the next attempt\n", key)
+		return ObjectInfo{}, firstErr
+	}
+
+	completeCtx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(r.config.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	input.IfMatch, input.IfNoneMatch = conditionHeaders(opts)
+	out, err := r.client.CompleteMultipartUpload(completeCtx, input)
+	if isPreconditionFailed(err) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s changed in R2: %w", ErrPreconditionFailed, key, err)
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to complete multipart upload to R2: %w", err)
+	}
+
+	etag := strings.Trim(aws.ToString(out.ETag), `"`)
+	if err := r.verifyMultipart(ctx, key, etag, sums, size); err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: size, ETag: etag, LastModified: time.Now()}, nil
+}
+
+// uploadPart uploads one part unless an identical part is already stored, and returns its MD5 and ETag
+func (r *R2Client) uploadPart(
+	ctx context.Context, key, uploadID string, number int32, part *io.SectionReader, stored map[int32]types.Part,
+) ([]byte, string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return nil, "", fmt.Errorf("failed to read part %d of %s: %w", number, key, err)
+	}
+	sum := hash.Sum(nil)
+
+	if existing, ok := stored[number]; ok && aws.ToInt64(existing.Size) == part.Size() {
+		etag := strings.Trim(aws.ToString(existing.ETag), `"`)
+		// Services that do not use MD5 part ETags are trusted on size, as the key names the content
+		if etag == hex.EncodeToString(sum) || !md5ETag(etag) {
+			return sum, etag, nil
+		}
+	}
+
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+	out, err := r.client.UploadPart(
+		ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(r.config.Bucket),
+			Key:           aws.String(key),
+			UploadId:      aws.String(uploadID),
+			PartNumber:    aws.Int32(number),
+			Body:          part,
+			ContentLength: aws.Int64(part.Size()),
+		},
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to upload part %d of %s to R2: %w", number, key, err)
+	}
+	return sum, strings.Trim(aws.ToString(out.ETag), `"`), nil
+}
+
+// md5ETag reports whether an ETag is a plain MD5 hex digest
+func md5ETag(etag string) bool {
+	if len(etag) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(etag)
+	return err == nil
+}
+
+// findUpload returns the newest unfinished multipart upload of key with its stored parts.
+// Uploads made with another part size cannot be resumed and are aborted.
+func (r *R2Client) findUpload(
+	ctx context.Context, key string, partSize, size int64,
+) (string, map[int32]types.Part, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	// Abandoned uploads of the key, or of keys it is a prefix of, can fill several pages
+	var uploads []types.MultipartUpload
+	list := &s3.ListMultipartUploadsInput{Bucket: aws.String(r.config.Bucket), Prefix: aws.String(key)}
+	for {
+		page, err := r.client.ListMultipartUploads(ctx, list)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list multipart uploads in R2: %w", err)
+		}
+		for _, upload := range page.Uploads {
+			if aws.ToString(upload.Key) == key {
+				uploads = append(uploads, upload)
+			}
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		list.KeyMarker, list.UploadIdMarker = page.NextKeyMarker, page.NextUploadIdMarker
+	}
+	if len(uploads) == 0 {
+		return "", nil, nil
+	}
+	sort.Slice(
+		uploads, func(i, j int) bool {
+			return aws.ToTime(uploads[i].Initiated).After(aws.ToTime(uploads[j].Initiated))
+		},
+	)
+	uploadID := aws.ToString(uploads[0].UploadId)
+
+	stored := make(map[int32]types.Part)
+	input := &s3.ListPartsInput{Bucket: aws.String(r.config.Bucket), Key: aws.String(key), UploadId: aws.String(uploadID)}
+	for {
+		page, err := r.client.ListParts(ctx, input)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list parts in R2: %w", err)
+		}
+		for _, part := range page.Parts {
+			stored[aws.ToInt32(part.PartNumber)] = part
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.PartNumberMarker = page.NextPartNumberMarker
+	}
+
+	count := int32((size + partSize - 1) / partSize)
+	for number, part := range stored {
+		if number < count && aws.ToInt64(part.Size) != partSize {
+			_, _ = r.client.AbortMultipartUpload(
+				ctx, &s3.AbortMultipartUploadInput{
+					Bucket: aws.String(r.config.Bucket), Key: aws.String(key), UploadId: aws.String(uploadID),
+				},
+			)
+			return "", nil, nil
+		}
+	}
+	return uploadID, stored, nil
+}
+
+// verifyMultipart checks a completed upload against the MD5s of its parts, or its stored size
+func (r *R2Client) verifyMultipart(ctx context.Context, key, etag string, sums [][]byte, size int64) error {
+	if multipartETag.MatchString(etag) {
+		hash := md5.New()
+		for _, sum := range sums {
+			hash.Write(sum)
+		}
+		expected := fmt.Sprintf("%x-%d", hash.Sum(nil), len(sums))
+		if etag != expected {
+			return fmt.Errorf("checksum mismatch after uploading %s: ETag %s, expected %s", key, etag, expected)
+		}
+		return nil
+	}
+
+	info, err := r.Head(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", key, err)
+	}
+	if info.Size != size {
+		return fmt.Errorf("size mismatch after uploading %s: stored %d bytes, expected %d", key, info.Size, size)
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package scripts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// ErrorClass groups storage errors by how they should be retried
+type ErrorClass string
+
+const (
+	ErrorThrottled ErrorClass = "throttled" // 429 or SlowDown: retried with longer delays
+	ErrorServer    ErrorClass = "server"    // 5xx: retried
+	ErrorNetwork   ErrorClass = "network"   // Timeouts, resets and other transport failures: retried
+	ErrorAuth      ErrorClass = "auth"      // 401, 403 and credential errors: not retried, spend the whole budget
+	ErrorClient    ErrorClass = "client"    // Other 4xx: not retried
+	ErrorOther     ErrorClass = "other"     // Anything else, e.g. local I/O: not retried
+)
+
+// throttleFactor scales the backoff of throttled requests
+const throttleFactor = 4
+
+// ErrBudgetExhausted is returned for every storage call once a run has failed too often
+var ErrBudgetExhausted = errors.New("storage error budget exhausted")
+
+// authCodes are S3 error codes caused by credentials or permissions
+var authCodes = map[string]bool{
+	"AccessDenied":          true,
+	"InvalidAccessKeyId":    true,
+	"SignatureDoesNotMatch": true,
+	"ExpiredToken":          true,
+	"InvalidToken":          true,
+	"Unauthorized":          true,
+}
+
+// throttleCodes are S3 error codes asking the client to slow down
+var throttleCodes = map[string]bool{
+	"SlowDown":                 true,
+	"TooManyRequests":          true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"ServiceUnavailable":       true,
+	"TooManyRequestsException": true,
+}
+
+// ClassifyError returns the class of a storage error
+func ClassifyError(err error) ErrorClass {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		switch {
+		case authCodes[code]:
+			return ErrorAuth
+		case throttleCodes[code]:
+			return ErrorThrottled
+		}
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch status := respErr.HTTPStatusCode(); {
+		case status == 429:
+			return ErrorThrottled
+		case status == 401 || status == 403:
+			return ErrorAuth
+		case status >= 500:
+			return ErrorServer
+		case status >= 400:
+			return ErrorClient
+		}
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return ErrorNetwork
+	}
+	return ErrorOther
+}
+
+// isPreconditionFailed reports whether a write was refused because of its If-Match or If-None-Match
+// condition; concurrent conditional writes of the same key may also be refused with a conflict
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == 412
+}
+
+// Retryable reports whether errors of the class may succeed when the call is repeated
+func (c ErrorClass) Retryable() bool {
+	return c == ErrorThrottled || c == ErrorServer || c == ErrorNetwork
+}
+
+// RetryConfig controls how storage calls are retried
+type RetryConfig struct {
+	MaxAttempts int `yaml:"max_attempts"`  // Attempts per call, including the first one
+	BaseDelayMS int `yaml:"base_delay_ms"` // Backoff before the second attempt, doubled for each further one
+	MaxDelayMS  int `yaml:"max_delay_ms"`  // Upper bound of a single backoff
+	ErrorBudget int `yaml:"error_budget"`  // Calls that may fail permanently before the run stops calling storage
+}
+
+// DefaultRetryConfig returns the default retry policy
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{MaxAttempts: 5, BaseDelayMS: 200, MaxDelayMS: 20000, ErrorBudget: 20}
+}
+
+// StorageFailure is a storage call that failed after all its attempts
+type StorageFailure struct {
+	Op       string     `json:"op"`
+	Key      string     `json:"key,omitempty"`
+	Class    ErrorClass `json:"class"`
+	Attempts int        `json:"attempts"`
+	Error    string     `json:"error"`
+}
+
+// RetrySummary describes the retries and failures of a run
+type RetrySummary struct {
+	Retries   map[ErrorClass]int `json:"retries"`   // Repeated attempts by the class of the error that caused them
+	Recovered int                `json:"recovered"` // Calls that succeeded after at least one retry
+	Failures  []StorageFailure   `json:"failures"`
+	Exhausted bool               `json:"exhausted"` // The error budget ran out
+}
+
+// RetryingStorage retries the calls of another Storage with jittered exponential backoff.
+// Bodies are rewound between attempts, so Put is only retried for seekable bodies.
+// It is safe for concurrent use.
+type RetryingStorage struct {
+	Storage
+	config RetryConfig
+	sleep  func(context.Context, time.Duration) error
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	retries   map[ErrorClass]int
+	recovered int
+	failures  []StorageFailure
+	budget    int
+}
+
+// NewRetryingStorage wraps s with the given retry policy
+func NewRetryingStorage(s Storage, config RetryConfig) *RetryingStorage {
+	return &RetryingStorage{
+		Storage: s,
+		config:  config,
+		sleep:   sleepContext,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		retries: make(map[ErrorClass]int),
+		budget:  config.ErrorBudget,
+	}
+}
+
+// Unwrap returns the wrapped storage
+func (r *RetryingStorage) Unwrap() Storage {
+	return r.Storage
+}
+
+// sleepContext waits for d or until ctx is canceled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the full jitter delay before the given retry, counting from 1
+func (r *RetryingStorage) backoff(retry int, class ErrorClass) time.Duration {
+	base := time.Duration(r.config.BaseDelayMS) * time.Millisecond
+	if class == ErrorThrottled {
+		base *= throttleFactor
+	}
+	maxDelay := time.Duration(r.config.MaxDelayMS) * time.Millisecond
+	delay := base << (retry - 1)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.rng.Int63n(int64(delay) + 1))
+}
+
+// do runs fn until it succeeds, fails with an error that is not retryable or runs out of attempts.
+// Calls abandoned because ctx was canceled are neither retried nor counted against the budget.
+func (r *RetryingStorage) do(ctx context.Context, op, key string, rewind func() error, fn func() error) error {
+	r.mu.Lock()
+	exhausted := r.budget < 0
+	r.mu.Unlock()
+	if exhausted {
+		return fmt.Errorf("%s %s: %w", op, key, ErrBudgetExhausted)
+	}
+
+	attempts := r.config.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	var class ErrorClass
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrPreconditionFailed) {
+			if attempt > 1 {
+				r.mu.Lock()
+				r.recovered++
+				r.mu.Unlock()
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		class = ClassifyError(err)
+		if !class.Retryable() || attempt >= attempts {
+			r.fail(StorageFailure{Op: op, Key: key, Class: class, Attempts: attempt, Error: err.Error()})
+			return err
+		}
+		if rewind != nil {
+			if rerr := rewind(); rerr != nil {
+				r.fail(StorageFailure{Op: op, Key: key, Class: class, Attempts: attempt, Error: err.Error()})
+				return err
+			}
+		}
+
+		delay := r.backoff(attempt, class)
+		fmt.Printf("⚠ %s %s failed (%s), retrying in %v: %v\n", op, key, class, delay.Round(time.Millisecond), err)
+		r.mu.Lock()
+		r.retries[class]++
+		r.mu.Unlock()
+		if serr := r.sleep(ctx, delay); serr != nil {
+			return err
+		}
+	}
+}
+
+// fail records a permanent failure and spends the error budget; auth errors spend all of it
+func (r *RetryingStorage) fail(failure StorageFailure) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, failure)
+	if failure.Class == ErrorAuth {
+		r.budget = -1
+	} else {
+		r.budget--
+	}
+	if r.budget == -1 {
+		fmt.Printf("❌ Storage error budget exhausted after %s %s, skipping further storage calls\n", failure.Op, failure.Key)
+	}
+}
+
+// Put stores body, rewinding it before each retry when it is seekable
+func (r *RetryingStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	// A consumed stream cannot be sent again
+	rewind := func() error { return errors.New("body is not seekable") }
+	if seeker, ok := body.(io.Seeker); ok {
+		rewind = func() error {
+			_, err := seeker.Seek(0, io.SeekStart)
+			return err
+		}
+	}
+
+	var info ObjectInfo
+	err := r.do(
+		ctx, "put", key, rewind, func() error {
+			var err error
+			info, err = r.Storage.Put(ctx, key, body, opts)
+			return err
+		},
+	)
+	return info, err
+}
+
+// Head returns the metadata of an object; ErrNotFound is returned without retrying
+func (r *RetryingStorage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := r.do(
+		ctx, "head", key, nil, func() error {
+			var err error
+			info, err = r.Storage.Head(ctx, key)
+			return err
+		},
+	)
+	return info, err
+}
+
+// Delete removes an object
+func (r *RetryingStorage) Delete(ctx context.Context, key string) error {
+	return r.do(ctx, "delete", key, nil, func() error { return r.Storage.Delete(ctx, key) })
+}
+
+// DeleteMany removes several objects
+func (r *RetryingStorage) DeleteMany(ctx context.Context, keys []string) error {
+	label := fmt.Sprintf("(%d keys)", len(keys))
+	return r.do(ctx, "delete", label, nil, func() error { return r.Storage.DeleteMany(ctx, keys) })
+}
+
+// List returns the objects below prefix
+func (r *RetryingStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := r.do(
+		ctx, "list", prefix, nil, func() error {
+			var err error
+			objects, err = r.Storage.List(ctx, prefix)
+			return err
+		},
+	)
+	return objects, err
+}
+
+// Copy copies an object; ErrNotFound is returned without retrying
+func (r *RetryingStorage) Copy(ctx context.Context, srcKey, dstKey string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := r.do(
+		ctx, "copy", srcKey, nil, func() error {
+			var err error
+			info, err = r.Storage.Copy(ctx, srcKey, dstKey)
+			return err
+		},
+	)
+	return info, err
+}
+
+// Summary returns the retries and failures so far
+func (r *RetryingStorage) Summary() RetrySummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := RetrySummary{
+		Retries:   make(map[ErrorClass]int, len(r.retries)),
+		Recovered: r.recovered,
+		Failures:  append([]StorageFailure(nil), r.failures...),
+		Exhausted: r.budget < 0,
+	}
+	for class, n := range r.retries {
+		summary.Retries[class] = n
+	}
+	return summary
+}
+
+// PrintRetrySummary writes the retries and permanent failures of a run; nothing when all calls succeeded first time
+func PrintRetrySummary(summary RetrySummary) {
+	if len(summary.Retries) == 0 && len(summary.Failures) == 0 {
+		return
+	}
+
+	var classes []string
+	total := 0
+	for class, n := range summary.Retries {
+		classes = append(classes, fmt.Sprintf("%s %d", class, n))
+		total += n
+	}
+	sort.Strings(classes)
+	if total > 0 {
+		fmt.Printf(
+			"⚠ Storage retries: %d (%s), %d calls recovered\n", total, strings.Join(classes, ", "), summary.Recovered,
+		)
+	}
+	for _, failure := range summary.Failures {
+		fmt.Printf(
+			"❌ %s %s failed after %d attempts (%s): %s\n",
+			failure.Op, failure.Key, failure.Attempts, failure.Class, failure.Error,
+		)
+	}
+	if summary.Exhausted {
+		fmt.Println("❌ The storage error budget was exhausted; later storage calls were skipped")
+	}
+}
@@ -0,0 +1,158 @@
+package scripts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// httpError builds an SDK error for an HTTP response with the given status
+func httpError(status int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      fmt.Errorf("status %d", status),
+		},
+	}
+}
+
+// TestClassifyError tests the classification of storage errors
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class ErrorClass
+	}{
+		{"Too many requests", httpError(429), ErrorThrottled},
+		{"Slow down code", &smithy.GenericAPIError{Code: "SlowDown"}, ErrorThrottled},
+		{"Internal error", httpError(500), ErrorServer},
+		{"Bad gateway", fmt.Errorf("failed to upload to R2: %w", httpError(502)), ErrorServer},
+		{"Forbidden", httpError(403), ErrorAuth},
+		{"Bad signature", &smithy.GenericAPIError{Code: "SignatureDoesNotMatch"}, ErrorAuth},
+		{"Bad request", httpError(400), ErrorClient},
+		{"Timeout", context.DeadlineExceeded, ErrorNetwork},
+		{"Connection", &net.OpError{Op: "dial", Err: errors.New("refused")}, ErrorNetwork},
+		{"Truncated body", io.ErrUnexpectedEOF, ErrorNetwork},
+		{"Local file", errors.New("failed to read file"), ErrorOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.class, ClassifyError(tt.err))
+		})
+	}
+}
+
+// flakyStorage fails the first calls with the given errors
+type flakyStorage struct {
+	Storage
+	errs   []error
+	calls  int
+	bodies []string
+}
+
+func (f *flakyStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	data, _ := io.ReadAll(body)
+	f.bodies = append(f.bodies, string(data))
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return ObjectInfo{}, err
+	}
+	return f.Storage.Put(ctx, key, bytes.NewReader(data), opts)
+}
+
+// TestRetryingStorage tests backoff, rewinding, the summary and the error budget
+func TestRetryingStorage(t *testing.T) {
+	flaky := &flakyStorage{Storage: NewMemoryStorage(""), errs: []error{httpError(503), httpError(429)}}
+	retrying := NewRetryingStorage(flaky, RetryConfig{MaxAttempts: 3, BaseDelayMS: 100, MaxDelayMS: 1000, ErrorBudget: 1})
+	var delays []time.Duration
+	retrying.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	_, err := putBytes(t.Context(), retrying, "a.jpg", []byte("data"), PutOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, flaky.calls)
+	assert.Equal(t, []string{"data", "data", "data"}, flaky.bodies, "the body is rewound for every attempt")
+	assert.Len(t, delays, 2)
+	assert.LessOrEqual(t, delays[0], 100*time.Millisecond)
+	assert.LessOrEqual(t, delays[1], 800*time.Millisecond, "throttling waits longer")
+
+	summary := retrying.Summary()
+	assert.Equal(t, map[ErrorClass]int{ErrorServer: 1, ErrorThrottled: 1}, summary.Retries)
+	assert.Equal(t, 1, summary.Recovered)
+	assert.Empty(t, summary.Failures)
+
+	// Client errors are not retried and spend the budget
+	flaky.calls = 0
+	flaky.errs = []error{httpError(400)}
+	_, err = putBytes(t.Context(), retrying, "b.jpg", []byte("data"), PutOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, flaky.calls)
+
+	// Missing objects are not failures
+	_, err = retrying.Head(t.Context(), "missing.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// An auth error exhausts the budget and later calls fail without reaching storage
+	flaky.errs = []error{httpError(403)}
+	_, err = putBytes(t.Context(), retrying, "c.jpg", []byte("data"), PutOptions{})
+	assert.Error(t, err)
+	flaky.calls = 0
+	_, err = putBytes(t.Context(), retrying, "d.jpg", []byte("data"), PutOptions{})
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.Equal(t, 0, flaky.calls)
+
+	summary = retrying.Summary()
+	assert.True(t, summary.Exhausted)
+	assert.Len(t, summary.Failures, 2)
+	assert.Equal(t, ErrorAuth, summary.Failures[1].Class)
+}
+
+// TestRetryingStorageStream tests that bodies that cannot be rewound are not sent twice
+func TestRetryingStorageStream(t *testing.T) {
+	flaky := &flakyStorage{Storage: NewMemoryStorage(""), errs: []error{httpError(503)}}
+	retrying := NewRetryingStorage(flaky, DefaultRetryConfig())
+	retrying.sleep = func(context.Context, time.Duration) error { return nil }
+
+	_, err := retrying.Put(t.Context(), "a.jpg", io.MultiReader(bytes.NewReader([]byte("data"))), PutOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, flaky.calls)
+}
+
+// TestRetryingStorageCanceled tests that canceled calls are neither retried nor charged to the budget
+func TestRetryingStorageCanceled(t *testing.T) {
+	flaky := &flakyStorage{Storage: NewMemoryStorage(""), errs: []error{httpError(503)}}
+	retrying := NewRetryingStorage(flaky, RetryConfig{MaxAttempts: 3, BaseDelayMS: 100, MaxDelayMS: 1000, ErrorBudget: 0})
+
+	// Canceled while waiting for the next attempt
+	ctx, cancel := context.WithCancel(t.Context())
+	retrying.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return sleepContext(ctx, d)
+	}
+	_, err := putBytes(ctx, retrying, "a.jpg", []byte("data"), PutOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, flaky.calls)
+
+	// Canceled before the call
+	_, err = putBytes(ctx, retrying, "b.jpg", []byte("data"), PutOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	summary := retrying.Summary()
+	assert.Empty(t, summary.Failures)
+	assert.False(t, summary.Exhausted)
+}
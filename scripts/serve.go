@@ -0,0 +1,61 @@
+package scripts
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// DefaultServePort is the port used by the local preview server
+const DefaultServePort = "3001"
+
+// Serve serves the files in dir on the given port until the server fails.
+// When distDir is not empty, files in it take precedence over dir, so that
+// the output of a local storage build is served at the same paths as the site.
+func Serve(dir, port, distDir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	var absDist string
+	if distDir != "" {
+		if absDist, err = filepath.Abs(distDir); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", overlayHandler(absDir, absDist))
+
+	fmt.Printf("Starting local server at http://localhost:%s\n", port)
+	fmt.Printf("Serving files from: %s\n", absDir)
+	if absDist != "" {
+		fmt.Printf("Serving local build from: %s\n", absDist)
+	}
+	fmt.Println("Press Ctrl+C to stop")
+
+	return http.ListenAndServe(":"+port, mux)
+}
+
+// overlayHandler serves files from distDir when they exist there and from dir otherwise
+func overlayHandler(dir, distDir string) http.Handler {
+	site := http.FileServer(http.Dir(dir))
+	if distDir == "" {
+		return site
+	}
+	dist := http.FileServer(http.Dir(distDir))
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			name := filepath.Join(distDir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+			if info, err := os.Stat(name); err == nil && !info.IsDir() {
+				dist.ServeHTTP(w, r)
+				return
+			}
+			site.ServeHTTP(w, r)
+		},
+	)
+}
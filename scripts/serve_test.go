@@ -0,0 +1,169 @@
+package scripts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestJPEG writes a small solid color JPEG to path
+func writeTestJPEG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 120, B: 40, A: 255})
+		}
+	}
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.NoError(t, jpeg.Encode(file, img, nil))
+}
+
+// writeOrientedJPEG writes a test JPEG stored as width x height with an EXIF Orientation tag
+func writeOrientedJPEG(t *testing.T, path string, width, height, orientation int) {
+	t.Helper()
+	writeTestJPEG(t, path, width, height)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	// Little endian TIFF header and an IFD0 with the single Orientation entry
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	for _, v := range []any{
+		uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), uint16(orientation), uint16(0), uint32(0),
+	} {
+		assert.NoError(t, binary.Write(&tiff, binary.LittleEndian, v))
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(data[:2]) // SOI
+	out.Write([]byte{0xff, 0xe1})
+	assert.NoError(t, binary.Write(&out, binary.BigEndian, uint16(len(segment)+2)))
+	out.Write(segment)
+	out.Write(data[2:])
+	assert.NoError(t, os.WriteFile(path, out.Bytes(), 0644))
+}
+
+// TestOverlayHandler tests that files in the dist directory shadow the site files
+func TestOverlayHandler(t *testing.T) {
+	siteDir := t.TempDir()
+	distDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(siteDir, "index.html"), []byte("site"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(siteDir, "photos.json"), []byte("site"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(distDir, "photos.json"), []byte("dist"), 0644))
+
+	server := httptest.NewServer(overlayHandler(siteDir, distDir))
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/index.html", http.StatusOK, "site"},
+		{"/photos.json", http.StatusOK, "dist"},
+		{"/../photos.json", http.StatusOK, "dist"},
+		{"/missing.jpg", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.body != "" {
+				assert.Equal(t, tt.body, string(body))
+			}
+		})
+	}
+}
+
+// TestLocalBuild tests an offline sync into the dist directory and serving the result
+func TestLocalBuild(t *testing.T) {
+	rootDir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.RootDir = rootDir
+	cfg.ExifExtractor = string(ExifExtractorGoExif)
+	cfg.Storage.Backend = StorageLocal
+	writeTestJPEG(t, filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-02_test.jpg"), 1200, 800)
+
+	processor, err := NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	jobs, err := processor.ScanJobs()
+	assert.NoError(t, err)
+	allPhotos, failed := processor.ProcessAll(t.Context(), jobs)
+	assert.Empty(t, failed)
+	outcome, err := processor.Publish(t.Context(), BuildAlbums(allPhotos), nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, PublishPublished, outcome)
+
+	distDir := filepath.Join(rootDir, DefaultLocalDir)
+	assert.Len(t, allPhotos, 1)
+	hash := allPhotos[0].Hash
+	version := processor.renditionVersion(hash)
+	for _, key := range []string{
+		"photos/originals/" + hash[:8] + "/DSC_2025-01-02_test.jpg",
+		"photos/thumbnails/" + version + "/DSC_2025-01-02_test-800w.webp",
+		"photos/photos.json",
+	} {
+		assert.FileExists(t, filepath.Join(distDir, filepath.FromSlash(key)))
+	}
+	assert.Equal(t, "/photos/thumbnails/"+version+"/DSC_2025-01-02_test-800w.webp", allPhotos[0].Thumbnail)
+	var urls []string
+	for _, source := range allPhotos[0].Srcset {
+		urls = append(urls, source.URL)
+	}
+	prefix := "/photos/thumbnails/" + version + "/DSC_2025-01-02_test"
+	assert.Equal(
+		t, []string{prefix + "-400w.webp", prefix + "-400w.jpg", prefix + "-800w.webp", prefix + "-800w.jpg"}, urls,
+	)
+	for _, source := range allPhotos[0].Srcset {
+		info, err := os.Stat(filepath.Join(distDir, filepath.FromSlash(source.URL)))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(source.Bytes), info.Size())
+	}
+
+	server := httptest.NewServer(overlayHandler(rootDir, distDir))
+	defer server.Close()
+	resp, err := http.Get(server.URL + allPhotos[0].Thumbnail)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/webp", resp.Header.Get("Content-Type"))
+
+	// Photos published elsewhere must be copied into the local build even when unchanged
+	processor.State = nil // Compare against photos.json only
+	photo := allPhotos[0]
+	processor.ExistingPhotos = map[string]Photo{photo.Filename: photo}
+	status, _, err := processor.Classify(jobs[0].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, PhotoUnchanged, status)
+	photo.Path = "https://cdn.example.com/photos/originals/" + photo.Filename
+	processor.ExistingPhotos[photo.Filename] = photo
+	status, _, err = processor.Classify(jobs[0].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, PhotoChanged, status)
+
+	// Other thumbnail settings publish under new keys
+	processor.ExistingPhotos[photo.Filename] = allPhotos[0]
+	processor.Config.Thumbnail.Quality = 70
+	status, _, err = processor.Classify(jobs[0].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, PhotoChanged, status)
+}
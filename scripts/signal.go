@@ -0,0 +1,48 @@
+package scripts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptSignals stop a run: Ctrl-C in a terminal and the default signal of kill, systemd and CI runners
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// drainOnSignal handles SIGINT and SIGTERM for runs that can finish their work in flight.
+// The first signal closes stopping: no new work is started, but running work completes.
+// The second signal cancels ctx and abandons the running work. stop releases the handler.
+func drainOnSignal(parent context.Context) (ctx context.Context, stopping <-chan struct{}, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+	drain := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, interruptSignals...)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Printf("\n⚠ Received %v, finishing photos in progress (repeat to abort)...\n", sig)
+			close(drain)
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case sig := <-signals:
+			fmt.Printf("\n⚠ Received %v again, aborting photos in progress...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, drain, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// cancelOnSignal returns a context that is canceled by SIGINT or SIGTERM
+func cancelOnSignal(parent context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(parent, interruptSignals...)
+}
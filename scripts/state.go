@@ -0,0 +1,422 @@
+package scripts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultStateFile is the local state database, relative to the root directory
+const DefaultStateFile = ".photos-state.jsonl"
+
+// PhotoState records what the last sync did with one photo: the source file it saw,
+// the settings it rendered with and the objects it stored
+type PhotoState struct {
+	Filename   string       `json:"filename"`
+	Source     string       `json:"source"` // Path relative to the root directory
+	Size       int64        `json:"size"`
+	ModTime    time.Time    `json:"mtime"`
+	Inode      uint64       `json:"inode,omitempty"`
+	Hash       string       `json:"hash"`
+	Renditions string       `json:"renditions"` // renditionVersion of the stored thumbnails
+	Backend    string       `json:"backend"`
+	Objects    []ObjectInfo `json:"objects"`         // Stored objects; ETag is empty when it was never observed
+	Photo      Photo        `json:"photo"`           // The photos.json entry, used when photos.json is lost
+	Stale      bool         `json:"stale,omitempty"` // Set by Reconcile when the stored objects no longer match
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// StateDB is a JSON-lines file with one PhotoState per line, keyed by filename.
+// It is loaded completely into memory and rewritten atomically by Save. It is safe for concurrent use.
+type StateDB struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]PhotoState
+	dirty   bool
+}
+
+// OpenStateDB loads the state database at path; a missing file yields an empty database.
+// Lines that cannot be parsed are skipped with a warning, so their photos are synced again.
+func OpenStateDB(path string) (*StateDB, error) {
+	db := &StateDB{path: path, records: make(map[string]PhotoState)}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record PhotoState
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Filename == "" {
+			fmt.Printf("⚠ Skipping invalid line %d of %s\n", line, path)
+			continue
+		}
+		db.records[record.Filename] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	return db, nil
+}
+
+// Path returns the file the database is stored in
+func (db *StateDB) Path() string {
+	return db.path
+}
+
+// Get returns the record of a photo
+func (db *StateDB) Get(filename string) (PhotoState, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	record, ok := db.records[filename]
+	return record, ok
+}
+
+// Put adds or replaces the record of a photo
+func (db *StateDB) Put(record PhotoState) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.records[record.Filename] = record
+	db.dirty = true
+}
+
+// Delete removes the record of a photo
+func (db *StateDB) Delete(filename string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.records[filename]; ok {
+		delete(db.records, filename)
+		db.dirty = true
+	}
+}
+
+// Records returns all records sorted by filename
+func (db *StateDB) Records() []PhotoState {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	records := make([]PhotoState, 0, len(db.records))
+	for _, record := range db.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Filename < records[j].Filename })
+	return records
+}
+
+// Len returns the number of records
+func (db *StateDB) Len() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.records)
+}
+
+// Save writes the database sorted by filename when it changed since it was loaded.
+// The file is written next to the target and renamed over it, so a crash never leaves half a file.
+func (db *StateDB) Save() error {
+	records := db.Records()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.dirty {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode state of %s: %w", record.Filename, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if err := writeFileAtomic(db.path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	db.dirty = false
+	return nil
+}
+
+// baseline returns the last synced entry of a photo: its state record when there is one, otherwise photos.json
+func (p *PhotoProcessor) baseline(filename string) (Photo, bool) {
+	if p.State != nil {
+		if record, ok := p.State.Get(filename); ok {
+			return restorePhoto(record.Photo), true
+		}
+	}
+	photo, ok := p.ExistingPhotos[filename]
+	return photo, ok
+}
+
+// fileHash returns the content hash of a file. Unless Rehash is set, the hash recorded in the state
+// database is trusted while the path, size, modification time and inode of the file are unchanged,
+// which avoids reading every image on every run.
+func (p *PhotoProcessor) fileHash(path string) (string, error) {
+	if !p.Rehash && p.State != nil {
+		record, ok := p.State.Get(filepath.Base(path))
+		if info, err := os.Stat(path); ok && err == nil && record.Hash != "" && p.sameSource(record, path, info) {
+			return record.Hash, nil
+		}
+	}
+	return calculateFileHash(path)
+}
+
+// sameSource reports whether a file still looks like the one a state record was made from
+func (p *PhotoProcessor) sameSource(record PhotoState, path string, info os.FileInfo) bool {
+	source, err := filepath.Rel(p.RootDir, path)
+	return err == nil &&
+		record.Source == filepath.ToSlash(source) &&
+		record.Size == info.Size() &&
+		record.ModTime.Equal(info.ModTime()) &&
+		record.Inode == fileInode(info)
+}
+
+// isStale reports whether Reconcile found the stored objects of a photo missing or changed
+func (p *PhotoProcessor) isStale(filename string) bool {
+	if p.State == nil {
+		return false
+	}
+	record, ok := p.State.Get(filename)
+	return ok && record.Stale
+}
+
+// recordState stores the outcome of processing a photo. Objects are the ones uploaded in this run;
+// for unchanged photos they are nil and the recorded objects are kept, or derived from the keys
+// when the photo predates the state database. Nothing is recorded in a dry run. Without storage the record
+// has no backend and no objects, but its file state still spares the next run from hashing the file.
+func (p *PhotoProcessor) recordState(path string, photo Photo, objects []ObjectInfo) {
+	if p.State == nil || p.DryRun {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	backend := p.Config.Storage.Backend
+	previous, hadRecord := p.State.Get(photo.Filename)
+	if p.Storage == nil {
+		backend, objects = "", nil
+	} else if objects == nil {
+		if hadRecord && previous.Hash == photo.Hash && previous.Renditions == p.renditionVersion(photo.Hash) {
+			objects = previous.Objects
+		} else {
+			for _, key := range p.photoKeys(photo) {
+				objects = append(objects, ObjectInfo{Key: key})
+			}
+		}
+	}
+
+	source, err := filepath.Rel(p.RootDir, path)
+	if err != nil {
+		source = path
+	}
+	photo.Status = ""
+	record := PhotoState{
+		Filename:   photo.Filename,
+		Source:     filepath.ToSlash(source),
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UTC(),
+		Inode:      fileInode(info),
+		Hash:       photo.Hash,
+		Renditions: p.renditionVersion(photo.Hash),
+		Backend:    backend,
+		Objects:    objects,
+		Photo:      photo,
+		UpdatedAt:  time.Now().UTC(),
+	}
+	if hadRecord && sameState(previous, record) {
+		return
+	}
+	p.State.Put(record)
+}
+
+// markStale records that a photo failed to sync, so the next sync processes it again even when
+// its file is unchanged. Photos that predate the state database get a record from their baseline.
+func (p *PhotoProcessor) markStale(path string, previous Photo) {
+	if p.State == nil || p.DryRun {
+		return
+	}
+	if _, ok := p.State.Get(previous.Filename); !ok {
+		p.recordState(path, previous, nil)
+	}
+	if record, ok := p.State.Get(previous.Filename); ok && !record.Stale {
+		record.Stale = true
+		record.UpdatedAt = time.Now().UTC()
+		p.State.Put(record)
+	}
+}
+
+// sameState reports whether two records only differ in their update time
+func sameState(a, b PhotoState) bool {
+	a.UpdatedAt, b.UpdatedAt = time.Time{}, time.Time{}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// SaveState forgets photos whose source files were not found by the scan and writes the state database
+func (p *PhotoProcessor) SaveState(jobs []Job) error {
+	if p.State == nil {
+		return nil
+	}
+	for _, filename := range p.forgottenRecords(jobs) {
+		p.State.Delete(filename)
+	}
+	return p.State.Save()
+}
+
+// forgottenRecords returns the recorded photos whose source files were not found by the scan
+func (p *PhotoProcessor) forgottenRecords(jobs []Job) []string {
+	if p.State == nil {
+		return nil
+	}
+	scanned := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		scanned[filepath.Base(job.Path)] = true
+	}
+	var forgotten []string
+	for _, record := range p.State.Records() {
+		if !scanned[record.Filename] {
+			forgotten = append(forgotten, record.Filename)
+		}
+	}
+	return forgotten
+}
+
+// ReconcileReport lists the differences between the state database and the storage
+type ReconcileReport struct {
+	Checked    int      `json:"checked"`    // Photos recorded for the current backend
+	Missing    []string `json:"missing"`    // Recorded keys that are not stored
+	Mismatched []string `json:"mismatched"` // Recorded keys stored with another ETag or size
+	Untracked  []string `json:"untracked"`  // Stored keys that no record mentions
+	Stale      []string `json:"stale"`      // Photos marked to be uploaded again
+	Learned    int      `json:"learned"`    // ETags and sizes recorded for the first time
+}
+
+// Consistent reports whether the storage holds exactly what the state database recorded
+func (r ReconcileReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Mismatched) == 0 && len(r.Untracked) == 0
+}
+
+// Reconcile compares the state database with a listing of the original and thumbnail prefixes.
+// Photos with missing or changed objects are marked stale so that the next sync uploads them again,
+// and ETags and sizes that were never observed are filled in from the listing.
+// The changes are kept in memory until SaveState.
+func (p *PhotoProcessor) Reconcile(ctx context.Context) (ReconcileReport, error) {
+	var report ReconcileReport
+	if p.State == nil || p.Storage == nil {
+		return report, nil
+	}
+
+	stored := make(map[string]ObjectInfo)
+	for _, prefix := range p.sweepPrefixes() {
+		objects, err := p.Storage.List(ctx, prefix)
+		if err != nil {
+			return report, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, obj := range objects {
+			stored[obj.Key] = obj
+		}
+	}
+
+	tracked := make(map[string]bool)
+	for _, record := range p.State.Records() {
+		if record.Backend != p.Config.Storage.Backend {
+			continue
+		}
+		report.Checked++
+
+		record.Objects = append([]ObjectInfo(nil), record.Objects...)
+		stale, learned := false, false
+		for i, obj := range record.Objects {
+			tracked[obj.Key] = true
+			actual, ok := stored[obj.Key]
+			switch {
+			case !ok:
+				report.Missing = append(report.Missing, obj.Key)
+				stale = true
+			case obj.ETag != "" && actual.ETag != "" && obj.ETag != actual.ETag,
+				obj.Size != 0 && actual.Size != 0 && obj.Size != actual.Size:
+				report.Mismatched = append(report.Mismatched, obj.Key)
+				stale = true
+			default:
+				if obj.ETag == "" && actual.ETag != "" {
+					record.Objects[i].ETag = actual.ETag
+					learned = true
+				}
+				if obj.Size == 0 && actual.Size != 0 {
+					record.Objects[i].Size = actual.Size
+					learned = true
+				}
+			}
+		}
+		if learned {
+			report.Learned++
+		}
+		if stale && !record.Stale {
+			report.Stale = append(report.Stale, record.Filename)
+		}
+		if (stale && !record.Stale) || learned {
+			record.Stale = record.Stale || stale
+			record.UpdatedAt = time.Now().UTC()
+			p.State.Put(record)
+		}
+	}
+
+	for key := range stored {
+		if !tracked[key] {
+			report.Untracked = append(report.Untracked, key)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Mismatched)
+	sort.Strings(report.Untracked)
+	return report, nil
+}
+
+// PrintReconcileReport writes a human readable summary of a reconciliation
+func PrintReconcileReport(report ReconcileReport) {
+	fmt.Printf("🟢 Reconciled %d recorded photos against storage\n", report.Checked)
+	for _, key := range report.Missing {
+		fmt.Printf("❌ missing: %s\n", key)
+	}
+	for _, key := range report.Mismatched {
+		fmt.Printf("❌ changed: %s\n", key)
+	}
+	for _, key := range report.Untracked {
+		fmt.Printf("⚠ untracked: %s\n", key)
+	}
+	if len(report.Stale) > 0 {
+		fmt.Printf("⚠ %d photos will be uploaded again by the next sync\n", len(report.Stale))
+	}
+	if report.Learned > 0 {
+		fmt.Printf("✓ Recorded ETags for %d photos\n", report.Learned)
+	}
+	if report.Consistent() {
+		fmt.Println("✓ Storage matches the state database")
+	}
+}
+
+// StatePath returns the state database file, resolving relative paths against rootDir
+func (c *Config) StatePath(rootDir string) string {
+	if filepath.IsAbs(c.StateFile) {
+		return c.StateFile
+	}
+	return filepath.Join(rootDir, c.StateFile)
+}
@@ -0,0 +1,193 @@
+package scripts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStateDBRoundTrip tests that records survive a save and that broken lines are skipped
+func TestStateDBRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	db, err := OpenStateDB(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, db.Len())
+	assert.NoError(t, db.Save())
+	assert.NoFileExists(t, path, "an unchanged database is not written")
+
+	db.Put(PhotoState{Filename: "b.jpg", Hash: "bb", Objects: []ObjectInfo{{Key: "k/b.jpg", ETag: "e"}}})
+	db.Put(PhotoState{Filename: "a.jpg", Hash: "aa"})
+	assert.NoError(t, db.Save())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, append(content, []byte("{not json\n")...), 0644))
+
+	db, err = OpenStateDB(path)
+	assert.NoError(t, err)
+	records := db.Records()
+	assert.Len(t, records, 2)
+	assert.Equal(t, "a.jpg", records[0].Filename)
+	assert.Equal(t, "k/b.jpg", records[1].Objects[0].Key)
+
+	db.Delete("a.jpg")
+	_, ok := db.Get("a.jpg")
+	assert.False(t, ok)
+}
+
+// TestStateDrivenSync tests that the state database keeps photos unchanged without photos.json
+// and that Reconcile marks photos with missing objects for upload
+func TestStateDrivenSync(t *testing.T) {
+	rootDir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.RootDir = rootDir
+	cfg.ExifExtractor = string(ExifExtractorGoExif)
+	cfg.Storage.Backend = StorageMemory
+	cfg.Thumbnail.Widths = []int{400}
+	cfg.Thumbnail.Formats = []string{FormatWebP}
+	writeTestJPEG(t, filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-02_a.jpg"), 600, 400)
+
+	processor, err := NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	storage := processor.Storage
+	jobs, err := processor.ScanJobs()
+	assert.NoError(t, err)
+	allPhotos, failed := processor.ProcessAll(t.Context(), jobs)
+	assert.Empty(t, failed)
+	assert.NoError(t, processor.SaveState(jobs))
+	assert.FileExists(t, cfg.StatePath(rootDir))
+
+	record, ok := processor.State.Get("DSC_2025-01-02_a.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, allPhotos[0].Hash, record.Hash)
+	assert.Equal(t, "web/photography/gallery_images/2025/DSC_2025-01-02_a.jpg", record.Source)
+	assert.Len(t, record.Objects, 3)
+	for _, obj := range record.Objects {
+		assert.NotEmpty(t, obj.ETag)
+	}
+
+	// No photos.json: the state alone keeps the photo unchanged
+	processor, err = NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	processor.Storage = storage
+	assert.Empty(t, processor.ExistingPhotos)
+	status, _, err := processor.Classify(jobs[0].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, PhotoUnchanged, status)
+
+	report, err := processor.Reconcile(t.Context())
+	assert.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, 1, report.Checked)
+
+	// An object deleted behind the tool's back makes the photo stale
+	assert.NoError(t, storage.Delete(t.Context(), record.Objects[1].Key))
+	_, err = putBytes(t.Context(), storage, "photos/thumbnails/stray.webp", []byte("x"), PutOptions{})
+	assert.NoError(t, err)
+	report, err = processor.Reconcile(t.Context())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{record.Objects[1].Key}, report.Missing)
+	assert.Equal(t, []string{"photos/thumbnails/stray.webp"}, report.Untracked)
+	assert.Equal(t, []string{"DSC_2025-01-02_a.jpg"}, report.Stale)
+	status, _, err = processor.Classify(jobs[0].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, PhotoChanged, status)
+
+	// Processing uploads it again and clears the mark
+	allPhotos, failed = processor.ProcessAll(t.Context(), jobs)
+	assert.Empty(t, failed)
+	assert.Equal(t, PhotoChanged, allPhotos[0].Status)
+	record, _ = processor.State.Get("DSC_2025-01-02_a.jpg")
+	assert.False(t, record.Stale)
+	exists, err := objectExists(t.Context(), storage, record.Objects[1].Key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// TestStateWithoutStorage tests that runs without a storage backend record the file state, without objects,
+// and that a later run with storage still uploads the photo
+func TestStateWithoutStorage(t *testing.T) {
+	rootDir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.RootDir = rootDir
+	cfg.ExifExtractor = string(ExifExtractorGoExif)
+	cfg.Storage.Backend = StorageMemory
+	cfg.Thumbnail.Widths = []int{400}
+	cfg.Thumbnail.Formats = []string{FormatWebP}
+	writeTestJPEG(t, filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-02_a.jpg"), 600, 400)
+
+	processor, err := NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	storage := processor.Storage
+	processor.Storage = nil
+	jobs, err := processor.ScanJobs()
+	assert.NoError(t, err)
+	allPhotos, failed := processor.ProcessAll(t.Context(), jobs)
+	assert.Empty(t, failed)
+	assert.NoError(t, processor.SaveState(jobs))
+
+	record, ok := processor.State.Get("DSC_2025-01-02_a.jpg")
+	if !assert.True(t, ok, "the file state is recorded without storage") {
+		return
+	}
+	assert.Equal(t, allPhotos[0].Hash, record.Hash)
+	assert.NotZero(t, record.Size)
+	assert.Empty(t, record.Backend)
+	assert.Empty(t, record.Objects)
+
+	// The next run trusts the recorded hash
+	processor, err = NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	record.Hash = "recorded"
+	processor.State.Put(record)
+	hash, err := processor.fileHash(jobs[0].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, "recorded", hash)
+	record.Hash = allPhotos[0].Hash
+	processor.State.Put(record)
+
+	// Nothing was uploaded, so a run with storage processes the photo
+	processor.Storage = storage
+	status, _, err := processor.Classify(jobs[0].Path)
+	assert.NoError(t, err)
+	assert.Equal(t, PhotoChanged, status)
+}
+
+// TestFileHashFastPath tests that recorded hashes are trusted only while the file looks unchanged
+func TestFileHashFastPath(t *testing.T) {
+	rootDir := t.TempDir()
+	path := filepath.Join(rootDir, "2025", "DSC_a.jpg")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	db, err := OpenStateDB(filepath.Join(rootDir, "state.jsonl"))
+	assert.NoError(t, err)
+	p := &PhotoProcessor{Config: DefaultConfig(), RootDir: rootDir, State: db}
+	db.Put(
+		PhotoState{
+			Filename: "DSC_a.jpg", Source: "2025/DSC_a.jpg", Size: info.Size(), ModTime: info.ModTime().UTC(),
+			Inode: fileInode(info), Hash: "recorded",
+		},
+	)
+	const actual = "5d41402abc4b2a76b9719d911017c592"
+
+	hash, err := p.fileHash(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "recorded", hash, "unchanged files are not read")
+
+	p.Rehash = true
+	hash, err = p.fileHash(path)
+	assert.NoError(t, err)
+	assert.Equal(t, actual, hash)
+	p.Rehash = false
+
+	assert.NoError(t, os.Chtimes(path, info.ModTime().Add(time.Second), info.ModTime().Add(time.Second)))
+	hash, err = p.fileHash(path)
+	assert.NoError(t, err)
+	assert.Equal(t, actual, hash, "a new modification time forces a rehash")
+}
@@ -0,0 +1,147 @@
+package scripts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Storage backends
+const (
+	StorageR2     = "r2"     // Cloudflare R2 or any S3 compatible service
+	StorageLocal  = "local"  // A directory on the local filesystem
+	StorageMemory = "memory" // In-process map, for tests and previews
+)
+
+// DefaultLocalDir is the output directory of the local backend, relative to the root directory
+const DefaultLocalDir = "dist"
+
+var (
+	// ErrNotFound is returned by Storage.Head when the object does not exist
+	ErrNotFound = errors.New("object not found")
+	// ErrPreconditionFailed is returned by Storage.Put when the stored object does not match PutOptions.IfMatch
+	// or PutOptions.IfNoneMatch
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Storage is the object store that originals, thumbnails and photos.json are published to.
+// Every call stops when its context is canceled.
+type Storage interface {
+	// Put stores body under key, replacing any existing object, or fails with ErrPreconditionFailed
+	// when the conditions of opts do not hold
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error)
+	// Head returns the metadata of an object, or ErrNotFound
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes an object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// DeleteMany removes several objects at once
+	DeleteMany(ctx context.Context, keys []string) error
+	// List returns all objects whose key starts with prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Copy copies an object within the store, keeping its HTTP metadata; a missing source is ErrNotFound
+	Copy(ctx context.Context, srcKey, dstKey string) (ObjectInfo, error)
+	// URL returns the public URL of an object
+	URL(key string) string
+}
+
+// PutOptions holds the HTTP metadata stored with an object and the conditions of a conditional write
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	IfMatch      string // Only replace the object while it has this ETag
+	IfNoneMatch  string // "*" only creates the object when none is stored
+}
+
+// checkPrecondition returns ErrPreconditionFailed when the stored object, nil when there is none,
+// does not satisfy the conditions of opts
+func checkPrecondition(key string, stored *ObjectInfo, opts PutOptions) error {
+	switch {
+	case opts.IfMatch != "" && (stored == nil || stored.ETag != opts.IfMatch):
+		return fmt.Errorf("%w: %s is not at ETag %s", ErrPreconditionFailed, key, opts.IfMatch)
+	case opts.IfNoneMatch == "*" && stored != nil:
+		return fmt.Errorf("%w: %s already exists", ErrPreconditionFailed, key)
+	}
+	return nil
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"lastModified,omitempty"`
+}
+
+// StorageConfig selects and configures the storage backend
+type StorageConfig struct {
+	Backend  string `yaml:"backend"`   // r2, local or memory
+	LocalDir string `yaml:"local_dir"` // Root directory of the local backend, relative to root_dir
+	BaseURL  string `yaml:"base_url"`  // URL prefix of objects in the local and memory backends
+}
+
+// NewStorage creates the backend selected in the configuration. R2 calls are retried according to cfg.Retry.
+// It returns nil without error when the r2 backend is selected but R2 is not configured.
+func NewStorage(cfg *Config, rootDir string) (Storage, error) {
+	switch cfg.Storage.Backend {
+	case StorageR2:
+		if !cfg.R2Configured() {
+			return nil, nil
+		}
+		client, err := NewR2Client(&cfg.R2)
+		if err != nil {
+			return nil, err
+		}
+		return NewRetryingStorage(client, cfg.Retry), nil
+	case StorageLocal:
+		local, err := NewLocalStorage(cfg.LocalDirPath(rootDir), cfg.Storage.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		return local, nil
+	case StorageMemory:
+		return NewMemoryStorage(cfg.Storage.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// LocalDirPath returns the directory of the local backend, resolving relative paths against rootDir
+func (c *Config) LocalDirPath(rootDir string) string {
+	if filepath.IsAbs(c.Storage.LocalDir) {
+		return c.Storage.LocalDir
+	}
+	return filepath.Join(rootDir, c.Storage.LocalDir)
+}
+
+// putBytes stores data under key
+func putBytes(ctx context.Context, s Storage, key string, data []byte, opts PutOptions) (ObjectInfo, error) {
+	return s.Put(ctx, key, bytes.NewReader(data), opts)
+}
+
+// putFile stores the contents of a local file under key
+func putFile(ctx context.Context, s Storage, key, localPath string, opts PutOptions) (ObjectInfo, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer file.Close()
+
+	if opts.ContentType == "" {
+		opts.ContentType = getContentType(localPath)
+	}
+	return s.Put(ctx, key, file, opts)
+}
+
+// objectExists reports whether key exists, treating errors other than ErrNotFound as failures
+func objectExists(ctx context.Context, s Storage, key string) (bool, error) {
+	_, err := s.Head(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
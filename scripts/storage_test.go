@@ -0,0 +1,160 @@
+package scripts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStorageBackends runs the same checks against every in-process Storage implementation
+func TestStorageBackends(t *testing.T) {
+	backends := []struct {
+		name string
+		new  func(t *testing.T) Storage
+	}{
+		{
+			name: "local",
+			new: func(t *testing.T) Storage {
+				s, err := NewLocalStorage(t.TempDir(), "http://localhost:3001/")
+				assert.NoError(t, err)
+				return s
+			},
+		},
+		{
+			name: "memory",
+			new:  func(t *testing.T) Storage { return NewMemoryStorage("http://localhost:3001/") },
+		},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			s := backend.new(t)
+
+			_, err := s.Head(t.Context(), "photos/original/a.jpg")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			info, err := putBytes(t.Context(), s, "photos/original/a.jpg", []byte("hello"), PutOptions{ContentType: "image/jpeg"})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), info.Size)
+			assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", info.ETag)
+
+			head, err := s.Head(t.Context(), "photos/original/a.jpg")
+			assert.NoError(t, err)
+			assert.Equal(t, info.ETag, head.ETag)
+			assert.Equal(t, int64(5), head.Size)
+
+			_, err = putBytes(t.Context(), s, "photos/thumbnail/a.webp", []byte("thumb"), PutOptions{})
+			assert.NoError(t, err)
+			_, err = putBytes(t.Context(), s, "photos/photos.json", []byte("[]"), PutOptions{})
+			assert.NoError(t, err)
+
+			objects, err := s.List(t.Context(), "photos/original/")
+			assert.NoError(t, err)
+			assert.Len(t, objects, 1)
+			assert.Equal(t, "photos/original/a.jpg", objects[0].Key)
+
+			objects, err = s.List(t.Context(), "photos/")
+			assert.NoError(t, err)
+			var keys []string
+			for _, obj := range objects {
+				keys = append(keys, obj.Key)
+			}
+			assert.Equal(t, []string{"photos/original/a.jpg", "photos/photos.json", "photos/thumbnail/a.webp"}, keys)
+
+			// Conditional writes
+			_, err = putBytes(t.Context(), s, "photos/photos.json", []byte("[1]"), PutOptions{IfNoneMatch: "*"})
+			assert.ErrorIs(t, err, ErrPreconditionFailed)
+			_, err = putBytes(t.Context(), s, "photos/photos.json", []byte("[1]"), PutOptions{IfMatch: info.ETag})
+			assert.ErrorIs(t, err, ErrPreconditionFailed)
+			_, err = putBytes(t.Context(), s, "photos/new.json", []byte("[1]"), PutOptions{IfMatch: info.ETag})
+			assert.ErrorIs(t, err, ErrPreconditionFailed)
+			stored, err := s.Head(t.Context(), "photos/photos.json")
+			assert.NoError(t, err)
+			_, err = putBytes(t.Context(), s, "photos/photos.json", []byte("[]"), PutOptions{IfMatch: stored.ETag})
+			assert.NoError(t, err)
+			_, err = putBytes(t.Context(), s, "photos/new.json", []byte("[1]"), PutOptions{IfNoneMatch: "*"})
+			assert.NoError(t, err)
+			assert.NoError(t, s.Delete(t.Context(), "photos/new.json"))
+
+			copied, err := s.Copy(t.Context(), "photos/original/a.jpg", "photos/trash/a.jpg")
+			assert.NoError(t, err)
+			assert.Equal(t, info.ETag, copied.ETag)
+			_, err = s.Copy(t.Context(), "photos/missing.jpg", "photos/trash/missing.jpg")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			assert.NoError(t, s.DeleteMany(t.Context(), []string{"photos/original/a.jpg", "photos/missing.jpg"}))
+			exists, err := objectExists(t.Context(), s, "photos/original/a.jpg")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+			assert.NoError(t, s.Delete(t.Context(), "photos/original/a.jpg"))
+
+			assert.Equal(t, "http://localhost:3001/photos/photos.json", s.URL("photos/photos.json"))
+		})
+	}
+}
+
+// TestLocalStorageRejectsEscapingKeys tests that keys cannot point outside the storage directory
+func TestLocalStorageRejectsEscapingKeys(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalStorage(filepath.Join(dir, "dist"), "")
+	assert.NoError(t, err)
+
+	for _, key := range []string{"", "../outside.jpg", "photos/../../outside.jpg"} {
+		_, err := putBytes(t.Context(), s, key, []byte("x"), PutOptions{})
+		assert.Error(t, err, key)
+	}
+	_, err = os.Stat(filepath.Join(dir, "outside.jpg"))
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, strings.HasPrefix(s.URL("a.jpg"), "/"))
+}
+
+// TestLocalStorageCreatesDirOnPut tests that the directory is created by the first Put, not by the constructor
+func TestLocalStorageCreatesDirOnPut(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dist")
+	s, err := NewLocalStorage(dir, "")
+	assert.NoError(t, err)
+	assert.NoDirExists(t, dir, "a dry run must not create the directory")
+
+	objects, err := s.List(t.Context(), "photos/")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+	_, err = s.Head(t.Context(), "photos/a.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, s.Delete(t.Context(), "photos/a.jpg"))
+	assert.NoDirExists(t, dir)
+
+	_, err = putBytes(t.Context(), s, "photos/a.jpg", []byte("x"), PutOptions{})
+	assert.NoError(t, err)
+	objects, err = s.List(t.Context(), "photos/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+}
+
+// TestNewStorage tests backend selection from the configuration
+func TestNewStorage(t *testing.T) {
+	rootDir := t.TempDir()
+
+	cfg := DefaultConfig()
+	s, err := NewStorage(cfg, rootDir)
+	assert.NoError(t, err)
+	assert.Nil(t, s, "r2 without credentials must not create a client")
+
+	cfg.Storage.Backend = StorageLocal
+	s, err = NewStorage(cfg, rootDir)
+	assert.NoError(t, err)
+	assert.IsType(t, &LocalStorage{}, s)
+	assert.Equal(t, filepath.Join(rootDir, "dist"), s.(*LocalStorage).Dir())
+
+	cfg.Storage.Backend = StorageMemory
+	s, err = NewStorage(cfg, rootDir)
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryStorage{}, s)
+
+	cfg.Storage.Backend = "ftp"
+	_, err = NewStorage(cfg, rootDir)
+	assert.Error(t, err)
+	assert.Error(t, cfg.Validate())
+}
@@ -0,0 +1,192 @@
+package scripts
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // Zone names resolve on systems without a zoneinfo database
+
+	"github.com/bradfitz/latlong"
+)
+
+// Where the UTC offset of a capture time came from, the offsetSource of photos.json
+const (
+	OffsetFromExif     = "exif"     // OffsetTimeOriginal or OffsetTime
+	OffsetFromGPS      = "gps"      // Difference between the camera clock and the UTC time of the GPS fix
+	OffsetFromLocation = "location" // Zone at the GPS position
+	OffsetFromFolder   = "folder"   // timezone.folders
+	OffsetFromDefault  = "default"  // timezone.default
+)
+
+// maxGPSClockDrift is how far the camera clock may be off a whole quarter hour from the GPS time
+// for the difference to be taken as its UTC offset
+const maxGPSClockDrift = 5 * time.Minute
+
+// maxUTCOffset is the largest UTC offset in use, Kiribati's +14:00
+const maxUTCOffset = 14 * time.Hour
+
+// TimezoneConfig sets the time zones of photos whose capture time has no UTC offset in EXIF
+type TimezoneConfig struct {
+	Default string            `yaml:"default"` // Zone of all other photos; empty leaves their offset unknown
+	Folders map[string]string `yaml:"folders"` // Folder below img_dir to the zone the photos in it were taken in
+}
+
+// TimeZones are the parsed zones of a TimezoneConfig
+type TimeZones struct {
+	Default *time.Location
+	Folders map[string]*time.Location // Key: folder below img_dir, with forward slashes
+}
+
+// NewTimeZones parses the zones of a TimezoneConfig; a zone is an IANA name such as "Asia/Tokyo"
+// or a fixed offset such as "+09:00"
+func NewTimeZones(cfg TimezoneConfig) (*TimeZones, error) {
+	zones := &TimeZones{Folders: make(map[string]*time.Location)}
+	if cfg.Default != "" {
+		zone, err := loadZone(cfg.Default)
+		if err != nil {
+			return nil, fmt.Errorf("timezone.default is invalid: %w", err)
+		}
+		zones.Default = zone
+	}
+
+	folders := make([]string, 0, len(cfg.Folders))
+	for folder := range cfg.Folders {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	for _, folder := range folders {
+		key := path.Clean(strings.Trim(strings.ReplaceAll(folder, "\\", "/"), "/"))
+		if key == "." || strings.HasPrefix(key, "../") {
+			return nil, fmt.Errorf("timezone.folders %q must be a folder below img_dir", folder)
+		}
+		zone, err := loadZone(cfg.Folders[folder])
+		if err != nil {
+			return nil, fmt.Errorf("timezone.folders %q is invalid: %w", folder, err)
+		}
+		zones.Folders[key] = zone
+	}
+	return zones, nil
+}
+
+// loadZone parses an IANA zone name or a UTC offset such as "+09:00" or "-0530"
+func loadZone(name string) (*time.Location, error) {
+	if offset, ok := parseOffset(name); ok {
+		return time.FixedZone("", offset), nil
+	}
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return time.LoadLocation(name)
+}
+
+// folderZone returns the zone of the longest configured folder that contains folder, nil when none does
+func (z *TimeZones) folderZone(folder string) *time.Location {
+	if z == nil {
+		return nil
+	}
+	folder = path.Clean(strings.ReplaceAll(folder, "\\", "/"))
+	var zone *time.Location
+	longest := -1
+	for key, loc := range z.Folders {
+		if (folder == key || strings.HasPrefix(folder, key+"/")) && len(key) > longest {
+			zone, longest = loc, len(key)
+		}
+	}
+	return zone
+}
+
+// Resolve returns the capture time of a photo in folder below img_dir with its UTC offset, and where the
+// offset came from: EXIF, the GPS time, the zone at the GPS position, the folder zone or the default zone,
+// in that order. Without any the time is the camera clock as UTC and the source is empty.
+// A folder zone is where its photos were taken, so times with a known offset are moved to it; this corrects
+// the date of cameras that were left on the time at home.
+func (z *TimeZones) Resolve(m PhotoMetadata, folder string) (time.Time, string) {
+	taken := m.CaptureTime()
+	if taken.IsZero() {
+		return time.Time{}, ""
+	}
+	zone := z.folderZone(folder)
+	inFolderZone := func(t time.Time, source string) (time.Time, string) {
+		if zone != nil {
+			t = t.In(zone)
+		}
+		return t, source
+	}
+
+	if _, ok := parseOffset(m.Capture.Offset); ok {
+		return inFolderZone(taken, OffsetFromExif)
+	}
+	if offset, ok := gpsOffset(m, taken); ok {
+		return inFolderZone(withOffset(taken, offset), OffsetFromGPS)
+	}
+	if location := locationZone(m); location != nil {
+		return inFolderZone(inZone(taken, location), OffsetFromLocation)
+	}
+	if zone != nil {
+		return inZone(taken, zone), OffsetFromFolder
+	}
+	if z != nil && z.Default != nil {
+		return inZone(taken, z.Default), OffsetFromDefault
+	}
+	return taken, ""
+}
+
+// gpsOffset returns the UTC offset of the camera clock in seconds from the GPS time of a photo, rounded to
+// a quarter hour; false without a GPS time or when the difference is no plausible offset
+func gpsOffset(m PhotoMetadata, local time.Time) (int, bool) {
+	if m.GPS == nil || m.GPS.Time == "" {
+		return 0, false
+	}
+	fix, err := time.Parse(time.RFC3339Nano, m.GPS.Time)
+	if err != nil {
+		return 0, false
+	}
+	diff := local.Sub(fix)
+	offset := diff.Round(15 * time.Minute)
+	if (diff-offset).Abs() > maxGPSClockDrift || offset.Abs() > maxUTCOffset {
+		return 0, false
+	}
+	return int(offset.Seconds()), true
+}
+
+// locationZone returns the zone at the GPS position of a photo, which is taken to be the zone the camera clock
+// was set to; nil without a position or at sea, where no zone is mapped
+func locationZone(m PhotoMetadata) *time.Location {
+	if m.GPS == nil || (m.GPS.Latitude == 0 && m.GPS.Longitude == 0) {
+		return nil
+	}
+	name := latlong.LookupZoneName(m.GPS.Latitude, m.GPS.Longitude)
+	if name == "" {
+		return nil
+	}
+	zone, err := time.LoadLocation(name)
+	if err != nil {
+		return nil
+	}
+	return zone
+}
+
+// parseOffset parses a UTC offset as in EXIF, "+09:00", or without the colon; the result is in seconds
+func parseOffset(s string) (int, bool) {
+	t, err := time.Parse("-07:00", s)
+	if err != nil {
+		t, err = time.Parse("-0700", s)
+	}
+	if err != nil {
+		return 0, false
+	}
+	_, offset := t.Zone()
+	return offset, true
+}
+
+// withOffset returns the wall clock time of t at a fixed UTC offset in seconds
+func withOffset(t time.Time, offset int) time.Time {
+	return inZone(t, time.FixedZone("", offset))
+}
+
+// inZone returns the wall clock time of t in zone, which takes the offset in effect at that time there
+func inZone(t time.Time, zone *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone)
+}
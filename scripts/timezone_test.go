@@ -0,0 +1,142 @@
+package scripts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResolveCaptureTime tests the order in which the UTC offset of a capture time is looked for
+func TestResolveCaptureTime(t *testing.T) {
+	zones, err := NewTimeZones(TimezoneConfig{
+		Default: "+08:00",
+		Folders: map[string]string{
+			"2024":        "Europe/Paris",
+			"2024/japan/": "Asia/Tokyo",
+			"trips/india": "+05:30",
+		},
+	})
+	assert.NoError(t, err)
+
+	capture := func(original, offset string) PhotoMetadata {
+		return PhotoMetadata{Version: MetadataVersion, Capture: &CaptureInfo{Original: original, Offset: offset}}
+	}
+	withGPS := func(m PhotoMetadata, fix string) PhotoMetadata {
+		m.GPS = &GPSInfo{Latitude: 35.658, Longitude: 139.705, Time: fix}
+		return m
+	}
+	at := func(m PhotoMetadata, latitude, longitude float64) PhotoMetadata {
+		m.GPS = &GPSInfo{Latitude: latitude, Longitude: longitude}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		zones    *TimeZones
+		metadata PhotoMetadata
+		folder   string
+		want     string
+		source   string
+	}{
+		{
+			"Offset from EXIF",
+			zones, capture("2025-11-09T22:05:34", "+08:00"), "2025", "2025-11-09T22:05:34+08:00", OffsetFromExif,
+		},
+		{
+			"Offset from the GPS time",
+			zones, withGPS(capture("2024-12-31T22:15:10", ""), "2024-12-31T13:15:08Z"), "2025",
+			"2024-12-31T22:15:10+09:00", OffsetFromGPS,
+		},
+		{
+			"Half hour offset from the GPS time",
+			zones, withGPS(capture("2024-03-01T09:00:00", ""), "2024-03-01T03:31:40.5Z"), "2025",
+			"2024-03-01T09:00:00+05:30", OffsetFromGPS,
+		},
+		{
+			"GPS time of an old fix falls back to the position",
+			zones, withGPS(capture("2024-03-01T09:00:00", ""), "2024-03-01T02:52:00Z"), "2025",
+			"2024-03-01T09:00:00+09:00", OffsetFromLocation,
+		},
+		{
+			"Zone at the position in summer",
+			zones, at(capture("2024-07-15T10:00:00", ""), 37.77, -122.42), "2025", "2024-07-15T10:00:00-07:00", OffsetFromLocation,
+		},
+		{
+			"Position in the folder zone",
+			zones, at(capture("2024-07-15T10:00:00", ""), 48.85, 2.35), "2024/japan", "2024-07-15T17:00:00+09:00", OffsetFromLocation,
+		},
+		{
+			"Position at sea",
+			zones, at(capture("2024-07-15T10:00:00", ""), 0, -140), "2023", "2024-07-15T10:00:00+08:00", OffsetFromDefault,
+		},
+		{
+			"Folder zone in winter",
+			zones, capture("2024-01-15T10:00:00", ""), "2024", "2024-01-15T10:00:00+01:00", OffsetFromFolder,
+		},
+		{
+			"Folder zone in summer",
+			zones, capture("2024-07-15T10:00:00", ""), "2024/lyon", "2024-07-15T10:00:00+02:00", OffsetFromFolder,
+		},
+		{
+			"Longest folder wins",
+			zones, capture("2024-07-15T10:00:00", ""), "2024/japan/kyoto", "2024-07-15T10:00:00+09:00", OffsetFromFolder,
+		},
+		{
+			"Fixed folder offset",
+			zones, capture("2023-02-01T18:00:00", ""), "trips/india", "2023-02-01T18:00:00+05:30", OffsetFromFolder,
+		},
+		{
+			"Camera on the time at home is moved to the folder zone",
+			zones, capture("2024-12-31T20:00:00", "+01:00"), "2024/japan", "2025-01-01T04:00:00+09:00", OffsetFromExif,
+		},
+		{
+			"Default zone",
+			zones, capture("2023-02-01T18:00:00", ""), "2023", "2023-02-01T18:00:00+08:00", OffsetFromDefault,
+		},
+		{"Unknown offset", nil, capture("2023-02-01T18:00:00", ""), "2023", "2023-02-01T18:00:00Z", ""},
+		{"No capture time", zones, PhotoMetadata{Version: MetadataVersion}, "2024", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken, source := tt.zones.Resolve(tt.metadata, tt.folder)
+			assert.Equal(t, tt.source, source)
+			if tt.want == "" {
+				assert.True(t, taken.IsZero())
+				return
+			}
+			assert.Equal(t, tt.want, taken.Format(time.RFC3339))
+		})
+	}
+}
+
+// TestNewTimeZones tests that invalid zones and folders are rejected
+func TestNewTimeZones(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TimezoneConfig
+		problem string
+	}{
+		{"Empty", TimezoneConfig{}, ""},
+		{
+			"Names and offsets",
+			TimezoneConfig{Default: "UTC", Folders: map[string]string{"a": "-0330", `b\c`: "America/New_York"}}, "",
+		},
+		{"Unknown default", TimezoneConfig{Default: "Mars/Olympus"}, "timezone.default"},
+		{"Local zone of the machine", TimezoneConfig{Default: "Local"}, "timezone.default"},
+		{"Missing folder zone", TimezoneConfig{Folders: map[string]string{"2024": ""}}, `timezone.folders "2024"`},
+		{"Root folder", TimezoneConfig{Folders: map[string]string{"/": "UTC"}}, "below img_dir"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTimeZones(tt.cfg)
+			if tt.problem == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.problem)
+		})
+	}
+}
@@ -2,7 +2,9 @@ package scripts
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -39,22 +41,57 @@ const (
 
 	// Concurrency
 	MaxConcurrency = 10
+
+	// ImmutableCacheControl is sent with originals and thumbnails, whose keys change with their content
+	ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+	// keyHashLength is the number of hex digits of a hash used in object keys
+	keyHashLength = 8
+
+	// renditionScheme is bumped whenever thumbnail generation changes its output for the same input,
+	// so that derived objects get new keys instead of stale cached copies (2: EXIF orientation)
+	renditionScheme = 2
+
+	// DefaultDateRegex extracts year, month and day from filenames like DSC_2025-11-09_001.jpg
+	DefaultDateRegex = `DSC_(\d{4})-(\d{2})-(\d{2})`
 )
 
 // Photo represents a single photo entry
 type Photo struct {
-	Filename  string                 `json:"filename"`
-	Path      string                 `json:"path"`
-	Thumbnail string                 `json:"thumbnail"`
-	Alt       string                 `json:"alt"`
-	Year      string                 `json:"year"`
-	Month     string                 `json:"month"`
-	Date      string                 `json:"date"` // YYYY-MM-DD for sorting
-	Width     int                    `json:"width,omitempty"`
-	Height    int                    `json:"height,omitempty"`
-	Exif      map[string]interface{} `json:"exif,omitempty"` // Complete EXIF data
-	Hash      string                 `json:"hash,omitempty"` // File hash for caching
-	Timestamp int64                  `json:"-"`              // Timestamp for sorting
+	Filename     string                 `json:"filename"`
+	Path         string                 `json:"path"`
+	Thumbnail    string                 `json:"thumbnail"`
+	Alt          string                 `json:"alt"`
+	Year         string                 `json:"year"`
+	Month        string                 `json:"month"`
+	Date         string                 `json:"date"`                   // YYYY-MM-DD where the photo was taken
+	Taken        string                 `json:"taken,omitempty"`        // RFC 3339, empty when the UTC offset is unknown
+	OffsetSource string                 `json:"offsetSource,omitempty"` // Where the offset of Taken came from, e.g. exif
+	Width        int                    `json:"width,omitempty"`
+	Height       int                    `json:"height,omitempty"`
+	Srcset       []PhotoSource          `json:"srcset,omitempty"`   // Responsive renditions, narrowest first, one per format
+	Exif         map[string]interface{} `json:"exif,omitempty"`     // Legacy EXIF keys, written from Metadata
+	Metadata     *PhotoMetadata         `json:"metadata,omitempty"` // Typed metadata
+	Hash         string                 `json:"hash,omitempty"`     // File hash for caching
+	Timestamp    int64                  `json:"-"`                  // Timestamp for sorting
+	Status       PhotoStatus            `json:"-"`                  // Status of the photo in the current run
+}
+
+// PhotoSource is one responsive rendition of a photo in one format
+type PhotoSource struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int    `json:"bytes"`
+	Format string `json:"format,omitempty"` // webp, avif or jpeg; webp when empty
+}
+
+// sourceFormat returns the format of a rendition, treating entries that predate formats as WebP
+func sourceFormat(source PhotoSource) string {
+	if source.Format == "" {
+		return FormatWebP
+	}
+	return source.Format
 }
 
 // YearAlbum represents a collection of photos for a specific year
@@ -65,60 +102,86 @@ type YearAlbum struct {
 
 // PhotoProcessor handles the processing of photos
 type PhotoProcessor struct {
+	Config         *Config
 	RootDir        string
 	ImgDirPath     string
-	R2Client       *R2Client
+	Workers        int             // Number of concurrent workers, MaxConcurrency when zero
+	DryRun         bool            // Compute results without uploading, deleting or writing anything
+	Rehash         bool            // Hash every file instead of trusting unchanged size, mtime and inode
+	Storage        Storage         // Where originals, thumbnails and photos.json are published, nil when not configured
+	State          *StateDB        // What previous syncs stored, nil when not tracked
+	Stopping       <-chan struct{} // Closed when no further photos should be started, e.g. on SIGINT
+	Force          bool            // Prune even when the deletion limits are exceeded
+	Confirm        ConfirmFunc     // Asked before pruning, nil prunes without asking
+	Overwrite      bool            // Publish photos.json even when the remote copy was changed elsewhere
 	ThumbnailBase  string
 	ExistingPhotos map[string]Photo // Key: Filename
 	NewPhotos      []Photo
 	Mutex          sync.Mutex
 	DateRegex      *regexp.Regexp
+	TimeZones      *TimeZones // Zones of capture times without a UTC offset in EXIF
 }
 
-// NewPhotoProcessor creates a new PhotoProcessor
-func NewPhotoProcessor() (*PhotoProcessor, error) {
-	rootDir, err := os.Getwd()
+// NewPhotoProcessor creates a new PhotoProcessor from a validated configuration
+func NewPhotoProcessor(cfg *Config) (*PhotoProcessor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	rootDir, err := cfg.ResolveRootDir()
 	if err != nil {
-		return nil, fmt.Errorf("error getting current working directory: %w", err)
+		return nil, fmt.Errorf("error resolving root directory: %w", err)
 	}
 
-	// Initialize R2 client
-	var r2Client *R2Client
-	var thumbnailBase string
+	CurrentExifExtractor = ExifExtractorType(cfg.ExifExtractor)
 
-	r2Config, err := LoadR2Config()
+	// Initialize storage backend
+	thumbnailBase := fmt.Sprintf(
+		"%s/%s%s",
+		strings.TrimRight(cfg.R2.CDNUrl, "/"),
+		cfg.R2.BasePrefix,
+		cfg.R2.ThumbnailPrefix,
+	)
+
+	storage, err := NewStorage(cfg, rootDir)
+	switch {
+	case err != nil:
+		fmt.Printf("⚠ Warning: Failed to create %s storage: %v\n", cfg.Storage.Backend, err)
+	case storage == nil:
+		fmt.Println("⚠ Warning: R2 is not configured (endpoint, bucket and access keys are required)")
+		fmt.Println("Using default/empty configuration... (use -storage local for an offline build)")
+	default:
+		fmt.Printf("✓ %s storage initialized successfully\n", cfg.Storage.Backend)
+	}
+
+	timeZones, err := NewTimeZones(cfg.Timezone)
 	if err != nil {
-		fmt.Printf("⚠ Warning: R2 configuration load failed: %v\n", err)
-		fmt.Println("Using default/empty configuration...")
-	} else {
-		thumbnailBase = fmt.Sprintf(
-			"%s/%s%s",
-			strings.TrimRight(r2Config.CDNUrl, "/"),
-			r2Config.BasePrefix,
-			r2Config.ThumbnailPrefix,
-		)
-		r2Client, err = NewR2Client(r2Config)
-		if err != nil {
-			fmt.Printf("⚠ Warning: Failed to create R2 client: %v\n", err)
-		} else {
-			fmt.Println("✓ R2 client initialized successfully")
-		}
+		return nil, err
+	}
+
+	state, err := OpenStateDB(cfg.StatePath(rootDir))
+	if err != nil {
+		return nil, err
 	}
 
 	return &PhotoProcessor{
+		Config:         cfg,
 		RootDir:        rootDir,
-		ImgDirPath:     filepath.Join(rootDir, ImgDir),
-		R2Client:       r2Client,
+		ImgDirPath:     filepath.Join(rootDir, cfg.ImgDir),
+		Workers:        cfg.MaxConcurrency,
+		Storage:        storage,
+		State:          state,
 		ThumbnailBase:  thumbnailBase,
 		ExistingPhotos: make(map[string]Photo),
-		DateRegex:      regexp.MustCompile(`DSC_(\d{4})-(\d{2})-(\d{2})`),
+		DateRegex:      regexp.MustCompile(cfg.DateRegex),
+		TimeZones:      timeZones,
 	}, nil
 }
 
 // LoadExistingMetadata loads existing photos.json
 func (p *PhotoProcessor) LoadExistingMetadata() ([]byte, error) {
 	var content []byte
-	outputFilePath := filepath.Join(p.RootDir, OutputFile)
+	outputFilePath := p.OutputFilePath()
 	if _, err := os.Stat(outputFilePath); err == nil {
 		content, err = os.ReadFile(outputFilePath)
 		if err != nil {
@@ -129,15 +192,7 @@ func (p *PhotoProcessor) LoadExistingMetadata() ([]byte, error) {
 		if err := json.Unmarshal(content, &albums); err == nil {
 			for _, album := range albums {
 				for _, photo := range album.Photos {
-					// Restore Timestamp from Exif if available
-					if val, ok := photo.Exif["DateTimeOriginal"]; ok {
-						if dateStr, ok := val.(string); ok {
-							if t, err := time.Parse("2006:01:02 15:04:05", dateStr); err == nil {
-								photo.Timestamp = t.Unix()
-							}
-						}
-					}
-					p.ExistingPhotos[photo.Filename] = photo
+					p.ExistingPhotos[photo.Filename] = restorePhoto(photo)
 				}
 			}
 			fmt.Printf("🟢 Loaded existing metadata for %d photos.\n", len(p.ExistingPhotos))
@@ -146,6 +201,25 @@ func (p *PhotoProcessor) LoadExistingMetadata() ([]byte, error) {
 	return content, nil
 }
 
+// restorePhoto completes an entry read back from photos.json or the state database: it builds the typed
+// metadata of older entries and sets the sort timestamp, which is not serialized, from the capture time
+func restorePhoto(photo Photo) Photo {
+	photo = upgradeMetadata(photo)
+	if taken, err := time.Parse(time.RFC3339, photo.Taken); err == nil {
+		photo.Timestamp = taken.Unix()
+	} else if photo.Metadata != nil {
+		if t := photo.Metadata.CaptureTime(); !t.IsZero() {
+			photo.Timestamp = t.Unix()
+		}
+	}
+	return photo
+}
+
+// OutputFilePath returns the absolute path of the local photos.json
+func (p *PhotoProcessor) OutputFilePath() string {
+	return filepath.Join(p.RootDir, p.Config.OutputFile)
+}
+
 // calculateFileHash calculates MD5 hash of a file
 func calculateFileHash(filePath string) (string, error) {
 	file, err := os.Open(filePath)
@@ -168,123 +242,128 @@ func calculateFileHash(filePath string) (string, error) {
 }
 
 // processPhoto processes a single photo
-func (p *PhotoProcessor) processPhoto(path string, yearDirName string) (Photo, error) {
+func (p *PhotoProcessor) processPhoto(ctx context.Context, path string, yearDirName string) (Photo, error) {
 	filename := filepath.Base(path)
 	filenameNoExt := strings.TrimSuffix(filename, filepath.Ext(filename))
 
-	// Calculate hash
-	hash, err := calculateFileHash(path)
+	// Calculate hash and check if photo exists with a matching hash
+	status, hash, err := p.Classify(path)
 	if err != nil {
-		return Photo{}, fmt.Errorf("failed to calculate hash: %w", err)
-	}
-
-	// Check if photo exists and hash matches
-	if existing, ok := p.ExistingPhotos[filename]; ok {
-		// 第一次是相同的
-		if existing.Hash == hash {
-			// if true {
-			// 	existing.Hash = hash
-			// Photo hasn't changed, return existing data
-			// But ensure path is correct (in case of URL changes, though hash check implies content same)
-			// We might want to re-verify R2 existence if we were being very strict, but for perf we skip
-			// fmt.Printf("Skipping unchanged photo: %s\n", filename)
-			return existing, nil
+		return Photo{}, err
+	}
+	if status == PhotoUnchanged {
+		// Photo hasn't changed, return existing data
+		// We might want to re-verify R2 existence if we were being very strict, but for perf we skip
+		existing, _ := p.baseline(filename)
+		if published, ok := p.ExistingPhotos[filename]; ok {
+			existing.Alt = published.Alt
+		}
+		// A sidecar is edited without touching the photo, so its metadata is read again
+		if XMPSidecar(path) != "" {
+			if exifData, _, _, _, err := ExtractMetadata(ctx, path); err == nil {
+				existing.SetMetadata(NewPhotoMetadata(exifData))
+			}
 		}
+		// The time zones may have been configured since
+		p.setCaptureTime(&existing, path)
+		existing.Status = status
+		p.recordState(path, existing, nil)
+		return existing, nil
 	}
 
 	// New or modified photo
-	fmt.Printf("🟢 Processing %s...\n", filename)
+	if p.DryRun {
+		fmt.Printf("🟢 Planning %s...\n", filename)
+	} else {
+		fmt.Printf("🟢 Processing %s...\n", filename)
+	}
 
 	relPath, _ := filepath.Rel(p.RootDir, path)
 	webPath := strings.ReplaceAll(relPath, "\\", "/")
-	if after, ok := strings.CutPrefix(webPath, WebPhotographyPrefix); ok {
+	if after, ok := strings.CutPrefix(webPath, p.Config.WebPrefix); ok {
 		webPath = after
 	}
 
-	var finalPath, finalThumbnail string
+	// Extract EXIF using configured extractor, with XMP on top; the orientation is needed for the thumbnails
+	exifData, width, height, _, exifErr := ExtractMetadata(ctx, path)
+	orientation := ExifOrientation(exifData)
+	width, height = OrientedSize(width, height, orientation)
 
-	// R2 Upload Logic
-	if p.R2Client != nil {
+	var finalPath, finalThumbnail string
+	var srcset []PhotoSource
+	var objects []ObjectInfo
+
+	// Storage Upload Logic
+	if p.Storage != nil && p.DryRun {
+		// Report the URLs the uploads would produce without touching the bucket
+		finalPath = p.Storage.URL(p.originalKey(filename, hash))
+		var thumbnailKey string
+		if srcset, thumbnailKey, _, err = p.uploadRenditions(ctx, path, hash, orientation); err != nil {
+			return Photo{}, err
+		}
+		finalThumbnail = p.Storage.URL(thumbnailKey)
+	} else if p.Storage != nil {
 		// 1. Upload Original
-		originalKey := fmt.Sprintf("%s%s%s", p.R2Client.config.BasePrefix, p.R2Client.config.OriginalPrefix, filename)
+		originalKey := p.originalKey(filename, hash)
 		// We could check existence, but since hash changed or it's new, we should probably upload
 		// Or we can check if it exists to avoid re-uploading if only local metadata changed?
 		// For simplicity/safety, if hash changed, we upload.
 
-		if err := p.R2Client.UploadFile(path, originalKey, "public, max-age=31536000"); err != nil {
+		if info, err := putFile(
+			ctx, p.Storage, originalKey, path, PutOptions{CacheControl: ImmutableCacheControl},
+		); err != nil {
 			fmt.Printf("❌ Failed to upload original %s: %v\n", filename, err)
 			finalPath = webPath
 			return Photo{}, fmt.Errorf("failed to upload original %s: %w", filename, err)
 		} else {
-			finalPath = p.R2Client.GetCDNUrl(originalKey)
+			finalPath = p.Storage.URL(originalKey)
+			objects = append(objects, info)
 		}
 
-		// 2. Upload Thumbnail
-		thumbnailKey := fmt.Sprintf(
-			"%s%s%s%s", p.R2Client.config.BasePrefix, p.R2Client.config.ThumbnailPrefix, filenameNoExt, ExtWebP,
-		)
-		thumbnailData, err := GenerateThumbnail(path, DefaultThumbnailConfig())
-		if err != nil {
-			fmt.Printf("❌ Failed to generate thumbnail for %s: %v\n", filename, err)
-			finalThumbnail = p.ThumbnailBase + filenameNoExt + ".webp"
-			return Photo{}, fmt.Errorf("failed to upload thumbnail %s: %w", filename, err)
-		} else {
-			if err := p.R2Client.UploadBytes(
-				thumbnailData, thumbnailKey, "image/webp", "public, max-age=31536000",
-			); err != nil {
-				fmt.Printf("❌ Failed to upload thumbnail for %s: %v\n", filename, err)
-				finalThumbnail = p.ThumbnailBase + filenameNoExt + ".webp"
-			} else {
-				finalThumbnail = p.R2Client.GetCDNUrl(thumbnailKey)
-			}
+		// 2. Upload responsive renditions, the thumbnail among them
+		var thumbnailKey string
+		var renditionObjects []ObjectInfo
+		if srcset, thumbnailKey, renditionObjects, err = p.uploadRenditions(ctx, path, hash, orientation); err != nil {
+			fmt.Printf("❌ Failed to upload renditions for %s: %v\n", filename, err)
+			return Photo{}, err
 		}
+		finalThumbnail = p.Storage.URL(thumbnailKey)
+		objects = append(objects, renditionObjects...)
 	} else {
 		finalPath = webPath
 		finalThumbnail = p.ThumbnailBase + filenameNoExt + ".webp"
 	}
 
-	// Extract EXIF using configured extractor
-	exifData, width, height, dateTaken, err := GetExifExtractor().Extract(path)
-
-	var photoYear, month, dateStr string
-	var timestamp int64
-
-	if err == nil && !dateTaken.IsZero() {
-		photoYear = fmt.Sprintf("%04d", dateTaken.Year())
-		month = fmt.Sprintf("%02d", dateTaken.Month())
-		dateStr = dateTaken.Format("2006-01-02")
-		timestamp = dateTaken.Unix()
-	} else {
-		// Fallback to filename
-		matches := p.DateRegex.FindStringSubmatch(filename)
-		if len(matches) >= 4 {
-			photoYear = matches[1]
-			month = matches[2]
-			dateStr = fmt.Sprintf(DateFormatYMD, matches[1], matches[2], matches[3])
-		} else {
-			photoYear = yearDirName
-			month = DefaultMonth
-			dateStr = fmt.Sprintf(DateFormatDefault, yearDirName)
-		}
-		if err != nil {
-			fmt.Printf("⚠ EXIF extraction failed for %s: %v\n", filename, err)
-		}
-	}
-
 	// Create Photo struct
 	photo := Photo{
 		Filename:  filename,
 		Path:      finalPath,
 		Thumbnail: finalThumbnail,
 		Alt:       "", // Preserve alt if exists?
-		Year:      photoYear,
-		Month:     month,
-		Date:      dateStr,
 		Width:     width,
 		Height:    height,
-		Exif:      exifData,
+		Srcset:    srcset,
 		Hash:      hash,
-		Timestamp: timestamp,
+		Status:    status,
+	}
+
+	photo.SetMetadata(NewPhotoMetadata(exifData))
+
+	if !p.setCaptureTime(&photo, path) {
+		// Fallback to filename
+		matches := p.DateRegex.FindStringSubmatch(filename)
+		if len(matches) >= 4 {
+			photo.Year = matches[1]
+			photo.Month = matches[2]
+			photo.Date = fmt.Sprintf(DateFormatYMD, matches[1], matches[2], matches[3])
+		} else {
+			photo.Year = yearDirName
+			photo.Month = DefaultMonth
+			photo.Date = fmt.Sprintf(DateFormatDefault, yearDirName)
+		}
+		if exifErr != nil {
+			fmt.Printf("⚠ EXIF extraction failed for %s: %v\n", filename, exifErr)
+		}
 	}
 
 	// Preserve Alt from existing if available
@@ -292,47 +371,84 @@ func (p *PhotoProcessor) processPhoto(path string, yearDirName string) (Photo, e
 		photo.Alt = existing.Alt
 	}
 
+	p.recordState(path, photo, objects)
 	return photo, nil
 }
 
-func UpdatePhotosHandler() {
-	processor, err := NewPhotoProcessor()
+// setCaptureTime sets the date, year and month of a photo from its capture time, resolved for the folder
+// of path; false when the metadata has no capture time.
+// Taken is only set when the UTC offset is known: an RFC 3339 time must carry one, and the camera clock read
+// as UTC would be a wrong instant. Without an offset the date and the sort order use the camera clock.
+func (p *PhotoProcessor) setCaptureTime(photo *Photo, path string) bool {
+	if photo.Metadata == nil {
+		return false
+	}
+	folder, err := filepath.Rel(p.ImgDirPath, filepath.Dir(path))
 	if err != nil {
-		fmt.Printf("Error initializing processor: %v\n", err)
-		os.Exit(1)
+		folder = ""
+	}
+	taken, source := p.TimeZones.Resolve(*photo.Metadata, filepath.ToSlash(folder))
+	if taken.IsZero() {
+		return false
 	}
-	var existingContent []byte
 
-	if existingContent, err = processor.LoadExistingMetadata(); err != nil {
-		fmt.Printf("Warning: Failed to load existing metadata: %v\n", err)
+	photo.Year = fmt.Sprintf("%04d", taken.Year())
+	photo.Month = fmt.Sprintf("%02d", taken.Month())
+	photo.Date = taken.Format("2006-01-02")
+	photo.Timestamp = taken.Unix()
+	photo.Taken, photo.OffsetSource = "", source
+	if source != "" {
+		photo.Taken = taken.Format(time.RFC3339)
 	}
+	return true
+}
+
+// Job is a single image file discovered by a scan of ImgDirPath
+type Job struct {
+	Path    string
+	YearDir string
+}
+
+// PhotoStatus describes how a scanned file relates to the existing photos.json
+type PhotoStatus string
+
+const (
+	PhotoNew       PhotoStatus = "new"
+	PhotoChanged   PhotoStatus = "changed"
+	PhotoUnchanged PhotoStatus = "unchanged"
+	PhotoSkipped   PhotoStatus = "skipped" // Not processed because the run was interrupted
+	PhotoStale     PhotoStatus = "stale"   // Failed to process, the previous entry was kept
+)
 
-	// Collect all image files
-	type Job struct {
-		Path    string
-		YearDir string
+// UpdatePhotosHandler runs the full sync pipeline and exits on failure.
+// Kept for callers that predate the command line interface.
+func UpdatePhotosHandler() {
+	if code := runSync(nil); code != ExitOK {
+		os.Exit(code)
 	}
+}
+
+// ScanJobs collects all supported image files below ImgDirPath, one level of year directories deep
+func (p *PhotoProcessor) ScanJobs() ([]Job, error) {
 	var jobs []Job
 
-	entries, err := os.ReadDir(processor.ImgDirPath)
+	entries, err := os.ReadDir(p.ImgDirPath)
 	if err != nil {
-		fmt.Printf("Error reading image directory: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("error reading image directory: %w", err)
 	}
 
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
-		yearDir := filepath.Join(processor.ImgDirPath, entry.Name())
+		yearDir := filepath.Join(p.ImgDirPath, entry.Name())
 
 		err := filepath.WalkDir(
 			yearDir, func(path string, d fs.DirEntry, err error) error {
 				if err != nil || d.IsDir() {
 					return err
 				}
-				ext := strings.ToLower(filepath.Ext(d.Name()))
-				if ext == ExtJPG || ext == ExtJPEG || ext == ExtPNG || ext == ExtWebP {
+				if isSupportedImage(d.Name()) {
 					jobs = append(jobs, Job{Path: path, YearDir: entry.Name()})
 				}
 				return nil
@@ -343,13 +459,55 @@ func UpdatePhotosHandler() {
 		}
 	}
 
-	// Worker Pool
+	return jobs, nil
+}
+
+// isSupportedImage reports whether the file extension is one the pipeline handles
+func isSupportedImage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ExtJPG || ext == ExtJPEG || ext == ExtPNG || ext == ExtWebP
+}
+
+// Classify hashes a file and compares it against the existing metadata
+func (p *PhotoProcessor) Classify(path string) (PhotoStatus, string, error) {
+	hash, err := p.fileHash(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to calculate hash: %w", err)
+	}
+
+	filename := filepath.Base(path)
+	existing, ok := p.baseline(filename)
+	switch {
+	case !ok:
+		return PhotoNew, hash, nil
+	case existing.Hash != hash || p.isStale(filename):
+		return PhotoChanged, hash, nil
+	case p.Storage != nil && (existing.Path != p.Storage.URL(p.originalKey(filename, hash)) ||
+		existing.Thumbnail != p.Storage.URL(p.thumbnailKey(filename, hash, existing.Srcset))):
+		// Published under other keys: another backend, an older key scheme or other thumbnail settings
+		return PhotoChanged, hash, nil
+	default:
+		return PhotoUnchanged, hash, nil
+	}
+}
+
+// ProcessAll runs processPhoto over all jobs using a worker pool.
+// Photos that fail keep their previous entry, marked stale so the next sync tries them again,
+// and are returned as failures; photos that were never synced are left out.
+// Once Stopping is closed or ctx is canceled no further photos are started; photos in flight
+// finish unless ctx is canceled. Photos that were not processed keep their previous entry.
+func (p *PhotoProcessor) ProcessAll(ctx context.Context, jobs []Job) ([]Photo, []PhotoFailure) {
 	jobsChan := make(chan Job, len(jobs))
 	resultsChan := make(chan Photo, len(jobs))
 	var wg sync.WaitGroup
+	var failures []PhotoFailure
+	var failedMu sync.Mutex
 
 	// Start workers
-	numWorkers := MaxConcurrency
+	numWorkers := p.Workers
+	if numWorkers <= 0 {
+		numWorkers = MaxConcurrency
+	}
 	if len(jobs) < numWorkers {
 		numWorkers = len(jobs)
 	}
@@ -361,9 +519,28 @@ func UpdatePhotosHandler() {
 		go func() {
 			defer wg.Done()
 			for job := range jobsChan {
-				photo, err := processor.processPhoto(job.Path, job.YearDir)
+				photo, err := p.processPhoto(ctx, job.Path, job.YearDir)
+				if err != nil && ctx.Err() != nil {
+					// Abandoned, not failed
+					if previous, ok := p.carryForward(job, PhotoSkipped); ok {
+						resultsChan <- previous
+					}
+					continue
+				}
 				if err != nil {
 					fmt.Printf("Error processing %s: %v\n", filepath.Base(job.Path), err)
+					failure := PhotoFailure{Filename: filepath.Base(job.Path), Path: job.Path, Error: err.Error()}
+					if rel, err := filepath.Rel(p.RootDir, job.Path); err == nil {
+						failure.Path = filepath.ToSlash(rel)
+					}
+					if previous, ok := p.carryForward(job, PhotoStale); ok {
+						p.markStale(job.Path, previous)
+						failure.Kept = true
+						resultsChan <- previous
+					}
+					failedMu.Lock()
+					failures = append(failures, failure)
+					failedMu.Unlock()
 					continue
 				}
 				resultsChan <- photo
@@ -371,8 +548,18 @@ func UpdatePhotosHandler() {
 		}()
 	}
 
-	// Send jobs
-	for _, job := range jobs {
+	// Send jobs until interrupted
+	var skipped []Photo
+	for i, job := range jobs {
+		if p.Interrupted(ctx) {
+			for _, rest := range jobs[i:] {
+				if previous, ok := p.carryForward(rest, PhotoSkipped); ok {
+					skipped = append(skipped, previous)
+				}
+			}
+			fmt.Printf("⚠ Interrupted, %d photos were not started\n", len(jobs)-i)
+			break
+		}
 		jobsChan <- job
 	}
 	close(jobsChan)
@@ -384,12 +571,66 @@ func UpdatePhotosHandler() {
 	close(resultsChan)
 
 	// Collect results
-	var allPhotos []Photo
+	allPhotos := skipped
 	for photo := range resultsChan {
 		allPhotos = append(allPhotos, photo)
 	}
 
-	// Organize into albums
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Filename < failures[j].Filename })
+	return allPhotos, failures
+}
+
+// Interrupted reports whether new photos should no longer be started
+func (p *PhotoProcessor) Interrupted(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	select {
+	case <-p.Stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// carryForward returns the previous entry of a photo that was not processed, so it is kept in photos.json
+func (p *PhotoProcessor) carryForward(job Job, status PhotoStatus) (Photo, bool) {
+	previous, ok := p.baseline(filepath.Base(job.Path))
+	if !ok {
+		return Photo{}, false
+	}
+	if published, ok := p.ExistingPhotos[previous.Filename]; ok {
+		previous.Alt = published.Alt
+	}
+	previous.Status = status
+	return previous, true
+}
+
+// PhotoFailure is a photo that could not be processed
+type PhotoFailure struct {
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+	Error    string `json:"error"`
+	Kept     bool   `json:"kept"` // The previous entry was kept in photos.json
+}
+
+// PrintFailureReport lists the photos that failed; nothing when all succeeded
+func PrintFailureReport(failures []PhotoFailure) {
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Printf("❌ %d photos failed to process:\n", len(failures))
+	for _, failure := range failures {
+		outcome := "not published"
+		if failure.Kept {
+			outcome = "previous version kept, retried next sync"
+		}
+		fmt.Printf("  %s (%s): %s\n", failure.Filename, outcome, failure.Error)
+	}
+}
+
+// BuildAlbums groups photos by year, sorted newest first
+func BuildAlbums(allPhotos []Photo) []YearAlbum {
 	albumsMap := make(map[string][]Photo)
 	for _, p := range allPhotos {
 		albumsMap[p.Year] = append(albumsMap[p.Year], p)
@@ -419,91 +660,210 @@ func UpdatePhotosHandler() {
 		},
 	)
 
-	// Identify deleted photos
-	if processor.R2Client != nil {
-		newPhotosMap := make(map[string]bool)
-		for _, p := range allPhotos {
-			newPhotosMap[p.Filename] = true
+	return newAlbums
+}
+
+// originalKey returns the storage key of an original image, e.g. photos/originals/ab12cd34/DSC_x.jpg.
+// The key contains the content hash, so replaced photos get new URLs and objects can be cached forever.
+// The same key layout is used by every storage backend.
+func (p *PhotoProcessor) originalKey(filename, hash string) string {
+	return fmt.Sprintf("%s%s%s/%s", p.Config.R2.BasePrefix, p.Config.R2.OriginalPrefix, shortHash(hash), filename)
+}
+
+// thumbnailKey returns the storage key of the thumbnail among the renditions in srcset: the widest WebP
+// no wider than thumbnail.max_width, as generated at ThumbnailWidth. It is empty when there is none.
+func (p *PhotoProcessor) thumbnailKey(filename, hash string, srcset []PhotoSource) string {
+	width := 0
+	for _, source := range srcset {
+		if sourceFormat(source) == FormatWebP && source.Width <= p.Config.Thumbnail.MaxWidth && source.Width > width {
+			width = source.Width
 		}
+	}
+	if width == 0 {
+		return ""
+	}
+	return p.renditionKey(filename, hash, width, FormatWebP)
+}
+
+// legacyThumbnailKey returns the storage key of the separate WebP thumbnail that syncs uploaded
+// before the thumbnail was one of the renditions
+func (p *PhotoProcessor) legacyThumbnailKey(filename, hash string) string {
+	return fmt.Sprintf(
+		"%s%s%s/%s%s", p.Config.R2.BasePrefix, p.Config.R2.ThumbnailPrefix, p.renditionVersion(hash),
+		strings.TrimSuffix(filename, filepath.Ext(filename)), ExtWebP,
+	)
+}
+
+// renditionKey returns the storage key of the rendition of an image at the given width and format
+func (p *PhotoProcessor) renditionKey(filename, hash string, width int, format string) string {
+	return fmt.Sprintf(
+		"%s%s%s/%s-%dw%s", p.Config.R2.BasePrefix, p.Config.R2.ThumbnailPrefix, p.renditionVersion(hash),
+		strings.TrimSuffix(filename, filepath.Ext(filename)), width, FormatExt(format),
+	)
+}
+
+// renditionVersion returns the key segment shared by the thumbnail and the renditions of a photo.
+// It covers the content hash and every setting that changes the generated images.
+func (p *PhotoProcessor) renditionVersion(hash string) string {
+	t := p.Config.Thumbnail
+	sum := md5.Sum(
+		[]byte(fmt.Sprintf("%s|%d|%d|%d|%v|%v", hash, renditionScheme, t.MaxWidth, t.Quality, t.Widths, t.Formats)),
+	)
+	return shortHash(hex.EncodeToString(sum[:]))
+}
+
+// shortHash truncates a hex hash to the length used in object keys
+func shortHash(hash string) string {
+	if len(hash) > keyHashLength {
+		return hash[:keyHashLength]
+	}
+	return hash
+}
+
+// photoKeys returns every storage key published for a photo
+func (p *PhotoProcessor) photoKeys(photo Photo) []string {
+	keys := []string{p.originalKey(photo.Filename, photo.Hash)}
+	for _, source := range photo.Srcset {
+		keys = append(keys, p.renditionKey(photo.Filename, photo.Hash, source.Width, sourceFormat(source)))
+	}
+	// Entries kept from an older sync may still point at a separate thumbnail
+	if legacy := p.legacyThumbnailKey(photo.Filename, photo.Hash); p.Storage != nil &&
+		photo.Thumbnail == p.Storage.URL(legacy) {
+		keys = append(keys, legacy)
+	}
+	return keys
+}
 
-		var keysToDelete []string
-		for filename := range processor.ExistingPhotos {
-			if !newPhotosMap[filename] {
-				fmt.Printf("Marking for deletion: %s\n", filename)
-				// Add original and thumbnail to delete list
-				keysToDelete = append(
-					keysToDelete,
-					fmt.Sprintf(
-						"%s%s%s", processor.R2Client.config.BasePrefix, processor.R2Client.config.OriginalPrefix,
-						filename,
-					),
-					fmt.Sprintf(
-						"%s%s%s%s", processor.R2Client.config.BasePrefix, processor.R2Client.config.ThumbnailPrefix,
-						strings.TrimSuffix(filename, filepath.Ext(filename)), ExtWebP,
-					),
-				)
+// uploadRenditions generates the responsive renditions of an image and uploads them, except in a dry run.
+// It returns the srcset entries, the key of the thumbnail rendition and the stored objects.
+func (p *PhotoProcessor) uploadRenditions(
+	ctx context.Context, path, hash string, orientation int,
+) ([]PhotoSource, string, []ObjectInfo, error) {
+	filename := filepath.Base(path)
+	renditions, err := GenerateRenditions(ctx, path, p.Config.Thumbnail, orientation)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to generate renditions for %s: %w", filename, err)
+	}
+
+	srcset := make([]PhotoSource, 0, len(renditions))
+	var thumbnailKey string
+	var objects []ObjectInfo
+	for _, r := range renditions {
+		key := p.renditionKey(filename, hash, r.Width, r.Format)
+		if r.Thumbnail {
+			thumbnailKey = key
+		}
+		if !p.DryRun {
+			info, err := putBytes(
+				ctx, p.Storage, key, r.Data,
+				PutOptions{ContentType: FormatContentType(r.Format), CacheControl: ImmutableCacheControl},
+			)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("failed to upload %dw %s rendition of %s: %w", r.Width, r.Format, filename, err)
 			}
+			objects = append(objects, info)
 		}
+		srcset = append(
+			srcset,
+			PhotoSource{URL: p.Storage.URL(key), Width: r.Width, Height: r.Height, Bytes: len(r.Data), Format: r.Format},
+		)
+	}
+	return srcset, thumbnailKey, objects, nil
+}
 
-		if len(keysToDelete) > 0 {
-			fmt.Printf("🟢 Deleting %d orphaned files from R2...\n", len(keysToDelete))
-			if err := processor.R2Client.DeleteObjects(keysToDelete); err != nil {
-				fmt.Printf("Error deleting objects: %v\n", err)
-			} else {
-				fmt.Println("✓ Successfully deleted orphaned files.")
-			}
+// photosJSONKey returns the storage key of the published photos.json
+func (p *PhotoProcessor) photosJSONKey() string {
+	return fmt.Sprintf("%sphotos.json", p.Config.R2.BasePrefix)
+}
+
+// OrphanKeys lists the original and thumbnail prefixes of the storage and returns every key that
+// no photo in allPhotos references: removed photos, replaced content and keys of older schemes or settings.
+// It also returns the number of stored objects that were checked.
+func (p *PhotoProcessor) OrphanKeys(ctx context.Context, allPhotos []Photo) ([]string, int, error) {
+	if p.Storage == nil {
+		return nil, 0, nil
+	}
+
+	referenced := map[string]bool{p.photosJSONKey(): true}
+	for _, photo := range allPhotos {
+		for _, key := range p.photoKeys(photo) {
+			referenced[key] = true
 		}
 	}
 
-	// Write output
-	jsonData, err := json.Marshal(newAlbums)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		os.Exit(1)
+	var keysToDelete []string
+	stored := 0
+	for _, prefix := range p.sweepPrefixes() {
+		objects, err := p.Storage.List(ctx, prefix)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		stored += len(objects)
+		for _, obj := range objects {
+			if !referenced[obj.Key] {
+				fmt.Printf("Marking for deletion: %s\n", obj.Key)
+				keysToDelete = append(keysToDelete, obj.Key)
+				referenced[obj.Key] = true // Listed once even when prefixes overlap
+			}
+		}
 	}
+	sort.Strings(keysToDelete)
 
-	outputFilePath := filepath.Join(processor.RootDir, OutputFile)
+	return keysToDelete, stored, nil
+}
 
-	// Check if content changed (ignoring order if possible, but simple byte check is fast)
-	// Since we re-generated everything, byte comparison might fail if order changed slightly or timestamps
-	// But we should write anyway if we processed updates.
+// sweepPrefixes returns the prefixes that only hold originals and thumbnails.
+// An empty original or thumbnail prefix would cover the whole base prefix, so it is never swept.
+func (p *PhotoProcessor) sweepPrefixes() []string {
+	var prefixes []string
+	for _, prefix := range []string{p.Config.R2.OriginalPrefix, p.Config.R2.ThumbnailPrefix} {
+		if prefix != "" {
+			prefixes = append(prefixes, p.Config.R2.BasePrefix+prefix)
+		}
+	}
+	return prefixes
+}
 
-	err = os.WriteFile(outputFilePath, jsonData, 0644)
-	if err != nil {
-		fmt.Printf("Error writing output file: %v\n", err)
-		os.Exit(1)
+// DeleteOrphans removes the given keys, found among stored objects, from storage.
+// It refuses with ErrDeletionLimit when the prune limits are exceeded, unless Force is set, and with
+// ErrDeletionDeclined when Confirm says no. In trash mode the objects are moved to a trash batch.
+func (p *PhotoProcessor) DeleteOrphans(ctx context.Context, keysToDelete []string, stored int) error {
+	if p.Storage == nil || len(keysToDelete) == 0 {
+		return nil
 	}
 
-	// Create backup of existing file if it exists
-	if len(existingContent) > 0 {
-		backupPath := outputFilePath + "." + time.Now().Format("20060102_150405") + ".bak"
-		if err := os.WriteFile(backupPath, existingContent, 0644); err != nil {
-			fmt.Printf("Warning: Could not create backup of %s: %v\n", outputFilePath, err)
-		} else {
-			fmt.Printf("✓ Backup created: %s\n", backupPath)
+	if err := p.Config.Prune.CheckDeletion(len(keysToDelete), stored); err != nil {
+		if !p.Force {
+			return err
 		}
+		fmt.Printf("⚠ %v, continuing because of -force\n", err)
 	}
 
-	// Check if content has changed
-	if JSONEqual(existingContent, jsonData) {
-		fmt.Println("✓ photos.json has not changed. Skipping backup, file write, and R2 upload.")
-		return
+	trash := p.Config.Prune.Mode == PruneTrash
+	batch := time.Now().UTC().Format(trashBatchFormat)
+	prompt := fmt.Sprintf("Delete %d orphaned files from %s permanently?", len(keysToDelete), p.Config.Storage.Backend)
+	if trash {
+		prompt = fmt.Sprintf("Move %d orphaned files to %s%s/?", len(keysToDelete), p.trashPrefix(), batch)
+	}
+	if p.Confirm != nil && !p.Confirm(ctx, prompt) {
+		return ErrDeletionDeclined
 	}
 
-	// Upload photos.json to R2
-	if processor.R2Client != nil {
-		jsonKey := fmt.Sprintf("%sphotos.json", processor.R2Client.config.BasePrefix)
-		if err := processor.R2Client.UploadBytes(
-			// jsonData, jsonKey, "application/json", "public, max-age=720, must-revalidate",
-			jsonData, jsonKey, "application/json", "public, max-age=720",
-		); err != nil {
-			fmt.Printf("❌ Failed to upload photos.json: %v\n", err)
-		} else {
-			fmt.Printf("✓ Uploaded photos.json to R2\n")
+	if trash {
+		fmt.Printf("🟢 Moving %d orphaned files to the trash...\n", len(keysToDelete))
+		if err := p.moveToTrash(ctx, batch, keysToDelete); err != nil {
+			return err
 		}
+		fmt.Printf("✓ Moved orphaned files to %s%s/, restore with: restore %s\n", p.trashPrefix(), batch, batch)
+		return nil
 	}
 
-	fmt.Printf("Successfully updated photos.json with %d photos.\n", len(allPhotos))
+	fmt.Printf("🟢 Deleting %d orphaned files from %s...\n", len(keysToDelete), p.Config.Storage.Backend)
+	if err := p.Storage.DeleteMany(ctx, keysToDelete); err != nil {
+		return fmt.Errorf("error deleting objects: %w", err)
+	}
+	fmt.Println("✓ Successfully deleted orphaned files.")
+	return nil
 }
 
 // JSONEqual compares two JSON byte slices for equality, ignoring whitespace and key order
@@ -517,23 +877,14 @@ func JSONEqual(a, b []byte) bool {
 	}
 
 	// Re-marshal to ensure consistent formatting (e.g. sorted keys, no whitespace)
-	// Use MarshalIndent for readability in diffs
-	m1, err := json.MarshalIndent(j1, "", "  ")
+	m1, err := json.Marshal(j1)
 	if err != nil {
 		return false
 	}
-	m2, err := json.MarshalIndent(j2, "", "  ")
+	m2, err := json.Marshal(j2)
 	if err != nil {
 		return false
 	}
 
-	if !bytes.Equal(m1, m2) {
-		fmt.Println("⚠️ JSON content differs. Writing to files for comparison...")
-		_ = os.WriteFile("photos_old.json", m1, 0644)
-		_ = os.WriteFile("photos_new.json", m2, 0644)
-		fmt.Println("👉 Please compare 'photos_old.json' and 'photos_new.json' to see the differences.")
-		return false
-	}
-
-	return true
+	return bytes.Equal(m1, m2)
 }
@@ -0,0 +1,345 @@
+package scripts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestObjectKeys tests that object keys change with the content and the thumbnail settings
+func TestObjectKeys(t *testing.T) {
+	p := &PhotoProcessor{Config: DefaultConfig()}
+	hash := "ab12cd34ef567890ab12cd34ef567890"
+
+	assert.Equal(t, "photos/originals/ab12cd34/DSC_x.jpg", p.originalKey("DSC_x.jpg", hash))
+	version := p.renditionVersion(hash)
+	assert.Len(t, version, keyHashLength)
+	srcset := []PhotoSource{
+		{Width: 400, Format: FormatWebP}, {Width: 799, Format: FormatWebP}, {Width: 799, Format: FormatJPEG},
+		{Width: 1600, Format: FormatWebP},
+	}
+	assert.Equal(t, "photos/thumbnails/"+version+"/DSC_x-799w.webp", p.thumbnailKey("DSC_x.jpg", hash, srcset))
+	assert.Empty(t, p.thumbnailKey("DSC_x.jpg", hash, srcset[2:3]), "no WebP rendition")
+	assert.Equal(t, "photos/thumbnails/"+version+"/DSC_x-800w.jpg", p.renditionKey("DSC_x.jpg", hash, 800, FormatJPEG))
+
+	assert.NotEqual(t, version, p.renditionVersion("ffff"+hash[4:]), "content must change the version")
+	p.Config.Thumbnail.Widths = []int{400}
+	assert.NotEqual(t, version, p.renditionVersion(hash), "settings must change the version")
+}
+
+// TestOrphanKeys tests that the sweep keeps referenced objects and everything outside the swept prefixes
+func TestOrphanKeys(t *testing.T) {
+	storage := NewMemoryStorage("")
+	p := &PhotoProcessor{Config: DefaultConfig(), Storage: storage}
+
+	kept := Photo{
+		Filename: "DSC_a.jpg",
+		Hash:     "aaaaaaaa11111111",
+		Srcset:   []PhotoSource{{Width: 400, Format: FormatWebP}, {Width: 400, Format: FormatJPEG}},
+	}
+	replaced := kept
+	replaced.Hash = "bbbbbbbb22222222"
+	removed := Photo{Filename: "DSC_b.jpg", Hash: "cccccccc33333333"}
+
+	for _, photo := range []Photo{kept, replaced, removed} {
+		for _, key := range p.photoKeys(photo) {
+			_, err := putBytes(t.Context(), storage, key, []byte(key), PutOptions{})
+			assert.NoError(t, err)
+		}
+	}
+	for _, key := range []string{
+		"photos/photos.json", "photos/originals/DSC_a.jpg", "photos/thumbnails/DSC_a.webp", "other/file.txt",
+	} {
+		_, err := putBytes(t.Context(), storage, key, []byte(key), PutOptions{})
+		assert.NoError(t, err)
+	}
+
+	keys, stored, err := p.OrphanKeys(t.Context(), []Photo{kept})
+	assert.NoError(t, err)
+	assert.Equal(t, len(keys)+len(p.photoKeys(kept)), stored)
+
+	expected := append(p.photoKeys(replaced), p.photoKeys(removed)...)
+	expected = append(expected, "photos/originals/DSC_a.jpg", "photos/thumbnails/DSC_a.webp")
+	assert.ElementsMatch(t, expected, keys)
+	assert.IsIncreasing(t, keys)
+	for _, key := range p.photoKeys(kept) {
+		assert.NotContains(t, keys, key)
+	}
+
+	p.Storage = nil
+	keys, _, err = p.OrphanKeys(t.Context(), []Photo{kept})
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// TestProcessAllInterrupted tests that an interrupted run starts no photos and keeps the previous entries
+func TestProcessAllInterrupted(t *testing.T) {
+	rootDir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.RootDir = rootDir
+	cfg.ExifExtractor = string(ExifExtractorGoExif)
+	cfg.Storage.Backend = StorageMemory
+	cfg.Thumbnail.Widths = []int{400}
+	cfg.Thumbnail.Formats = []string{FormatWebP}
+	writeTestJPEG(t, filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-02_a.jpg"), 600, 400)
+
+	processor, err := NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	storage := processor.Storage
+	jobs, err := processor.ScanJobs()
+	assert.NoError(t, err)
+	synced, failed := processor.ProcessAll(t.Context(), jobs)
+	assert.Empty(t, failed)
+	assert.NoError(t, processor.SaveState(jobs))
+
+	writeTestJPEG(t, filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-03_b.jpg"), 600, 400)
+	stopping := make(chan struct{})
+	close(stopping)
+	processor, err = NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	processor.Storage = storage
+	processor.Stopping = stopping
+	jobs, err = processor.ScanJobs()
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 2)
+
+	allPhotos, failed := processor.ProcessAll(t.Context(), jobs)
+	assert.True(t, processor.Interrupted(t.Context()))
+	assert.Empty(t, failed)
+	assert.Len(t, allPhotos, 1, "the new photo was not started")
+	assert.Equal(t, PhotoSkipped, allPhotos[0].Status)
+	assert.Equal(t, synced[0].Path, allPhotos[0].Path)
+	_, ok := processor.State.Get("DSC_2025-01-03_b.jpg")
+	assert.False(t, ok)
+
+	// A canceled context abandons the work in the same way
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	processor.Stopping = nil
+	allPhotos, failed = processor.ProcessAll(ctx, jobs)
+	assert.Empty(t, failed)
+	assert.Len(t, allPhotos, 1)
+}
+
+// TestProcessAllFailures tests that failed photos keep their previous entry and objects and are retried
+func TestProcessAllFailures(t *testing.T) {
+	rootDir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.RootDir = rootDir
+	cfg.ExifExtractor = string(ExifExtractorGoExif)
+	cfg.Storage.Backend = StorageMemory
+	cfg.Thumbnail.Widths = []int{400}
+	cfg.Thumbnail.Formats = []string{FormatWebP}
+	pathA := filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-02_a.jpg")
+	writeTestJPEG(t, pathA, 600, 400)
+
+	processor, err := NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	jobs, err := processor.ScanJobs()
+	assert.NoError(t, err)
+	synced, failures := processor.ProcessAll(t.Context(), jobs)
+	assert.Empty(t, failures)
+
+	// Both photos are now unreadable
+	assert.NoError(t, os.WriteFile(pathA, []byte("not a jpeg"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-03_b.jpg"), []byte("x"), 0644))
+	jobs, err = processor.ScanJobs()
+	assert.NoError(t, err)
+	allPhotos, failures := processor.ProcessAll(t.Context(), jobs)
+
+	assert.Len(t, failures, 2)
+	assert.Equal(t, "DSC_2025-01-02_a.jpg", failures[0].Filename)
+	assert.True(t, failures[0].Kept)
+	assert.Equal(t, "web/photography/gallery_images/2025/DSC_2025-01-02_a.jpg", failures[0].Path)
+	assert.False(t, failures[1].Kept, "a photo that was never synced has nothing to keep")
+
+	assert.Len(t, allPhotos, 1)
+	assert.Equal(t, PhotoStale, allPhotos[0].Status)
+	assert.Equal(t, synced[0].Path, allPhotos[0].Path)
+	record, ok := processor.State.Get("DSC_2025-01-02_a.jpg")
+	assert.True(t, ok)
+	assert.True(t, record.Stale)
+
+	// The objects of the kept entry are not orphaned
+	keys, _, err := processor.OrphanKeys(t.Context(), allPhotos)
+	assert.NoError(t, err)
+	for _, key := range processor.photoKeys(synced[0]) {
+		assert.NotContains(t, keys, key)
+	}
+}
+
+// TestProcessPhotoCaptureTime tests the dates of photos with and without a UTC offset, and that unchanged
+// photos follow a change of the folder zones
+func TestProcessPhotoCaptureTime(t *testing.T) {
+	rootDir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.RootDir = rootDir
+	cfg.ExifExtractor = string(ExifExtractorGoExif)
+	cfg.Storage.Backend = StorageMemory
+	for name, folder := range map[string]string{"gps_time.jpg": "2024/tokyo", "nikon_z6.jpg": "2025/auckland"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "exif", name))
+		assert.NoError(t, err)
+		path := filepath.Join(rootDir, cfg.ImgDir, folder, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+	}
+	syncPhotos := func() map[string]Photo {
+		processor, err := NewPhotoProcessor(cfg)
+		assert.NoError(t, err)
+		jobs, err := processor.ScanJobs()
+		assert.NoError(t, err)
+		synced, failures := processor.ProcessAll(t.Context(), jobs)
+		assert.Empty(t, failures)
+		assert.NoError(t, processor.SaveState(jobs))
+		photos := make(map[string]Photo)
+		for _, photo := range synced {
+			photos[photo.Filename] = photo
+		}
+		return photos
+	}
+
+	photos := syncPhotos()
+	tokyo := photos["gps_time.jpg"]
+	assert.Equal(t, "2024-12-31T22:15:10+09:00", tokyo.Taken)
+	assert.Equal(t, OffsetFromGPS, tokyo.OffsetSource)
+	assert.Equal(t, int64(1735650910), tokyo.Timestamp)
+	nikon := photos["nikon_z6.jpg"]
+	assert.Equal(t, "2025-11-09T22:05:34+08:00", nikon.Taken)
+	assert.Equal(t, OffsetFromExif, nikon.OffsetSource)
+	assert.Equal(t, "2025-11-09", nikon.Date)
+
+	// The camera was still on the time at home, the photo was taken the next morning in Auckland
+	cfg.Timezone.Folders = map[string]string{"2025/auckland": "Pacific/Auckland"}
+	nikon = syncPhotos()["nikon_z6.jpg"]
+	assert.Equal(t, PhotoUnchanged, nikon.Status)
+	assert.Equal(t, "2025-11-10T03:05:34+13:00", nikon.Taken)
+	assert.Equal(t, "2025-11-10", nikon.Date)
+	assert.Equal(t, "11", nikon.Month)
+	assert.Equal(t, OffsetFromExif, nikon.OffsetSource)
+}
+
+// TestProcessPhotoThumbnail tests that the thumbnail is the uploaded WebP rendition at thumbnail.max_width, and that
+// entries with a thumbnail of an older sync or without a thumbnail rendition are synced again
+func TestProcessPhotoThumbnail(t *testing.T) {
+	tests := []struct {
+		name                       string
+		width, height, orientation int
+		want                       string
+	}{
+		{"Landscape", 1000, 500, 1, "-800w.webp"},
+		{"Narrower than max_width", 600, 400, 1, "-600w.webp"},
+		{"Rotated with an uneven aspect ratio", 1511, 1007, 6, "-800w.webp"},
+		{"Rotated and mirrored", 1511, 1007, 7, "-800w.webp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			cfg := DefaultConfig()
+			cfg.RootDir = rootDir
+			cfg.ExifExtractor = string(ExifExtractorGoExif)
+			cfg.Storage.Backend = StorageMemory
+			cfg.Thumbnail.Widths = []int{400}
+			cfg.Thumbnail.Formats = []string{FormatJPEG}
+			writeOrientedJPEG(
+				t, filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-02_a.jpg"), tt.width, tt.height, tt.orientation,
+			)
+
+			processor, err := NewPhotoProcessor(cfg)
+			assert.NoError(t, err)
+			jobs, err := processor.ScanJobs()
+			assert.NoError(t, err)
+			synced, failures := processor.ProcessAll(t.Context(), jobs)
+			assert.Empty(t, failures)
+			if !assert.Len(t, synced, 1) {
+				return
+			}
+			photo := synced[0]
+			displayWidth, _ := OrientedSize(tt.width, tt.height, tt.orientation)
+			assert.Equal(t, displayWidth, photo.Width)
+
+			assert.True(t, strings.HasSuffix(photo.Thumbnail, "/DSC_2025-01-02_a"+tt.want), photo.Thumbnail)
+			var urls []string
+			for _, source := range photo.Srcset {
+				urls = append(urls, source.URL)
+			}
+			assert.Contains(t, urls, photo.Thumbnail)
+			key := processor.thumbnailKey(photo.Filename, photo.Hash, photo.Srcset)
+			assert.Equal(t, processor.Storage.URL(key), photo.Thumbnail)
+			_, err = processor.Storage.Head(t.Context(), key)
+			assert.NoError(t, err, "the thumbnail is uploaded")
+			objects, err := processor.Storage.List(t.Context(), cfg.R2.BasePrefix+cfg.R2.ThumbnailPrefix)
+			assert.NoError(t, err)
+			assert.Len(t, objects, len(photo.Srcset), "no object besides the renditions")
+
+			processor.State = nil
+			classify := func(existing Photo) PhotoStatus {
+				processor.ExistingPhotos = map[string]Photo{photo.Filename: existing}
+				status, _, err := processor.Classify(jobs[0].Path)
+				assert.NoError(t, err)
+				return status
+			}
+			assert.Equal(t, PhotoUnchanged, classify(photo))
+
+			// An entry of an older sync points at a separate thumbnail, which stays referenced until it is synced again
+			legacy := photo
+			legacy.Thumbnail = processor.Storage.URL(processor.legacyThumbnailKey(photo.Filename, photo.Hash))
+			assert.Contains(t, processor.photoKeys(legacy), processor.legacyThumbnailKey(photo.Filename, photo.Hash))
+			assert.NotContains(t, processor.photoKeys(photo), processor.legacyThumbnailKey(photo.Filename, photo.Hash))
+			assert.Equal(t, PhotoChanged, classify(legacy))
+
+			// A thumbnail that is not one of the renditions was never uploaded
+			broken := photo
+			broken.Thumbnail = processor.Storage.URL(processor.renditionKey(photo.Filename, photo.Hash, 801, FormatWebP))
+			assert.Equal(t, PhotoChanged, classify(broken))
+		})
+	}
+}
+
+// failingStorage fails the uploads of keys matching fail
+type failingStorage struct {
+	Storage
+	fail func(key string) bool
+}
+
+func (f *failingStorage) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	if f.fail(key) {
+		return ObjectInfo{}, errors.New("upload failed")
+	}
+	return f.Storage.Put(ctx, key, body, opts)
+}
+
+// TestProcessPhotoThumbnailUploadFails tests that a failed thumbnail upload fails the photo, so it is retried
+func TestProcessPhotoThumbnailUploadFails(t *testing.T) {
+	rootDir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.RootDir = rootDir
+	cfg.ExifExtractor = string(ExifExtractorGoExif)
+	cfg.Storage.Backend = StorageMemory
+	cfg.Thumbnail.Widths = []int{400}
+	cfg.Thumbnail.Formats = []string{FormatWebP}
+	writeTestJPEG(t, filepath.Join(rootDir, cfg.ImgDir, "2025", "DSC_2025-01-02_a.jpg"), 600, 400)
+
+	processor, err := NewPhotoProcessor(cfg)
+	assert.NoError(t, err)
+	// Only the thumbnail, the WebP at the image width below thumbnail.max_width, fails
+	processor.Storage = &failingStorage{Storage: processor.Storage, fail: func(key string) bool {
+		return strings.HasSuffix(key, "/DSC_2025-01-02_a-600w.webp")
+	}}
+	jobs, err := processor.ScanJobs()
+	assert.NoError(t, err)
+	allPhotos, failures := processor.ProcessAll(t.Context(), jobs)
+
+	assert.Empty(t, allPhotos)
+	if assert.Len(t, failures, 1) {
+		assert.Contains(t, failures[0].Error, "600w webp rendition")
+	}
+	_, ok := processor.State.Get("DSC_2025-01-02_a.jpg")
+	assert.False(t, ok, "the photo is not recorded as synced")
+}
@@ -0,0 +1,332 @@
+package scripts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMP namespaces of the properties read from embedded XMP and sidecars
+const (
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+	nsXMP = "http://ns.adobe.com/xap/1.0/"
+	nsLR  = "http://ns.adobe.com/lightroom/1.0/"
+)
+
+// xmpProperties maps the XMP properties Lightroom and darktable write to the field names of exiftool
+var xmpProperties = map[xml.Name]string{
+	{Space: nsDC, Local: "title"}:               "Title",
+	{Space: nsDC, Local: "description"}:         "Description",
+	{Space: nsDC, Local: "subject"}:             "Subject",
+	{Space: nsXMP, Local: "Rating"}:             "Rating",
+	{Space: nsXMP, Local: "Label"}:              "Label",
+	{Space: nsLR, Local: "hierarchicalSubject"}: "HierarchicalSubject",
+}
+
+// xmpSupersedes lists the EXIF and IPTC fields an XMP field replaces, besides the field of the same name
+var xmpSupersedes = map[string][]string{
+	"Subject": {"Keywords"},
+}
+
+// jpegXMPHeader starts the APP1 segment that holds the XMP packet of a JPEG
+var jpegXMPHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// ExtractMetadata extracts the metadata of a photo: EXIF with the configured extractor, then XMP on top.
+// Per field a sidecar wins over embedded XMP, which wins over IPTC and EXIF.
+func ExtractMetadata(ctx context.Context, filePath string) (map[string]interface{}, int, int, time.Time, error) {
+	exifData, width, height, dateTaken, err := GetExifExtractor().Extract(ctx, filePath)
+	if err != nil {
+		return exifData, width, height, dateTaken, err
+	}
+
+	xmpData, err := ReadXMP(filePath)
+	if err != nil {
+		fmt.Printf("⚠ Ignoring the XMP of %s: %v\n", filePath, err)
+		return exifData, width, height, dateTaken, nil
+	}
+	return mergeXMP(exifData, xmpData), width, height, dateTaken, nil
+}
+
+// mergeXMP sets the XMP fields on exifData, replacing the fields they supersede
+func mergeXMP(exifData, xmpData map[string]interface{}) map[string]interface{} {
+	if len(xmpData) == 0 {
+		return exifData
+	}
+	if exifData == nil {
+		exifData = make(map[string]interface{})
+	}
+	for field, value := range xmpData {
+		for _, superseded := range xmpSupersedes[field] {
+			delete(exifData, superseded)
+		}
+		exifData[field] = value
+	}
+	return exifData
+}
+
+// XMPSidecar returns the sidecar of a photo, photo.jpg.xmp as darktable writes it or photo.xmp as Lightroom
+// does, and "" when there is none
+func XMPSidecar(filePath string) string {
+	stem := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	for _, candidate := range []string{filePath + ".xmp", filePath + ".XMP", stem + ".xmp", stem + ".XMP"} {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// ReadXMP reads the XMP fields of a photo, embedded and from its sidecar, the sidecar winning per field
+func ReadXMP(filePath string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	packet, err := embeddedXMP(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if packet != nil {
+		embedded, err := parseXMP(packet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid embedded XMP: %w", err)
+		}
+		for field, value := range embedded {
+			fields[field] = value
+		}
+	}
+
+	if sidecar := XMPSidecar(filePath); sidecar != "" {
+		content, err := os.ReadFile(sidecar)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sidecar: %w", err)
+		}
+		fromSidecar, err := parseXMP(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sidecar %s: %w", sidecar, err)
+		}
+		for field, value := range fromSidecar {
+			fields[field] = value
+		}
+	}
+	return fields, nil
+}
+
+// embeddedXMP returns the XMP packet embedded in an image, nil when there is none. JPEG files are read
+// segment by segment up to the image data, other formats are searched whole.
+func embeddedXMP(filePath string) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			fmt.Println("Failed to close file.")
+		}
+	}(f)
+
+	r := bufio.NewReader(f)
+	if soi, err := r.Peek(2); err == nil && soi[0] == 0xFF && soi[1] == 0xD8 {
+		segments, err := readJPEGSegments(r, 0xE1)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range segments {
+			if packet, ok := bytes.CutPrefix(segment.Data, jpegXMPHeader); ok {
+				return packet, nil
+			}
+		}
+		return nil, nil
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.Index(content, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil, nil
+	}
+	end := bytes.Index(content[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		return nil, nil
+	}
+	return content[start : start+end+len("</x:xmpmeta>")], nil
+}
+
+// jpegSegment is one marker segment of a JPEG file
+type jpegSegment struct {
+	Marker byte
+	Data   []byte // Without the marker and the length
+}
+
+// readJPEGSegments returns the segments with the given markers, reading up to the start of the image data
+func readJPEGSegments(r io.Reader, markers ...byte) ([]jpegSegment, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	var segments []jpegSegment
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("truncated JPEG: %w", err)
+		}
+		if b != 0xFF {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker, err := br.ReadByte()
+		for err == nil && marker == 0xFF { // Fill bytes
+			marker, err = br.ReadByte()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("truncated JPEG: %w", err)
+		}
+		switch {
+		case marker == 0xD9 || marker == 0xDA: // End of image, start of scan
+			return segments, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // No length
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("truncated JPEG: %w", err)
+		}
+		if length < 2 {
+			return nil, errors.New("invalid JPEG segment length")
+		}
+		wanted := false
+		for _, m := range markers {
+			wanted = wanted || m == marker
+		}
+		if !wanted {
+			if _, err := br.Discard(int(length) - 2); err != nil {
+				return nil, fmt.Errorf("truncated JPEG: %w", err)
+			}
+			continue
+		}
+		data := make([]byte, int(length)-2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("truncated JPEG: %w", err)
+		}
+		segments = append(segments, jpegSegment{Marker: marker, Data: data})
+	}
+}
+
+// parseXMP reads the fields of xmpProperties from an XMP packet. Properties may be attributes of
+// rdf:Description or elements holding text, an rdf:Alt (the x-default entry is used) or an rdf:Bag/rdf:Seq.
+// Lists with one entry become a string and longer lists a []string, as in exiftool -json.
+func parseXMP(data []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	set := func(field string, values []string) {
+		var kept []string
+		for _, v := range values {
+			if v = strings.TrimSpace(v); v != "" {
+				kept = append(kept, v)
+			}
+		}
+		switch {
+		case len(kept) == 0:
+			return
+		case field == "Rating":
+			if rating, err := strconv.Atoi(kept[0]); err == nil {
+				fields[field] = rating
+			} else if rating, err := strconv.ParseFloat(kept[0], 64); err == nil {
+				fields[field] = rating
+			}
+		case len(kept) == 1:
+			fields[field] = kept[0]
+		default:
+			fields[field] = kept
+		}
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var (
+		field    string   // Property being read, "" outside of one
+		depth    int      // Element depth within the property
+		text     string   // Text of the property or of the current list item
+		items    []string // List items of the property
+		alt      string   // x-default entry of an rdf:Alt
+		itemLang string   // xml:lang of the current list item
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if field != "" {
+				depth++
+				if t.Name == (xml.Name{Space: nsRDF, Local: "li"}) {
+					text, itemLang = "", ""
+					for _, attr := range t.Attr {
+						if attr.Name.Local == "lang" {
+							itemLang = attr.Value
+						}
+					}
+				}
+				continue
+			}
+			if name, ok := xmpProperties[t.Name]; ok {
+				field, depth, text, items, alt = name, 0, "", nil, ""
+				continue
+			}
+			if t.Name == (xml.Name{Space: nsRDF, Local: "Description"}) {
+				for _, attr := range t.Attr {
+					if name, ok := xmpProperties[attr.Name]; ok {
+						set(name, []string{attr.Value})
+					}
+				}
+			}
+		case xml.CharData:
+			if field != "" {
+				text += string(t)
+			}
+		case xml.EndElement:
+			if field == "" {
+				continue
+			}
+			if depth > 0 {
+				depth--
+				if t.Name == (xml.Name{Space: nsRDF, Local: "li"}) {
+					items = append(items, text)
+					if itemLang == "x-default" {
+						alt = text
+					}
+				}
+				continue
+			}
+			switch {
+			case alt != "":
+				set(field, []string{alt})
+			case items != nil:
+				if field == "Title" || field == "Description" {
+					items = items[:1] // rdf:Alt without x-default, the first language
+				}
+				set(field, items)
+			default:
+				set(field, []string{text})
+			}
+			field = ""
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package scripts
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// xmpPacket wraps rdf:Description content in an XMP packet
+func xmpPacket(description string) string {
+	return `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:lr="http://ns.adobe.com/lightroom/1.0/"
+    ` + description + `
+ </rdf:RDF>
+</x:xmpmeta>`
+}
+
+// withEmbeddedXMP returns a JPEG with packet in an APP1 segment after the start of image
+func withEmbeddedXMP(t *testing.T, jpeg []byte, packet string) []byte {
+	payload := append(append([]byte{}, jpegXMPHeader...), packet...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(payload)))
+	assert.Equal(t, []byte{0xFF, 0xD8}, jpeg[:2])
+	out := append([]byte{}, jpeg[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, jpeg[2:]...)
+}
+
+// TestParseXMP tests reading properties written as attributes and as elements
+func TestParseXMP(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+		want   map[string]interface{}
+	}{
+		{
+			"darktable attributes",
+			xmpPacket(`xmp:Rating="3" xmp:Label="Red"/>`),
+			map[string]interface{}{"Rating": 3, "Label": "Red"},
+		},
+		{
+			"Lightroom elements",
+			xmpPacket(`xmp:Rating="5">
+   <dc:title><rdf:Alt>
+     <rdf:li xml:lang="de">Hafen</rdf:li>
+     <rdf:li xml:lang="x-default">Harbour</rdf:li>
+   </rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="en">Boats at dawn</rdf:li></rdf:Alt></dc:description>
+   <dc:subject><rdf:Bag><rdf:li>travel</rdf:li><rdf:li>sea</rdf:li></rdf:Bag></dc:subject>
+   <lr:hierarchicalSubject><rdf:Bag><rdf:li>Places|Australia|Sydney</rdf:li></rdf:Bag></lr:hierarchicalSubject>
+  </rdf:Description>`),
+			map[string]interface{}{
+				"Title":               "Harbour",
+				"Description":         "Boats at dawn",
+				"Subject":             []string{"travel", "sea"},
+				"HierarchicalSubject": "Places|Australia|Sydney",
+				"Rating":              5,
+			},
+		},
+		{
+			"Empty values are left out",
+			xmpPacket(`xmp:Label="">
+   <dc:subject><rdf:Bag><rdf:li> </rdf:li></rdf:Bag></dc:subject>
+   <xmp:Rating>-1</xmp:Rating>
+  </rdf:Description>`),
+			map[string]interface{}{"Rating": -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := parseXMP([]byte(tt.packet))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, fields)
+		})
+	}
+
+	_, err := parseXMP([]byte(`<x:xmpmeta><rdf:RDF>`))
+	assert.Error(t, err)
+}
+
+// TestExtractMetadata tests the precedence of a sidecar over embedded XMP over EXIF
+func TestExtractMetadata(t *testing.T) {
+	jpeg, err := os.ReadFile(filepath.Join("testdata", "exif", "nikon_z6.jpg"))
+	assert.NoError(t, err)
+	previous := CurrentExifExtractor
+	CurrentExifExtractor = ExifExtractorGoExif
+	t.Cleanup(func() { CurrentExifExtractor = previous })
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "DSC_2025-11-09_001.jpg")
+	embedded := xmpPacket(`xmp:Rating="2">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Embedded title</rdf:li></rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">Embedded caption</rdf:li></rdf:Alt></dc:description>
+  </rdf:Description>`)
+	assert.NoError(t, os.WriteFile(path, withEmbeddedXMP(t, jpeg, embedded), 0644))
+
+	exifData, width, height, dateTaken, err := ExtractMetadata(t.Context(), path)
+	assert.NoError(t, err)
+	assert.Equal(t, 24, width)
+	assert.Equal(t, 16, height)
+	assert.Equal(t, "2025-11-09", dateTaken.Format("2006-01-02"))
+	assert.Equal(t, 2, exifData["Rating"], "embedded XMP wins over the EXIF rating")
+	assert.Equal(t, "Embedded title", exifData["Title"])
+	assert.Equal(t, "NIKON Z 6_2", exifData["Model"])
+
+	for _, sidecar := range []string{path + ".xmp", filepath.Join(dir, "DSC_2025-11-09_001.xmp")} {
+		t.Run(filepath.Base(sidecar), func(t *testing.T) {
+			assert.NoError(t, os.WriteFile(sidecar, []byte(xmpPacket(`xmp:Rating="5">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sidecar title</rdf:li></rdf:Alt></dc:title>
+  </rdf:Description>`)), 0644))
+			defer os.Remove(sidecar)
+
+			assert.Equal(t, sidecar, XMPSidecar(path))
+			exifData, _, _, _, err := ExtractMetadata(t.Context(), path)
+			assert.NoError(t, err)
+			assert.Equal(t, 5, exifData["Rating"])
+			assert.Equal(t, "Sidecar title", exifData["Title"])
+			assert.Equal(t, "Embedded caption", exifData["Description"], "fields missing from the sidecar keep the embedded value")
+		})
+	}
+}
+
+// TestMergeXMP tests that XMP keywords replace the IPTC keywords
+func TestMergeXMP(t *testing.T) {
+	exifData := map[string]interface{}{"Keywords": []string{"old"}, "Rating": 1, "Make": "Apple"}
+	merged := mergeXMP(exifData, map[string]interface{}{"Subject": "new", "Rating": 4})
+	assert.Equal(t, map[string]interface{}{"Subject": "new", "Rating": 4, "Make": "Apple"}, merged)
+	assert.Equal(t, map[string]interface{}{"Rating": 4}, mergeXMP(nil, map[string]interface{}{"Rating": 4}))
+}
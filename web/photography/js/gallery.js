@@ -737,9 +737,13 @@ async function loadGallery() {
     }
 
     try {
-        // const response = await fetch("photos.json");
+        // The local preview server serves the photos.json written by the sync script,
+        // including builds made with `-storage local`
+        const isLocalPreview = ["localhost", "127.0.0.1"].includes(window.location.hostname);
         const response = await fetch(
-            "https://cdn-photography-img-vincent.chyu.org/pages/photos.json"
+            isLocalPreview
+                ? "photos.json"
+                : "https://cdn-photography-img-vincent.chyu.org/pages/photos.json"
         );
         if (!response.ok) {
             throw new Error(`HTTP error! status: ${response.status}`);
@@ -1417,6 +1421,8 @@ function renderWaterfallLayout(container, photos, year, month, galleryItems) {
         galleryItems.push({
             src: photo.path,
             thumb: photo.thumbnail,
+            srcset: buildSrcset(photo, "webp"),
+            sizes: "100vw",
             caption: photo.alt || "",
             exif: photo.exif, // Store full EXIF object
             filename: photo.filename || "",
@@ -1448,6 +1454,20 @@ function renderWaterfallLayout(container, photos, year, month, galleryItems) {
     container.appendChild(waterfallContainer);
 }
 
+/**
+ * Build a srcset value from the responsive renditions of a photo in one format.
+ * Renditions without a format predate format negotiation and are WebP.
+ */
+function buildSrcset(photo, format) {
+    if (!Array.isArray(photo.srcset)) {
+        return "";
+    }
+    return photo.srcset
+        .filter((source) => (source.format || "webp") === format)
+        .map((source) => `${source.url} ${source.width}w`)
+        .join(", ");
+}
+
 function createPhotoCard(photo, year, month) {
     const wrapper = document.createElement("div");
     wrapper.className = "photo-card relative"; // Ensure relative positioning for anchors
@@ -1463,6 +1483,16 @@ function createPhotoCard(photo, year, month) {
 
     const exifData = photo.exif ? JSON.stringify(photo.exif) : "";
     const filename = photo.filename || "";
+    // Tiles are one column wide, matching the breakpoints in getColumnCount
+    const sizes = "(min-width: 1200px) 20vw, (min-width: 768px) 33vw, 50vw";
+    // Modern formats as <source> elements, JPEG (or WebP for older entries) on the <img> itself
+    const sourcesHtml = ["avif", "webp"]
+        .map((format) => [format, buildSrcset(photo, format)])
+        .filter(([, srcset]) => srcset)
+        .map(([format, srcset]) => `<source type="image/${format}" srcset="${srcset}" sizes="${sizes}" />`)
+        .join("");
+    const fallbackSrcset = buildSrcset(photo, "jpeg") || buildSrcset(photo, "webp");
+    const srcsetAttrs = fallbackSrcset ? `srcset="${fallbackSrcset}" sizes="${sizes}"` : "";
 
     // Generate hidden anchors if markers exist
     let anchorsHtml = "";
@@ -1489,13 +1519,17 @@ function createPhotoCard(photo, year, month) {
          data-exif='${exifData.replace(/'/g, "&apos;")}'
          data-filename="${filename}"
          class="block w-full h-full gallery-item">
-        <img
-          alt="${photo.alt || ""}"
-          width="${width}"
-          height="${height}"
-          class="block w-full h-full object-cover object-center opacity-0 animate-fade-in transition duration-300 img-hover-zoom img-loading rounded-lg"
-          src="${photo.thumbnail}"
-        />
+        <picture class="block w-full h-full">
+          ${sourcesHtml}
+          <img
+            alt="${photo.alt || ""}"
+            width="${width}"
+            height="${height}"
+            class="block w-full h-full object-cover object-center opacity-0 animate-fade-in transition duration-300 img-hover-zoom img-loading rounded-lg"
+            src="${photo.thumbnail}"
+            ${srcsetAttrs}
+          />
+        </picture>
       </a>
     </div>
   `;